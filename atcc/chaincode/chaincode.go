@@ -6,26 +6,19 @@ import (
 	"net/http"
 	"time"
 
+	"crosschain/types"
+
 	"github.com/gocarina/gocsv"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
-// SmartContract provides functions for managing an Asset
+// SmartContract provides functions for managing an types.Asset
 type SmartContract struct {
 	contractapi.Contract
 }
 
-// Asset describes basic details of what makes up a simple asset
-type Asset struct {
-	ID             string `json:"ID"`
-	Color          string `json:"color"`
-	Size           int    `json:"size"`
-	Owner          string `json:"owner"`
-	AppraisedValue int    `json:"appraisedValue"`
-}
-
-func importCSVfromURL(fileURL string, numRows int) ([]Asset, error) {
+func importCSVfromURL(fileURL string, numRows int) ([]types.Asset, error) {
 	// // Disable certificate verification
 	// http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 
@@ -35,7 +28,7 @@ func importCSVfromURL(fileURL string, numRows int) ([]Asset, error) {
 	}
 	defer response.Body.Close()
 
-	var assets []Asset
+	var assets []types.Asset
 	if err := gocsv.Unmarshal(response.Body, &assets); err != nil {
 		return nil, fmt.Errorf("failed to parse CSV data: %v", err)
 	}
@@ -44,24 +37,27 @@ func importCSVfromURL(fileURL string, numRows int) ([]Asset, error) {
 	if numRows > 0 && numRows < len(assets) {
 		assets = assets[:numRows]
 	}
-
+	for i := range assets {
+		assets[i].SchemaVersion = types.AssetSchemaVersion
+	}
 
 	return assets, nil
 }
 
-func generateAssets(numAssets int) []Asset {
-	var assets []Asset
+func generateAssets(numAssets int) []types.Asset {
+	var assets []types.Asset
 	for i := 1; i <= numAssets; i++ {
-		asset := Asset{
+		asset := types.Asset{
+			SchemaVersion:  types.AssetSchemaVersion,
 			ID:             fmt.Sprintf("pc%d", i),
-			Color:          "blue", // Example color
-			Size:           5,      // Example size
+			Color:          "blue",  // Example color
+			Size:           5,       // Example size
 			Owner:          "Owner", // Example owner
-			AppraisedValue: 3000,   // Example appraised value
+			AppraisedValue: 3000,    // Example appraised value
 		}
 		assets = append(assets, asset)
 	}
-	fmt.Printf("Successfully generated %s records\n", numAssets)
+	fmt.Printf("Successfully generated %d records\n", numAssets)
 	return assets
 }
 
@@ -103,7 +99,8 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 		return fmt.Errorf("the asset %s already exists", id)
 	}
 
-	asset := Asset{
+	asset := types.Asset{
+		SchemaVersion:  types.AssetSchemaVersion,
 		ID:             id,
 		Color:          color,
 		Size:           size,
@@ -119,7 +116,7 @@ func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface,
 }
 
 // ReadAsset returns the asset stored in the world state with given id.
-func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*Asset, error) {
+func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*types.Asset, error) {
 	startTime := time.Now()
 	assetJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
@@ -135,8 +132,7 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, i
 		return nil, fmt.Errorf("the asset %s does not exist", id)
 	}
 
-	var asset Asset
-	err = json.Unmarshal(assetJSON, &asset)
+	asset, err := types.DecodeAsset(assetJSON)
 	if err != nil {
 		duration := time.Since(startTime)
 		fmt.Printf("UNMARSHAL ERR!! Time taken for query for id (%s): %s\n", id, duration)
@@ -147,7 +143,7 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, i
 	duration := time.Since(startTime)
 	fmt.Printf("Time taken for query for id (%s): %s\n", id, duration)
 
-	return &asset, nil
+	return asset, nil
 }
 
 // UpdateAsset updates an existing asset in the world state with provided parameters.
@@ -161,7 +157,8 @@ func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface,
 	}
 
 	// overwriting original asset with new asset
-	asset := Asset{
+	asset := types.Asset{
+		SchemaVersion:  types.AssetSchemaVersion,
 		ID:             id,
 		Color:          color,
 		Size:           size,
@@ -216,7 +213,7 @@ func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterfac
 }
 
 // GetAllAssets returns all assets found in world state
-func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*Asset, error) {
+func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*types.Asset, error) {
 	// range query with empty string for startKey and endKey does an
 	// open-ended query of all assets in the chaincode namespace.
 	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
@@ -225,20 +222,52 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 	}
 	defer resultsIterator.Close()
 
-	var assets []*Asset
+	var assets []*types.Asset
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
 
-		var asset Asset
-		err = json.Unmarshal(queryResponse.Value, &asset)
+		asset, err := types.DecodeAsset(queryResponse.Value)
 		if err != nil {
 			return nil, err
 		}
-		assets = append(assets, &asset)
+		assets = append(assets, asset)
 	}
 
 	return assets, nil
-}
\ No newline at end of file
+}
+
+// GetAllAssetsWithPagination returns one page of assets in key order.
+// Pass the returned bookmark to get the next page; pagination only works in evaluated (query) transactions.
+func (s *SmartContract) GetAllAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*types.AssetPage, error) {
+	err := types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &types.AssetPage{Records: []*types.Asset{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := types.DecodeAsset(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+
+	page.FetchedCount = responseMetadata.GetFetchedRecordsCount()
+	page.Bookmark = responseMetadata.GetBookmark()
+	return page, nil
+}
@@ -2,7 +2,10 @@ module atcc
 
 go 1.22.2
 
-require github.com/hyperledger/fabric-contract-api-go v1.2.2
+require (
+	crosschain/types v0.0.0-00010101000000-000000000000
+	github.com/hyperledger/fabric-contract-api-go v1.2.2
+)
 
 require (
 	github.com/go-openapi/jsonpointer v0.20.0 // indirect
@@ -32,3 +35,5 @@ require (
 	google.golang.org/protobuf v1.31.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace crosschain/types => ../crosschain/types
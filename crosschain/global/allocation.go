@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Composite key object types of policy ID allocation
+const (
+	policySequenceIndex   = "policyseq~hospital" // hospitalID -> last sequence number allocated
+	policyAllocationIndex = "policyalloc~id"     // policyID -> types.PolicyAllocation
+)
+
+// PolicyIDLocation is one regional chaincode holding a policy ID
+type PolicyIDLocation struct {
+	Region         string `json:"region,omitempty" metadata:",optional"`
+	RegionalCCName string `json:"regionalCCName"`
+	Channel        string `json:"channel"`
+}
+
+// Reasons ScanPolicyIDConflicts reports a policy ID for
+const (
+	ConflictDuplicated  = "duplicated"  // held by more than one regional chaincode
+	ConflictUnallocated = "unallocated" // never allocated by AllocatePolicyID or recorded by RegisterSeedPolicyIDs
+	ConflictWrongRegion = "wrongRegion" // held outside the region it was allocated for
+)
+
+// PolicyIDConflict is a policy ID held by more than one regional chaincode, or by a regional
+// chaincode globalcc did not allocate it to
+type PolicyIDConflict struct {
+	PolicyID  string              `json:"policyID"`
+	Reason    string              `json:"reason"`
+	Locations []*PolicyIDLocation `json:"locations"`
+}
+
+// AllocatePolicyID issues the next policy ID of an active hospital, namespaced by the hospital's
+// region so that it is unique across regions, and registers the policy at the hospital.
+// Regional CreateAsset only accepts IDs allocated here. The caller must belong to the hospital's
+// MSP or to the governing org.
+func (s *SmartContract) AllocatePolicyID(ctx contractapi.TransactionContextInterface, hospitalID string) (string, error) {
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return "", err
+	}
+	err = checkRoutable(hospital)
+	if err != nil {
+		return "", err
+	}
+	err = requireHospitalOrg(ctx, hospital)
+	if err != nil {
+		return "", err
+	}
+	if hospital.Region == "" {
+		return "", fmt.Errorf("the hospital %s has no region to allocate policy IDs in", hospitalID)
+	}
+	if strings.Contains(hospital.Region+hospitalID, types.PolicyIDSeparator) {
+		return "", fmt.Errorf("region and hospital IDs that allocate policy IDs may not contain %q", types.PolicyIDSeparator)
+	}
+
+	sequenceKey, err := ctx.GetStub().CreateCompositeKey(policySequenceIndex, []string{hospitalID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create composite key: %v", err)
+	}
+	sequenceBytes, err := ctx.GetStub().GetState(sequenceKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from world state: %v", err)
+	}
+	sequence := 0
+	if sequenceBytes != nil {
+		sequence, err = strconv.Atoi(string(sequenceBytes))
+		if err != nil {
+			return "", fmt.Errorf("invalid policy sequence for hospital %s: %v", hospitalID, err)
+		}
+	}
+	sequence++
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return "", err
+	}
+	allocation := types.PolicyAllocation{
+		PolicyID:    types.NewPolicyID(hospital.Region, hospitalID, sequence),
+		HospitalID:  hospitalID,
+		Region:      hospital.Region,
+		TxID:        ctx.GetStub().GetTxID(),
+		AllocatedAt: now,
+	}
+
+	err = ctx.GetStub().PutState(sequenceKey, []byte(strconv.Itoa(sequence)))
+	if err != nil {
+		return "", fmt.Errorf("failed to put to world state. %v", err)
+	}
+	err = putAllocation(ctx, &allocation)
+	if err != nil {
+		return "", err
+	}
+	err = s.RegisterPolicy(ctx, allocation.PolicyID, hospitalID)
+	if err != nil {
+		return "", err
+	}
+
+	return allocation.PolicyID, nil
+}
+
+// RegisterSeedPolicyIDs records an allocation, under types.SeedHospitalID, for every policy ID the
+// regional chaincode of a region seeded with InitLedger, so that ScanPolicyIDConflicts accounts for
+// them. IDs already recorded are skipped. It returns the number of IDs recorded. Only the governing
+// org may run it, once the region's InitLedger has committed.
+func (s *SmartContract) RegisterSeedPolicyIDs(ctx contractapi.TransactionContextInterface, region string) (int, error) {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return 0, err
+	}
+	hospitals, err := s.GetAllAssets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var route *regionalRoute
+	for _, hospital := range hospitals {
+		if hospital.Region == region {
+			route, err = routeFor(ctx, hospital)
+			if err != nil {
+				return 0, err
+			}
+			break
+		}
+	}
+	if route == nil {
+		return 0, fmt.Errorf("no hospital is registered in region %s", region)
+	}
+
+	payload, err := invokeRegional(ctx, route, "ListAssetIDs")
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	err = json.Unmarshal(payload, &ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode the asset IDs of %s: %v", route.ChaincodeName, err)
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	recorded := 0
+	for _, id := range ids {
+		idRegion, hospitalID, ok := types.ParsePolicyID(id)
+		if !ok || idRegion != region || hospitalID != types.SeedHospitalID {
+			continue
+		}
+		existing, err := getAllocation(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		err = putAllocation(ctx, &types.PolicyAllocation{
+			PolicyID:    id,
+			HospitalID:  types.SeedHospitalID,
+			Region:      region,
+			TxID:        ctx.GetStub().GetTxID(),
+			AllocatedAt: now,
+		})
+		if err != nil {
+			return 0, err
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+// GetPolicyAllocation returns the allocation record of a policy ID issued by AllocatePolicyID
+func (s *SmartContract) GetPolicyAllocation(ctx contractapi.TransactionContextInterface, policyID string) (*types.PolicyAllocation, error) {
+	allocation, err := getAllocation(ctx, policyID)
+	if err != nil {
+		return nil, err
+	}
+	if allocation == nil {
+		return nil, fmt.Errorf("the policy ID %s was not allocated", policyID)
+	}
+	return allocation, nil
+}
+
+// ScanPolicyIDConflicts lists the policy IDs held by more than one regional chaincode, such as the
+// bare pcN IDs every region seeded before seeds were namespaced, and those held by a regional
+// chaincode that globalcc has no allocation for in its region. It asks the regional chaincode of
+// every registered hospital, whatever its status, for its asset IDs, so it should be evaluated
+// rather than submitted. Only the governing org and compliance reviewers may run it.
+func (s *SmartContract) ScanPolicyIDConflicts(ctx contractapi.TransactionContextInterface) ([]*PolicyIDConflict, error) {
+	err := requireReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hospitals, err := s.GetAllAssets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// each regional chaincode is asked once, however many hospitals it serves
+	locations := make(map[regionalRoute]*PolicyIDLocation)
+	var routes []regionalRoute
+	for _, hospital := range hospitals {
+		route, err := routeFor(ctx, hospital)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := locations[*route]; ok {
+			continue
+		}
+		locations[*route] = &PolicyIDLocation{Region: hospital.Region, RegionalCCName: route.ChaincodeName, Channel: route.Channel}
+		routes = append(routes, *route)
+	}
+
+	holders := make(map[string][]*PolicyIDLocation)
+	for i := range routes {
+		payload, err := invokeRegional(ctx, &routes[i], "ListAssetIDs")
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		err = json.Unmarshal(payload, &ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode the asset IDs of %s: %v", routes[i].ChaincodeName, err)
+		}
+		for _, id := range ids {
+			holders[id] = append(holders[id], locations[routes[i]])
+		}
+	}
+
+	conflicts := []*PolicyIDConflict{}
+	for id, held := range holders {
+		if len(held) > 1 {
+			conflicts = append(conflicts, &PolicyIDConflict{PolicyID: id, Reason: ConflictDuplicated, Locations: held})
+			continue
+		}
+		allocation, err := getAllocation(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if allocation == nil {
+			conflicts = append(conflicts, &PolicyIDConflict{PolicyID: id, Reason: ConflictUnallocated, Locations: held})
+		} else if allocation.Region != held[0].Region {
+			conflicts = append(conflicts, &PolicyIDConflict{PolicyID: id, Reason: ConflictWrongRegion, Locations: held})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].PolicyID < conflicts[j].PolicyID })
+	return conflicts, nil
+}
+
+// getAllocation returns the allocation record of policyID, or nil when it has none
+func getAllocation(ctx contractapi.TransactionContextInterface, policyID string) (*types.PolicyAllocation, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(policyAllocationIndex, []string{policyID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	allocationJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if allocationJSON == nil {
+		return nil, nil
+	}
+
+	var allocation types.PolicyAllocation
+	err = json.Unmarshal(allocationJSON, &allocation)
+	if err != nil {
+		return nil, err
+	}
+	return &allocation, nil
+}
+
+// putAllocation stores the allocation record of a policy ID
+func putAllocation(ctx contractapi.TransactionContextInterface, allocation *types.PolicyAllocation) error {
+	allocationJSON, err := json.Marshal(allocation)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(policyAllocationIndex, []string{allocation.PolicyID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+
+	err = ctx.GetStub().PutState(key, allocationJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+	return nil
+}
+
+// requireHospitalOrg fails unless the caller belongs to the hospital's MSP or to the governing org
+func requireHospitalOrg(ctx contractapi.TransactionContextInterface, hospital *types.GlobalAsset) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	if mspID != governingMSPID() && (hospital.MSPID == "" || mspID != hospital.MSPID) {
+		return fmt.Errorf("only %s or the hospital's org may allocate or register policies for %s, caller is %s", governingMSPID(), hospital.HospitalID, mspID)
+	}
+	return nil
+}
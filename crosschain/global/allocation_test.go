@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestAllocatePolicyID(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	hospitalOrg := testIdentity(t, "Org2MSP")
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", MSPID: "Org2MSP", Region: "region1"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP2", RegionalCCName: "regionalCC2", MSPID: "Org2MSP", Region: "region2", Status: types.HospitalSuspended})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP3", RegionalCCName: "regionalCC3", MSPID: "Org2MSP"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP:4", RegionalCCName: "regionalCC1", MSPID: "Org2MSP", Region: "region1"})
+
+	for i, creator := range [][]byte{hospitalOrg, hospitalOrg, governingOrg} {
+		response := invoke(stub, creator, "AllocatePolicyID", "HP1")
+		if response.Status != 200 {
+			t.Fatalf("AllocatePolicyID failed: %s", response.Message)
+		}
+		expected := types.NewPolicyID("region1", "HP1", i+1)
+		if string(response.Payload) != expected {
+			t.Fatalf("expected %s, got %s", expected, response.Payload)
+		}
+	}
+
+	policyID := types.NewPolicyID("region1", "HP1", 1)
+	response := invoke(stub, testIdentity(t, "Org3MSP"), "GetPolicyAllocation", policyID)
+	var allocation types.PolicyAllocation
+	if err := json.Unmarshal(response.Payload, &allocation); response.Status != 200 || err != nil {
+		t.Fatalf("GetPolicyAllocation failed: %d %s", response.Status, response.Message)
+	}
+	if allocation.PolicyID != policyID || allocation.HospitalID != "HP1" || allocation.Region != "region1" || allocation.TxID == "" || allocation.AllocatedAt == "" {
+		t.Errorf("unexpected allocation %+v", allocation)
+	}
+	response = invoke(stub, hospitalOrg, "LocatePolicy", policyID)
+	var locator PolicyLocator
+	if err := json.Unmarshal(response.Payload, &locator); response.Status != 200 || err != nil || locator.HospitalID != "HP1" {
+		t.Errorf("expected the allocated policy to be located at HP1, got %d %s %s", response.Status, response.Message, response.Payload)
+	}
+	if response := invoke(stub, hospitalOrg, "GetPolicyAllocation", types.NewPolicyID("region1", "HP1", 9)); response.Status == 200 {
+		t.Errorf("GetPolicyAllocation found a policy ID that was never allocated")
+	}
+
+	for _, test := range []struct {
+		name       string
+		creator    []byte
+		hospitalID string
+		message    string
+	}{
+		{"another org", testIdentity(t, "Org3MSP"), "HP1", "caller is Org3MSP"},
+		{"an unknown hospital", governingOrg, "HP9", "does not exist"},
+		{"a suspended hospital", hospitalOrg, "HP2", types.HospitalSuspended},
+		{"a hospital without a region", hospitalOrg, "HP3", "no region"},
+		{"a hospital ID with the separator", hospitalOrg, "HP:4", "may not contain"},
+	} {
+		response := invoke(stub, test.creator, "AllocatePolicyID", test.hospitalID)
+		if response.Status == 200 || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: expected AllocatePolicyID to be refused with %q, got %d %s", test.name, test.message, response.Status, response.Message)
+		}
+	}
+}
+
+func TestRegisterHospitalRefusesSeedHospitalID(t *testing.T) {
+	stub := newTestStub(t)
+
+	response := invoke(stub, testIdentity(t, DefaultGoverningMSPID), "RegisterHospital", types.SeedHospitalID, "Seed", "Org2MSP", "region1", "regionalCC1", "")
+	if response.Status == 200 || !strings.Contains(response.Message, "reserved") {
+		t.Errorf("expected the seed hospital ID to be refused, got %d %s", response.Status, response.Message)
+	}
+}
+
+// conflictReasons lists each conflict as its policy ID and reason
+func conflictReasons(conflicts []*PolicyIDConflict) []string {
+	var reported []string
+	for _, conflict := range conflicts {
+		reported = append(reported, conflict.PolicyID+" "+conflict.Reason)
+	}
+	return reported
+}
+
+func TestScanPolicyIDConflicts(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP2", RegionalCCName: "regionalCC2", Region: "region2"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP3", RegionalCCName: "regionalCC1", Region: "region1", Status: types.HospitalSuspended})
+	regional1 := deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	regional2 := deployFakeRegional(stub, "regionalCC2", DefaultChannel)
+	for _, hospitalID := range []string{"HP1", "HP2"} {
+		if response := invoke(stub, governingOrg, "AllocatePolicyID", hospitalID); response.Status != 200 {
+			t.Fatalf("AllocatePolicyID failed: %s", response.Message)
+		}
+	}
+	seeded := types.NewPolicyID("region1", types.SeedHospitalID, 1)
+	misplaced := types.NewPolicyID("region2", "HP2", 1)
+	regional1.ids = []string{"pc5", seeded, types.NewPolicyID("region1", "HP1", 1), misplaced}
+	regional2.ids = []string{"pc5"}
+
+	scan := func() []*PolicyIDConflict {
+		t.Helper()
+		response := invoke(stub, governingOrg, "ScanPolicyIDConflicts")
+		if response.Status != 200 {
+			t.Fatalf("ScanPolicyIDConflicts failed: %s", response.Message)
+		}
+		var conflicts []*PolicyIDConflict
+		if err := json.Unmarshal(response.Payload, &conflicts); err != nil {
+			t.Fatal(err)
+		}
+		return conflicts
+	}
+
+	if response := invoke(stub, testIdentity(t, "Org2MSP"), "ScanPolicyIDConflicts"); response.Status == 200 {
+		t.Errorf("an org other than the governing org ran the scan")
+	}
+	conflicts := scan()
+	expected := []string{"pc5 " + ConflictDuplicated, seeded + " " + ConflictUnallocated, misplaced + " " + ConflictWrongRegion}
+	if reported := conflictReasons(conflicts); strings.Join(reported, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected conflicts %v, got %v", expected, reported)
+	}
+	// regionalCC1 is asked once although two hospitals route to it
+	if locations := conflicts[0].Locations; len(locations) != 2 || locations[0].RegionalCCName == locations[1].RegionalCCName {
+		t.Errorf("unexpected locations of pc5: %+v %+v", locations[0], locations[1])
+	}
+
+	for _, test := range []struct {
+		name    string
+		creator []byte
+		region  string
+	}{
+		{"another org", testIdentity(t, "Org2MSP"), "region1"},
+		{"an unknown region", governingOrg, "region9"},
+	} {
+		if response := invoke(stub, test.creator, "RegisterSeedPolicyIDs", test.region); response.Status == 200 {
+			t.Errorf("%s: RegisterSeedPolicyIDs succeeded", test.name)
+		}
+	}
+	for _, recorded := range []string{"1", "0"} {
+		response := invoke(stub, governingOrg, "RegisterSeedPolicyIDs", "region1")
+		if response.Status != 200 || string(response.Payload) != recorded {
+			t.Fatalf("expected RegisterSeedPolicyIDs to record %s IDs, got %d %s %s", recorded, response.Status, response.Message, response.Payload)
+		}
+	}
+	response := invoke(stub, governingOrg, "GetPolicyAllocation", seeded)
+	var allocation types.PolicyAllocation
+	if err := json.Unmarshal(response.Payload, &allocation); response.Status != 200 || err != nil || allocation.HospitalID != types.SeedHospitalID || allocation.Region != "region1" {
+		t.Errorf("unexpected seed allocation %d %s %s", response.Status, response.Message, response.Payload)
+	}
+
+	expected = []string{"pc5 " + ConflictDuplicated, misplaced + " " + ConflictWrongRegion}
+	if reported := conflictReasons(scan()); strings.Join(reported, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected conflicts %v once the seeds are recorded, got %v", expected, reported)
+	}
+}
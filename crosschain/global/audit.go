@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Composite key object types of access records. Both keys hold the full record; the timestamp
+// attribute keeps each policy's and each caller's records in time order.
+const (
+	accessByPolicyIndex = "access~policy~time~tx" // policyID, timestamp, txID
+	accessByCallerIndex = "access~caller~time~tx" // mspID, callerID, timestamp, txID
+)
+
+// Document types of access records, so CouchDB queries only match the by-policy copy
+const (
+	accessRecordDocType       = "accessRecord"
+	accessRecordCallerDocType = "accessRecordByCaller"
+)
+
+// Outcomes stored in AccessRecord.Outcome
+const (
+	AccessGranted = "granted"
+	AccessDenied  = "denied"
+	AccessFailed  = "failed"
+)
+
+// sortableTime is RFC 3339 with fixed-width nanoseconds, so timestamps sort as strings
+const sortableTime = "2006-01-02T15:04:05.000000000Z"
+
+// AccessRecord is the audit record of one audited cross-region read
+type AccessRecord struct {
+	DocType    string `json:"docType"`
+	PolicyID   string `json:"policyID"`
+	HospitalID string `json:"hospitalID"`
+	Region     string `json:"region,omitempty" metadata:",optional"`
+	MSPID      string `json:"mspID"`
+	CallerID   string `json:"callerID"`
+	TxID       string `json:"txID"`
+	Timestamp  string `json:"timestamp"`
+	Outcome    string `json:"outcome"`
+	Reason     string `json:"reason,omitempty" metadata:",optional"`
+}
+
+// AccessRecordPage is one page of access records
+type AccessRecordPage struct {
+	Records      []*AccessRecord `json:"records"`
+	FetchedCount int32           `json:"fetchedCount"`
+	Bookmark     string          `json:"bookmark"`
+}
+
+// AuditedRead is the result of AuditedReadRegionalAsset: the access record and, when granted, the policy
+type AuditedRead struct {
+	Access *AccessRecord        `json:"access"`
+	Asset  *types.RegionalAsset `json:"asset,omitempty" metadata:",optional"`
+}
+
+// AuditedReadRegionalAsset is the audited read mode: it reads a policy like ReadRegionalAsset and
+// appends an access record. It is opt-in and only leaves a record when submitted; the plain reads
+// stay unaudited. A denied or failed read still commits so that its record is kept; the outcome
+// and reason are in the returned record.
+func (s *SmartContract) AuditedReadRegionalAsset(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) (*AuditedRead, error) {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return nil, fmt.Errorf("client identity is not available")
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	callerID, err := identity.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	record := &AccessRecord{
+		DocType:    accessRecordDocType,
+		PolicyID:   policyID,
+		HospitalID: hospitalID,
+		MSPID:      mspID,
+		CallerID:   callerID,
+		TxID:       ctx.GetStub().GetTxID(),
+		Timestamp:  time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC().Format(sortableTime),
+	}
+
+	var asset *types.RegionalAsset
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err == nil {
+		record.Region = hospital.Region
+		asset, err = s.ReadRegionalAsset(ctx, policyID, hospitalID)
+	}
+	record.Outcome = accessOutcome(err)
+	if err != nil {
+		record.Reason = err.Error()
+		asset = nil
+	}
+
+	err = putAccessRecord(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditedRead{Access: record, Asset: asset}, nil
+}
+
+// GetAccessRecordsByPolicy returns one page of a policy's access records, oldest first
+func (s *SmartContract) GetAccessRecordsByPolicy(ctx contractapi.TransactionContextInterface, policyID string, pageSize int32, bookmark string) (*AccessRecordPage, error) {
+	return getAccessRecordPage(ctx, accessByPolicyIndex, []string{policyID}, pageSize, bookmark)
+}
+
+// GetAccessRecordsByCaller returns one page of a caller's access records, oldest first.
+// callerID is the client ID as returned by the client identity library.
+func (s *SmartContract) GetAccessRecordsByCaller(ctx contractapi.TransactionContextInterface, mspID string, callerID string, pageSize int32, bookmark string) (*AccessRecordPage, error) {
+	return getAccessRecordPage(ctx, accessByCallerIndex, []string{mspID, callerID}, pageSize, bookmark)
+}
+
+// QueryAccessRecords returns one page of access records between from and to (RFC 3339, inclusive),
+// optionally narrowed to a policy and a caller. Empty arguments match everything. Needs CouchDB.
+func (s *SmartContract) QueryAccessRecords(ctx contractapi.TransactionContextInterface, policyID string, mspID string, callerID string, from string, to string, pageSize int32, bookmark string) (*AccessRecordPage, error) {
+	err := requireReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	timeRange := map[string]string{"$gte": ""}
+	if from != "" {
+		timeRange["$gte"], err = toSortableTime(from)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if to != "" {
+		timeRange["$lte"], err = toSortableTime(to)
+		if err != nil {
+			return nil, err
+		}
+	}
+	selector := map[string]interface{}{"docType": accessRecordDocType, "timestamp": timeRange}
+	if policyID != "" {
+		selector["policyID"] = policyID
+	}
+	if mspID != "" {
+		selector["mspID"] = mspID
+	}
+	if callerID != "" {
+		selector["callerID"] = callerID
+	}
+	queryString, err := json.Marshal(map[string]interface{}{
+		"selector":  selector,
+		"sort":      []map[string]string{{"docType": "asc"}, {"timestamp": "asc"}},
+		"use_index": []string{"_design/indexAccessRecordDoc", "indexAccessRecord"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructAccessRecordPage(resultsIterator, responseMetadata)
+}
+
+func getAccessRecordPage(ctx contractapi.TransactionContextInterface, index string, attributes []string, pageSize int32, bookmark string) (*AccessRecordPage, error) {
+	err := requireReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, attributes, pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructAccessRecordPage(resultsIterator, responseMetadata)
+}
+
+func constructAccessRecordPage(resultsIterator shim.StateQueryIteratorInterface, responseMetadata *peer.QueryResponseMetadata) (*AccessRecordPage, error) {
+	page := &AccessRecordPage{Records: []*AccessRecord{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record AccessRecord
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		record.DocType = accessRecordDocType
+		page.Records = append(page.Records, &record)
+	}
+
+	page.FetchedCount = responseMetadata.GetFetchedRecordsCount()
+	page.Bookmark = responseMetadata.GetBookmark()
+	return page, nil
+}
+
+// putAccessRecord stores the record under its by-policy and by-caller keys
+func putAccessRecord(ctx contractapi.TransactionContextInterface, record *AccessRecord) error {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(accessByPolicyIndex, []string{record.PolicyID, record.Timestamp, record.TxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to world state. %v", err)
+	}
+
+	callerRecord := *record
+	callerRecord.DocType = accessRecordCallerDocType
+	callerJSON, err := json.Marshal(callerRecord)
+	if err != nil {
+		return err
+	}
+	key, err = ctx.GetStub().CreateCompositeKey(accessByCallerIndex, []string{record.MSPID, record.CallerID, record.Timestamp, record.TxID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	return ctx.GetStub().PutState(key, callerJSON)
+}
+
+// accessOutcome classifies a read error. The regional chaincode reports refusals as an
+// AccessError whose message is a JSON object with a code.
+func accessOutcome(err error) string {
+	if err == nil {
+		return AccessGranted
+	}
+	if _, ok := err.(*HospitalUnavailableError); ok {
+		return AccessDenied
+	}
+
+	message := err.Error()
+	if start := strings.Index(message, "{"); start >= 0 {
+		var accessError struct {
+			Code string `json:"code"`
+		}
+		if json.Unmarshal([]byte(message[start:]), &accessError) == nil && accessError.Code != "" {
+			return AccessDenied
+		}
+	}
+	return AccessFailed
+}
+
+// requireReviewer fails unless the caller belongs to the governing org or carries the compliance attribute
+func requireReviewer(ctx contractapi.TransactionContextInterface) error {
+	if ctx.GetClientIdentity() == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	reviewer, err := isReviewer(ctx)
+	if err != nil {
+		return err
+	}
+	if !reviewer {
+		return fmt.Errorf("only the governing org or compliance reviewers may read access records")
+	}
+	return nil
+}
+
+func toSortableTime(value string) (string, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid time %q, expected RFC 3339: %v", value, err)
+	}
+	return t.UTC().Format(sortableTime), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// accessRecordsOf returns the access records stored under index for attributes
+func accessRecordsOf(t *testing.T, stub *shimtest.MockStub, index string, attributes ...string) []*AccessRecord {
+	t.Helper()
+
+	stub.MockTransactionStart("records")
+	defer stub.MockTransactionEnd("records")
+	iterator, err := stub.GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iterator.Close()
+	var records []*AccessRecord
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var record AccessRecord
+		if err := json.Unmarshal(entry.Value, &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, &record)
+	}
+	return records
+}
+
+func TestAuditedReadRegionalAsset(t *testing.T) {
+	stub := newTestStub(t)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	doctor := testIdentity(t, "Org2MSP")
+
+	// the plain read stays as it was and leaves no record
+	if response := invoke(stub, doctor, "ReadRegionalAsset", "pc1", "HP1"); response.Status != 200 {
+		t.Fatalf("ReadRegionalAsset failed: %s", response.Message)
+	}
+	if records := accessRecordsOf(t, stub, accessByPolicyIndex, "pc1"); len(records) != 0 {
+		t.Fatalf("the unaudited read left %d access records", len(records))
+	}
+
+	response := invoke(stub, doctor, "AuditedReadRegionalAsset", "pc1", "HP1")
+	if response.Status != 200 {
+		t.Fatalf("AuditedReadRegionalAsset failed: %s", response.Message)
+	}
+	var read AuditedRead
+	if err := json.Unmarshal(response.Payload, &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.Asset == nil || read.Asset.ID != "pc1" || read.Access.Outcome != AccessGranted || read.Access.Region != "region1" || read.Access.MSPID != "Org2MSP" {
+		t.Fatalf("unexpected audited read %s", response.Payload)
+	}
+
+	response = invoke(stub, doctor, "AuditedReadRegionalAsset", "pc2", "HP9")
+	read = AuditedRead{}
+	if err := json.Unmarshal(response.Payload, &read); err != nil || response.Status != 200 {
+		t.Fatalf("a failed audited read must still commit its record, got %d %s", response.Status, response.Message)
+	}
+	if read.Asset != nil || read.Access.Outcome != AccessFailed || read.Access.Reason == "" {
+		t.Fatalf("unexpected failed read %s", response.Payload)
+	}
+
+	if records := accessRecordsOf(t, stub, accessByPolicyIndex, "pc1"); len(records) != 1 || records[0].HospitalID != "HP1" {
+		t.Fatalf("expected one record of pc1, got %d", len(records))
+	}
+	records := accessRecordsOf(t, stub, accessByCallerIndex, "Org2MSP", read.Access.CallerID)
+	if len(records) != 2 || records[0].DocType != accessRecordCallerDocType {
+		t.Fatalf("expected both reads in the caller's records, got %d", len(records))
+	}
+}
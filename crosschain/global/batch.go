@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// batchGroup holds the items of a batch read that go to the same regional chaincode and channel
+type batchGroup struct {
+	route     *regionalRoute
+	policyIDs []string         // unique, in order of first appearance
+	items     map[string][]int // policy ID -> positions in the result list
+}
+
+// ReadRegionalAssets reads many policies at once. The requests are grouped by region and each
+// region's regional chaincode is called once. Results come back in request order, with an
+// error on each item that could not be read instead of failing the whole batch.
+func (s *SmartContract) ReadRegionalAssets(ctx contractapi.TransactionContextInterface, requests []types.PolicyRequest) ([]*types.RegionalAssetResult, error) {
+	if len(requests) > types.MaxBatchSize {
+		return nil, fmt.Errorf("a batch may read at most %d policies, got %d", types.MaxBatchSize, len(requests))
+	}
+
+	startTime := time.Now()
+	results := make([]*types.RegionalAssetResult, len(requests))
+	groups := make(map[string]*batchGroup)
+	hospitals := make(map[string]*regionalRoute)
+	hospitalErrors := make(map[string]error)
+
+	for i, request := range requests {
+		results[i] = &types.RegionalAssetResult{PolicyID: request.PolicyID, HospitalID: request.HospitalID}
+		if request.PolicyID == "" || request.HospitalID == "" {
+			results[i].Error = "hospitalID and policyID are required"
+			continue
+		}
+
+		route, ok := hospitals[request.HospitalID]
+		if !ok {
+			var err error
+			route, err = s.routeForHospital(ctx, request.HospitalID)
+			hospitals[request.HospitalID] = route
+			hospitalErrors[request.HospitalID] = err
+		}
+		if err := hospitalErrors[request.HospitalID]; err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		key := route.ChaincodeName + "/" + route.Channel
+		group, ok := groups[key]
+		if !ok {
+			group = &batchGroup{route: route, items: make(map[string][]int)}
+			groups[key] = group
+		}
+		if _, seen := group.items[request.PolicyID]; !seen {
+			group.policyIDs = append(group.policyIDs, request.PolicyID)
+		}
+		group.items[request.PolicyID] = append(group.items[request.PolicyID], i)
+	}
+
+	// call the regions in a fixed order so every endorser makes the same calls
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		group := groups[key]
+		regionResults, err := retrieveBatchFromRegionalBC(ctx, group.route, group.policyIDs)
+		for _, policyID := range group.policyIDs {
+			for _, i := range group.items[policyID] {
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].Asset = regionResults[policyID].Asset
+				results[i].Error = regionResults[policyID].Error
+			}
+		}
+	}
+
+	duration := time.Since(startTime)
+	fmt.Printf("Time taken for batch query of %d policies across %d regions: %s\n", len(requests), len(groups), duration)
+
+	return results, nil
+}
+
+// routeForHospital returns the route of an active hospital
+func (s *SmartContract) routeForHospital(ctx contractapi.TransactionContextInterface, hospitalID string) (*regionalRoute, error) {
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRoutable(hospital)
+	if err != nil {
+		return nil, err
+	}
+
+	return routeFor(ctx, hospital)
+}
+
+// retrieveBatchFromRegionalBC reads policyIDs with one call to the regional chaincode of the route,
+// returning the results by policy ID
+func retrieveBatchFromRegionalBC(ctx contractapi.TransactionContextInterface, route *regionalRoute, policyIDs []string) (map[string]*types.RegionalAssetResult, error) {
+	idsJSON, err := json.Marshal(policyIDs)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := invokeRegional(ctx, route, "ReadAssets", string(idsJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve asset data from regional blockchain: %v", err)
+	}
+
+	regionResults, err := types.DecodeRegionalAssetResults(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode regional asset data: %v", err)
+	}
+
+	byPolicyID := make(map[string]*types.RegionalAssetResult, len(regionResults))
+	for _, result := range regionResults {
+		byPolicyID[result.PolicyID] = result
+	}
+	for _, policyID := range policyIDs {
+		if byPolicyID[policyID] == nil {
+			byPolicyID[policyID] = &types.RegionalAssetResult{PolicyID: policyID, Error: "missing from regional response"}
+		}
+	}
+
+	return byPolicyID, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestReadRegionalAssets(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	if response := invoke(stub, governingOrg, "SetRegionChannel", "region2", "region2channel"); response.Status != 200 {
+		t.Fatalf("SetRegionChannel failed: %s", response.Message)
+	}
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP2", RegionalCCName: "regionalCC2", Region: "region2"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP3", RegionalCCName: "regionalCC1", Region: "region1", Status: types.HospitalSuspended})
+	regional1 := deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	deployFakeRegional(stub, "regionalCC2", "region2channel")
+	regional1.missing["pcGone"] = true
+
+	requests := []types.PolicyRequest{
+		{HospitalID: "HP2", PolicyID: "pc1"},
+		{HospitalID: "HP1", PolicyID: "pc2"},
+		{HospitalID: "HP9", PolicyID: "pc3"},
+		{HospitalID: "HP1", PolicyID: "pcGone"},
+		{HospitalID: "HP2", PolicyID: "pc1"},
+		{HospitalID: "HP3", PolicyID: "pc4"},
+		{HospitalID: "HP1", PolicyID: ""},
+		{HospitalID: "HP1", PolicyID: "pc5"},
+	}
+	requestsJSON, err := json.Marshal(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := invoke(stub, governingOrg, "ReadRegionalAssets", string(requestsJSON))
+	if response.Status != 200 {
+		t.Fatalf("ReadRegionalAssets failed: %s", response.Message)
+	}
+	var results []*types.RegionalAssetResult
+	if err := json.Unmarshal(response.Payload, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(requests) {
+		t.Fatalf("expected %d results, got %d", len(requests), len(results))
+	}
+
+	// every item comes back at the position of its request, read or with its own error
+	for i, test := range []struct {
+		metadata string
+		err      string
+	}{
+		{metadata: "https://regionalCC2.region2channel"},
+		{metadata: "https://regionalCC1." + DefaultChannel},
+		{err: "HP9 does not exist"},
+		{err: "the asset pcGone does not exist"},
+		{metadata: "https://regionalCC2.region2channel"},
+		{err: "suspended"},
+		{err: "hospitalID and policyID are required"},
+		{metadata: "https://regionalCC1." + DefaultChannel},
+	} {
+		result := results[i]
+		if result.PolicyID != requests[i].PolicyID || result.HospitalID != requests[i].HospitalID {
+			t.Errorf("result %d is for %s at %s, expected %s at %s", i, result.PolicyID, result.HospitalID, requests[i].PolicyID, requests[i].HospitalID)
+			continue
+		}
+		if test.err != "" {
+			if result.Asset != nil || !strings.Contains(result.Error, test.err) {
+				t.Errorf("result %d: expected error containing %q, got %q (asset %v)", i, test.err, result.Error, result.Asset)
+			}
+			continue
+		}
+		if result.Error != "" || result.Asset == nil {
+			t.Errorf("result %d: expected an asset, got error %q", i, result.Error)
+			continue
+		}
+		if result.Asset.ID != result.PolicyID || result.Asset.Metadata != test.metadata {
+			t.Errorf("result %d: got %s from %s, expected %s from %s", i, result.Asset.ID, result.Asset.Metadata, result.PolicyID, test.metadata)
+		}
+	}
+}
+
+func TestReadRegionalAssetsSizeLimit(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+
+	requests := make([]types.PolicyRequest, types.MaxBatchSize+1)
+	for i := range requests {
+		requests[i] = types.PolicyRequest{HospitalID: "HP1", PolicyID: "pc1"}
+	}
+	requestsJSON, err := json.Marshal(requests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := invoke(stub, governingOrg, "ReadRegionalAssets", string(requestsJSON))
+	if response.Status == 200 || !strings.Contains(response.Message, "at most") {
+		t.Fatalf("expected a batch of %d to be refused, got %d: %s", len(requests), response.Status, response.Message)
+	}
+
+	requestsJSON, err = json.Marshal(requests[:types.MaxBatchSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = invoke(stub, governingOrg, "ReadRegionalAssets", string(requestsJSON))
+	if response.Status != 200 {
+		t.Fatalf("a batch of %d failed: %s", types.MaxBatchSize, response.Message)
+	}
+}
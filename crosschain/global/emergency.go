@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Certificate attributes used by break-glass access
+const (
+	EmergencyAttribute  = "emergency"  // "true" for clinicians allowed to use GrantEmergencyAccess
+	ComplianceAttribute = "compliance" // "true" for reviewers allowed to list every emergency access
+)
+
+// TransientOwnerRef is the transient map key under which a patient passes their ownerRef, the
+// pseudonymous reference the regional chaincode indexes their policies by, to ListEmergencyAccesses
+const TransientOwnerRef = "owner_ref"
+
+// EmergencyAccessEvent is the chaincode event emitted by GrantEmergencyAccess
+const EmergencyAccessEvent = "EmergencyAccess"
+
+// EmergencyGrantTTL is how long an emergency access grant lets its caller read the policy
+const EmergencyGrantTTL = time.Hour
+
+// emergencyIndex is the composite key object type of emergency access records: policyID, txID
+const emergencyIndex = "emergency~policy~tx"
+
+// EmergencyAccess is the audit record written by every GrantEmergencyAccess. It is never updated
+// or deleted, so it names the caller but nothing that identifies the patient.
+type EmergencyAccess struct {
+	PolicyID       string `json:"policyID"`
+	HospitalID     string `json:"hospitalID"`
+	Region         string `json:"region,omitempty" metadata:",optional"`
+	RegionalCCName string `json:"regionalCCName"`
+	Channel        string `json:"channel"`
+	CallerID       string `json:"callerID"`
+	MSPID          string `json:"mspID"`
+	Justification  string `json:"justification"`
+	TxID           string `json:"txID"`
+	Timestamp      string `json:"timestamp"`
+	ExpiresAt      string `json:"expiresAt"`
+}
+
+// GrantEmergencyAccess records a break-glass access to a policy for a caller with the emergency
+// attribute, even when no AuthRole or consent matches, and emits an EmergencyAccess event. It must
+// be submitted: EmergencyRead only serves callers whose grant is committed. The returned record's
+// TxID names the grant.
+func (s *SmartContract) GrantEmergencyAccess(ctx contractapi.TransactionContextInterface, hospitalID string, policyID string, justification string) (*EmergencyAccess, error) {
+	err := requireEmergencyAttribute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(justification) == "" {
+		return nil, fmt.Errorf("a justification is required for emergency access")
+	}
+	mspID, callerID, err := callerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	err = checkRoutable(hospital)
+	if err != nil {
+		return nil, err
+	}
+	route, err := routeFor(ctx, hospital)
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	now := time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC()
+	record := EmergencyAccess{
+		PolicyID:       policyID,
+		HospitalID:     hospitalID,
+		Region:         hospital.Region,
+		RegionalCCName: route.ChaincodeName,
+		Channel:        route.Channel,
+		CallerID:       callerID,
+		MSPID:          mspID,
+		Justification:  justification,
+		TxID:           ctx.GetStub().GetTxID(),
+		Timestamp:      now.Format(time.RFC3339Nano),
+		ExpiresAt:      now.Add(EmergencyGrantTTL).Format(time.RFC3339Nano),
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(emergencyIndex, []string{policyID, record.TxID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().PutState(key, recordJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to world state. %v", err)
+	}
+	err = ctx.GetStub().SetEvent(EmergencyAccessEvent, recordJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set event: %v", err)
+	}
+
+	fmt.Printf("[EMERGENCY] PolicyID: %s, HospitalID: %s, MSPID: %s\n", policyID, hospitalID, mspID)
+	return &record, nil
+}
+
+// EmergencyRead reads a policy under the caller's committed emergency access grant grantTxID, which
+// must be for the same policy and hospital and not have expired. It writes nothing, so it may be
+// evaluated: the audit record was committed by GrantEmergencyAccess. A single submitted
+// EmergencyRead(hospitalID, policyID, justification) would hand out the policy when evaluated
+// without leaving any record, hence the grant transaction first.
+func (s *SmartContract) EmergencyRead(ctx contractapi.TransactionContextInterface, hospitalID string, policyID string, grantTxID string) (*types.RegionalAsset, error) {
+	err := requireEmergencyAttribute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mspID, callerID, err := callerOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(emergencyIndex, []string{policyID, grantTxID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	grantJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if grantJSON == nil {
+		return nil, fmt.Errorf("no committed emergency access grant %s for policy %s, submit GrantEmergencyAccess first", grantTxID, policyID)
+	}
+	var grant EmergencyAccess
+	err = json.Unmarshal(grantJSON, &grant)
+	if err != nil {
+		return nil, err
+	}
+	if grant.HospitalID != hospitalID || grant.MSPID != mspID || grant.CallerID != callerID {
+		return nil, fmt.Errorf("the emergency access grant %s is not for this caller at hospital %s", grantTxID, hospitalID)
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if expired, err := isAfter(now, grant.ExpiresAt); err != nil || expired {
+		return nil, fmt.Errorf("the emergency access grant %s expired at %s", grantTxID, grant.ExpiresAt)
+	}
+
+	route, err := s.routeForHospital(ctx, hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	// the regional chaincode serves EmergencyReadAsset only to globalcc, which calls it only here
+	payload, err := invokeRegional(ctx, route, "EmergencyReadAsset", policyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve asset data from regional blockchain: %v", err)
+	}
+	asset, err := types.DecodeRegionalAsset(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode regional asset data: %v", err)
+	}
+	return asset, nil
+}
+
+// ListEmergencyAccesses returns the emergency access records of a policy.
+// The governing org and callers with the compliance attribute see every record. A patient sees
+// the records of a policy they own by passing its ownerRef under TransientOwnerRef, which the
+// regional chaincode holding the policy checks.
+func (s *SmartContract) ListEmergencyAccesses(ctx contractapi.TransactionContextInterface, policyID string) ([]*EmergencyAccess, error) {
+	if ctx.GetClientIdentity() == nil {
+		return nil, fmt.Errorf("client identity is not available")
+	}
+	reviewer, err := isReviewer(ctx)
+	if err != nil {
+		return nil, err
+	}
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transient map: %v", err)
+	}
+	ownerRef := string(transient[TransientOwnerRef])
+	if !reviewer && ownerRef == "" {
+		return nil, fmt.Errorf("only the patient, the governing org or compliance reviewers may list emergency accesses")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(emergencyIndex, []string{policyID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	records := []*EmergencyAccess{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var record EmergencyAccess
+		err = json.Unmarshal(queryResponse.Value, &record)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, &record)
+	}
+	if reviewer || len(records) == 0 {
+		return records, nil
+	}
+
+	owner, err := s.ownsPolicy(ctx, records[0].HospitalID, policyID, ownerRef)
+	if err != nil {
+		return nil, err
+	}
+	if !owner {
+		return nil, fmt.Errorf("the ownerRef does not own policy %s", policyID)
+	}
+	return records, nil
+}
+
+// ownsPolicy asks the regional chaincode of hospitalID whether its policyID belongs to ownerRef.
+// Only the ownerRef's hash, which the regional chaincode indexes in its private data collection,
+// leaves globalcc.
+func (s *SmartContract) ownsPolicy(ctx contractapi.TransactionContextInterface, hospitalID string, policyID string, ownerRef string) (bool, error) {
+	route, err := s.routeForHospital(ctx, hospitalID)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256([]byte(ownerRef))
+	payload, err := invokeRegional(ctx, route, "HasOwnerRef", policyID, hex.EncodeToString(sum[:]))
+	if err != nil {
+		return false, err
+	}
+	var owner bool
+	err = json.Unmarshal(payload, &owner)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode regional response: %v", err)
+	}
+	return owner, nil
+}
+
+// requireEmergencyAttribute fails unless the caller's certificate carries EmergencyAttribute=true
+func requireEmergencyAttribute(ctx contractapi.TransactionContextInterface) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	emergency, found, err := identity.GetAttributeValue(EmergencyAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to get client attribute %s: %v", EmergencyAttribute, err)
+	}
+	if !found || emergency != "true" {
+		return fmt.Errorf("client certificate does not carry %s=true", EmergencyAttribute)
+	}
+	return nil
+}
+
+// callerOf returns the MSP ID and client ID of the caller
+func callerOf(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	identity := ctx.GetClientIdentity()
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	callerID, err := identity.GetID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client ID: %v", err)
+	}
+	return mspID, callerID, nil
+}
+
+// isAfter reports whether the RFC 3339 time a is after b
+func isAfter(a string, b string) (bool, error) {
+	at, err := time.Parse(time.RFC3339Nano, a)
+	if err != nil {
+		return false, err
+	}
+	bt, err := time.Parse(time.RFC3339Nano, b)
+	if err != nil {
+		return false, err
+	}
+	return at.After(bt), nil
+}
+
+// isReviewer reports whether the caller belongs to the governing org or carries the compliance attribute
+func isReviewer(ctx contractapi.TransactionContextInterface) (bool, error) {
+	identity := ctx.GetClientIdentity()
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return false, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	if mspID == governingMSPID() {
+		return true, nil
+	}
+
+	compliance, found, err := identity.GetAttributeValue(ComplianceAttribute)
+	if err != nil {
+		return false, fmt.Errorf("failed to get client attribute %s: %v", ComplianceAttribute, err)
+	}
+	return found && compliance == "true", nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestEmergencyReadRequiresCommittedGrant(t *testing.T) {
+	stub := newTestStub(t)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP2", RegionalCCName: "regionalCC1", Region: "region1"})
+	regional := deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	clinician := testIdentityWithAttrs(t, "Org2MSP", map[string]string{EmergencyAttribute: "true"})
+
+	response := invoke(stub, clinician, "EmergencyRead", "HP1", "pc1", "tx-none")
+	if response.Status == 200 || !strings.Contains(response.Message, "no committed emergency access grant") {
+		t.Fatalf("expected EmergencyRead without a grant to be refused, got %d %s", response.Status, response.Message)
+	}
+	if response := invoke(stub, testIdentity(t, "Org2MSP"), "GrantEmergencyAccess", "HP1", "pc1", "cardiac arrest"); response.Status == 200 {
+		t.Fatalf("a caller without the emergency attribute was granted emergency access")
+	}
+	if response := invoke(stub, clinician, "GrantEmergencyAccess", "HP1", "pc1", " "); response.Status == 200 {
+		t.Fatalf("emergency access was granted without a justification")
+	}
+
+	response = invoke(stub, clinician, "GrantEmergencyAccess", "HP1", "pc1", "cardiac arrest")
+	if response.Status != 200 {
+		t.Fatalf("GrantEmergencyAccess failed: %s", response.Message)
+	}
+	var grant EmergencyAccess
+	if err := json.Unmarshal(response.Payload, &grant); err != nil {
+		t.Fatal(err)
+	}
+	if grant.TxID == "" || grant.ExpiresAt <= grant.Timestamp || strings.Contains(string(response.Payload), "PATIENT 1") {
+		t.Fatalf("unexpected grant %s", response.Payload)
+	}
+	if event := <-stub.ChaincodeEventsChannel; event.EventName != EmergencyAccessEvent {
+		t.Fatalf("expected an %s event, got %s", EmergencyAccessEvent, event.EventName)
+	}
+
+	for _, test := range []struct {
+		name       string
+		creator    []byte
+		hospitalID string
+		policyID   string
+	}{
+		{"another caller", testIdentityWithAttrs(t, "Org3MSP", map[string]string{EmergencyAttribute: "true"}), "HP1", "pc1"},
+		{"another hospital", clinician, "HP2", "pc1"},
+		{"another policy", clinician, "HP1", "pc2"},
+	} {
+		if response := invoke(stub, test.creator, "EmergencyRead", test.hospitalID, test.policyID, grant.TxID); response.Status == 200 {
+			t.Errorf("%s: EmergencyRead succeeded under a grant it does not hold", test.name)
+		}
+	}
+
+	response = invoke(stub, clinician, "EmergencyRead", "HP1", "pc1", grant.TxID)
+	if response.Status != 200 {
+		t.Fatalf("EmergencyRead under the grant failed: %s", response.Message)
+	}
+	var asset types.RegionalAsset
+	if err := json.Unmarshal(response.Payload, &asset); err != nil || asset.ID != "pc1" {
+		t.Fatalf("unexpected asset %s", response.Payload)
+	}
+	if functions := regional.functions; len(functions) != 1 || functions[0] != "EmergencyReadAsset" {
+		t.Fatalf("expected one regional EmergencyReadAsset call, got %v", functions)
+	}
+}
+
+func TestListEmergencyAccesses(t *testing.T) {
+	stub := newTestStub(t)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	regional := deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	sum := sha256.Sum256([]byte("ref-1"))
+	regional.ownerRefs["pc1"] = hex.EncodeToString(sum[:])
+
+	clinician := testIdentityWithAttrs(t, "Org2MSP", map[string]string{EmergencyAttribute: "true"})
+	if response := invoke(stub, clinician, "GrantEmergencyAccess", "HP1", "pc1", "cardiac arrest"); response.Status != 200 {
+		t.Fatalf("GrantEmergencyAccess failed: %s", response.Message)
+	}
+
+	patient := testIdentity(t, "Org2MSP")
+	for _, test := range []struct {
+		name      string
+		creator   []byte
+		ownerRef  string
+		succeeded bool
+	}{
+		{"governing org", testIdentity(t, DefaultGoverningMSPID), "", true},
+		{"compliance reviewer", testIdentityWithAttrs(t, "Org2MSP", map[string]string{ComplianceAttribute: "true"}), "", true},
+		{"patient", patient, "ref-1", true},
+		{"another patient", patient, "ref-2", false},
+		{"anyone", patient, "", false},
+	} {
+		stub.TransientMap = map[string][]byte{}
+		if test.ownerRef != "" {
+			stub.TransientMap[TransientOwnerRef] = []byte(test.ownerRef)
+		}
+		response := invoke(stub, test.creator, "ListEmergencyAccesses", "pc1")
+		if (response.Status == 200) != test.succeeded {
+			t.Errorf("%s: expected success %v, got %d %s", test.name, test.succeeded, response.Status, response.Message)
+			continue
+		}
+		var records []*EmergencyAccess
+		if test.succeeded && (json.Unmarshal(response.Payload, &records) != nil || len(records) != 1) {
+			t.Errorf("%s: unexpected records %s", test.name, response.Payload)
+		}
+	}
+}
@@ -4,43 +4,62 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"crosschain/types"
+
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
 )
 
+// compositeKeyNamespace starts every composite key, which keeps them apart from hospital IDs
+const compositeKeyNamespace = "\x00"
+
 // SmartContract provides functions for managing an GlobalAsset
 type SmartContract struct {
 	contractapi.Contract
 }
 
-// GlobalAsset describes basic details of what makes up a simple asset
-type GlobalAsset struct {
-	HospitalID     string `json:"hospitalID"`
-	RegionalCCName string `json:"regionalCCName"`
-}
-
-type RegionalAsset struct {
-	ID             string `json:"ID"`
-	Color          string `json:"color"`
-	Size           int    `json:"size"`
-	Owner          string `json:"owner"`
-	AppraisedValue int    `json:"appraisedValue"`
-}
-
 // InitLedger adds a base set of assets to the ledger
 func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
 
-	assets := []GlobalAsset{
-		{HospitalID: "HP1", RegionalCCName: "regionalCC1"},
-		{HospitalID: "HP2", RegionalCCName: "regionalCC2"},
-		{HospitalID: "HP3", RegionalCCName: "regionalCC3"},
-		{HospitalID: "HP4", RegionalCCName: "regionalCC4"},
-		{HospitalID: "HP5", RegionalCCName: "regionalCC5"},
+	assets := []types.GlobalAsset{
+		{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"},
+		{HospitalID: "HP2", RegionalCCName: "regionalCC2", Region: "region2"},
+		{HospitalID: "HP3", RegionalCCName: "regionalCC3", Region: "region3"},
+		{HospitalID: "HP4", RegionalCCName: "regionalCC4", Region: "region4"},
+		{HospitalID: "HP5", RegionalCCName: "regionalCC5", Region: "region5"},
 	}
 
 	for _, asset := range assets {
+		// a channel already set with SetRegionChannel survives a re-run
+		region, err := getRegion(ctx, asset.Region)
+		if err != nil {
+			return err
+		}
+		if region == nil {
+			err = putRegion(ctx, &Region{RegionID: asset.Region, Channel: DefaultChannel})
+			if err != nil {
+				return err
+			}
+		}
+
+		asset.SchemaVersion = types.GlobalAssetSchemaVersion
+		asset.Channel = DefaultChannel
+		asset.Status = types.HospitalActive
+		asset.CreatedAt = now
+		asset.UpdatedAt = now
+
 		assetJSON, err := json.Marshal(asset)
 		if err != nil {
 			fmt.Printf("INIT: MARSHAL ERR!\n")
@@ -59,29 +78,13 @@ func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface)
 }
 
 // CreateAsset issues a new asset to the world state with given details.
+// It registers an active hospital on the default channel; use RegisterHospital to set the other details.
 func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, hospitalID string, rccName string) error {
-	exists, err := s.AssetExists(ctx, hospitalID)
-	if err != nil {
-		return err
-	}
-	if exists {
-		return fmt.Errorf("the asset %s already exists", hospitalID)
-	}
-
-	asset := GlobalAsset{
-		HospitalID:     hospitalID,
-		RegionalCCName: rccName,
-	}
-	assetJSON, err := json.Marshal(asset)
-	if err != nil {
-		return err
-	}
-
-	return ctx.GetStub().PutState(hospitalID, assetJSON)
+	return s.RegisterHospital(ctx, hospitalID, "", "", "", rccName, DefaultChannel)
 }
 
 // ReadAsset returns the asset stored in the world state with given id.
-func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*GlobalAsset, error) {
+func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*types.GlobalAsset, error) {
 	startTime := time.Now()
 	assetJSON, err := ctx.GetStub().GetState(id)
 	if err != nil {
@@ -97,8 +100,7 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, i
 		return nil, fmt.Errorf("the asset %s does not exist", id)
 	}
 
-	var asset GlobalAsset
-	err = json.Unmarshal(assetJSON, &asset)
+	asset, err := types.DecodeGlobalAsset(assetJSON)
 	if err != nil {
 		duration := time.Since(startTime)
 		fmt.Printf("UNMARSHAL ERR!! Time taken for query for id (%s): %s\n", id, duration)
@@ -109,11 +111,11 @@ func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, i
 	duration := time.Since(startTime)
 	fmt.Printf("Time taken for query for id (%s): %s\n", id, duration)
 
-	return &asset, nil
+	return asset, nil
 }
 
 // ReadAsset returns the asset stored in the world state with given id.
-func (s *SmartContract) ReadRegionalAsset(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) (*RegionalAsset, error) {
+func (s *SmartContract) ReadRegionalAsset(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) (*types.RegionalAsset, error) {
 	startTime := time.Now()
 	indexAssetJSON, err := ctx.GetStub().GetState(hospitalID)
 	if err != nil {
@@ -129,19 +131,31 @@ func (s *SmartContract) ReadRegionalAsset(ctx contractapi.TransactionContextInte
 		return nil, fmt.Errorf("the asset hospitalID (%s) does not exist", hospitalID)
 	}
 
-	var indexAsset GlobalAsset
-	err = json.Unmarshal(indexAssetJSON, &indexAsset)
+	indexAsset, err := types.DecodeGlobalAsset(indexAssetJSON)
 	if err != nil {
 		duration := time.Since(startTime)
 		fmt.Printf("UNMARSHAL ERR!! Time taken for query for hospitalID (%s): %s\n", hospitalID, duration)
 
 		return nil, err
 	}
-	
+
+	err = checkRoutable(indexAsset)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("UNAVAILABLE!! Time taken for query for hospitalID (%s): %s\n", hospitalID, duration)
+
+		return nil, err
+	}
+
 	duration := time.Since(startTime)
 	fmt.Printf("Time before retrieve regional: %s\n", duration)
 
-	asset, err := retrieveFromRegionalBC(ctx, indexAsset.RegionalCCName, policyID)
+	route, err := routeFor(ctx, indexAsset)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := retrieveFromRegionalBC(ctx, route, policyID)
 	if err != nil {
 		duration := time.Since(startTime)
 		fmt.Printf("Regional ERR!! Time taken for query for hospitalID (%s): %s\n", hospitalID, duration)
@@ -155,51 +169,61 @@ func (s *SmartContract) ReadRegionalAsset(ctx contractapi.TransactionContextInte
 	return asset, nil
 }
 
-
-// retrieveFromRegionalBC retrieves asset data from the regional blockchain using the provided path and asset ID.
-func retrieveFromRegionalBC(ctx contractapi.TransactionContextInterface, rccName string, policyID string) (*RegionalAsset, error) {
-	queryArgs := [][]byte{[]byte("ReadAsset"), []byte(policyID)}
-	response := ctx.GetStub().InvokeChaincode(rccName, queryArgs, "mychannel")
-
-	if response.GetStatus() != shim.OK {
-		return nil, fmt.Errorf("failed to retrieve asset data from regional blockchain: %s", response.GetMessage())
+// retrieveFromRegionalBC retrieves asset data from the regional chaincode and channel of the route.
+func retrieveFromRegionalBC(ctx contractapi.TransactionContextInterface, route *regionalRoute, policyID string) (*types.RegionalAsset, error) {
+	payload, err := invokeRegional(ctx, route, "ReadAsset", policyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve asset data from regional blockchain: %v", err)
 	}
 
-	var regionalAsset RegionalAsset
-	err := json.Unmarshal(response.Payload, &regionalAsset)
+	// strict decoding so a payload that does not match the shared schema fails
+	// loudly instead of silently dropping fields
+	regionalAsset, err := types.DecodeRegionalAsset(payload)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal regional asset data: %v", err)
+		return nil, fmt.Errorf("failed to decode regional asset data: %v", err)
 	}
 
-	fmt.Printf("[REG] PolicyID: %s, Owner: %s\n", regionalAsset.ID, regionalAsset.Owner)
-	return &regionalAsset, nil
+	fmt.Printf("[REG] PolicyID: %s\n", regionalAsset.ID)
+	return regionalAsset, nil
 }
 
-// UpdateAsset updates an existing asset in the world state with provided parameters.
-func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, hospitalID string, rccName string) error {
-	exists, err := s.AssetExists(ctx, hospitalID)
+// VerifyRegionalPrivateHash asks the hospital's regional chaincode whether the owner, metadata and
+// salt passed in the transient map match the policy's private data, without reading the collection.
+// The transient map travels with the proposal to the regional chaincode.
+func (s *SmartContract) VerifyRegionalPrivateHash(ctx contractapi.TransactionContextInterface, hospitalID string, policyID string) (bool, error) {
+	route, err := s.routeForHospital(ctx, hospitalID)
 	if err != nil {
-		return err
-	}
-	if !exists {
-		return fmt.Errorf("the asset %s does not exist", hospitalID)
+		return false, err
 	}
 
-	// overwriting original asset with new asset
-	asset := GlobalAsset{
-		HospitalID:     hospitalID,
-		RegionalCCName: rccName,
+	payload, err := invokeRegional(ctx, route, "VerifyPrivateHash", policyID)
+	if err != nil {
+		return false, err
 	}
-	assetJSON, err := json.Marshal(asset)
+	var matches bool
+	err = json.Unmarshal(payload, &matches)
 	if err != nil {
-		return err
+		return false, fmt.Errorf("failed to decode regional response: %v", err)
 	}
+	return matches, nil
+}
 
-	return ctx.GetStub().PutState(hospitalID, assetJSON)
+// UpdateAsset updates an existing asset in the world state with provided parameters.
+func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, hospitalID string, rccName string) error {
+	return s.TransferAsset(ctx, hospitalID, rccName)
 }
 
 // DeleteAsset deletes an given asset from the world state.
+// Prefer DecommissionHospital, which keeps the record and its reason.
+// Composite-key records such as emergency access audits cannot be deleted.
 func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, id string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(id, compositeKeyNamespace) {
+		return fmt.Errorf("the record %q cannot be deleted", id)
+	}
 	exists, err := s.AssetExists(ctx, id)
 	if err != nil {
 		return err
@@ -221,24 +245,26 @@ func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface,
 	return assetJSON != nil, nil
 }
 
-// TransferAsset updates the owner field of asset with given id in world state.
+// TransferAsset moves the hospital with given id to another regional chaincode.
 func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterface, id string, newrccName string) error {
-	asset, err := s.ReadAsset(ctx, id)
+	err := requireGoverningOrg(ctx)
 	if err != nil {
 		return err
 	}
-
-	asset.RegionalCCName = newrccName
-	assetJSON, err := json.Marshal(asset)
+	asset, err := s.ReadAsset(ctx, id)
 	if err != nil {
 		return err
 	}
+	if asset.Status == types.HospitalDecommissioned {
+		return fmt.Errorf("the hospital %s is decommissioned", id)
+	}
 
-	return ctx.GetStub().PutState(id, assetJSON)
+	asset.RegionalCCName = newrccName
+	return updateHospital(ctx, asset, asset.Status, asset.Reason)
 }
 
 // GetAllAssets returns all assets found in world state
-func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*GlobalAsset, error) {
+func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*types.GlobalAsset, error) {
 	// range query with empty string for startKey and endKey does an
 	// open-ended query of all assets in the chaincode namespace.
 	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
@@ -247,34 +273,127 @@ func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface
 	}
 	defer resultsIterator.Close()
 
-	var assets []*GlobalAsset
+	var assets []*types.GlobalAsset
 	for resultsIterator.HasNext() {
 		queryResponse, err := resultsIterator.Next()
 		if err != nil {
 			return nil, err
 		}
+		// Fabric leaves composite keys out of range queries, shimtest's MockStub does not
+		if strings.HasPrefix(queryResponse.Key, compositeKeyNamespace) {
+			continue
+		}
 
-		var asset GlobalAsset
-		err = json.Unmarshal(queryResponse.Value, &asset)
+		asset, err := types.DecodeGlobalAsset(queryResponse.Value)
 		if err != nil {
 			return nil, err
 		}
-		assets = append(assets, &asset)
+		assets = append(assets, asset)
 	}
 
 	return assets, nil
 }
 
-func (t *SmartContract) QueryAssetsByPolicyAndHospital(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) (*RegionalAsset, error) {
+// GetAllAssetsWithPagination returns one page of the hospital registry in key order.
+// Pass the returned bookmark to get the next page; pagination only works in evaluated (query) transactions.
+func (s *SmartContract) GetAllAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*types.GlobalAssetPage, error) {
+	err := types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructGlobalAssetPage(resultsIterator, responseMetadata)
+}
+
+// QueryHospitalsWithPagination returns one page of the hospitals with the given status
+// using a CouchDB selector. An empty status matches every hospital. Hospitals are told apart from
+// globalcc's other documents, which also carry a hospitalID, by their schemaVersion, so hospitals
+// stored before it was introduced are only listed by GetAllAssetsWithPagination.
+func (s *SmartContract) QueryHospitalsWithPagination(ctx contractapi.TransactionContextInterface, status string, pageSize int32, bookmark string) (*types.GlobalAssetPage, error) {
+	err := types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	selector := map[string]interface{}{
+		"schemaVersion": map[string]interface{}{"$exists": true},
+		"hospitalID":    map[string]interface{}{"$exists": true},
+	}
+	if status != "" {
+		selector["status"] = status
+	}
+	queryString, err := json.Marshal(map[string]interface{}{"selector": selector})
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryString), pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	return constructGlobalAssetPage(resultsIterator, responseMetadata)
+}
+
+// constructGlobalAssetPage decodes every hospital of a paginated query into a page
+func constructGlobalAssetPage(resultsIterator shim.StateQueryIteratorInterface, responseMetadata *peer.QueryResponseMetadata) (*types.GlobalAssetPage, error) {
+	page := &types.GlobalAssetPage{Records: []*types.GlobalAsset{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := types.DecodeGlobalAsset(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+
+	page.FetchedCount = responseMetadata.GetFetchedRecordsCount()
+	page.Bookmark = responseMetadata.GetBookmark()
+	return page, nil
+}
+
+// QueryAssetsByPolicyAndHospital finds the policy locator with a CouchDB selector and reads the policy from its region
+func (t *SmartContract) QueryAssetsByPolicyAndHospital(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) (*types.RegionalAsset, error) {
 	startTime := time.Now()
-	queryString := fmt.Sprintf(`{"selector":{"policyID":"%s","hospitalID":"%s"}}`, policyID, hospitalID)
-	globalAsset, err := getQueryResultForQueryString(ctx, queryString)
+	queryString, err := policyLocatorSelector(policyID, hospitalID)
+	if err != nil {
+		return nil, err
+	}
+	locator, err := getQueryResultForQueryString(ctx, queryString)
+	if err != nil {
+		return nil, fmt.Errorf("query global failed: %s", err)
+	}
+	locator, err = t.resolveLocator(ctx, locator)
 	if err != nil {
 		return nil, fmt.Errorf("query global failed: %s", err)
 	}
+	hospital, err := t.ReadAsset(ctx, locator.HospitalID)
+	if err != nil {
+		return nil, fmt.Errorf("query global failed: %s", err)
+	}
+	err = checkRoutable(hospital)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Printf("[GLO] PolicyID: %s, HospitalID: %s, RegionalCC: %s\n", policyID, globalAsset.HospitalID, globalAsset.RegionalCCName)
-	actualAsset, err := retrieveFromRegionalBC(ctx, globalAsset.RegionalCCName, policyID)
+	route, err := routeFor(ctx, hospital)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("[GLO] PolicyID: %s, HospitalID: %s, RegionalCC: %s, Channel: %s\n", policyID, locator.HospitalID, route.ChaincodeName, route.Channel)
+	actualAsset, err := retrieveFromRegionalBC(ctx, route, policyID)
 	if err != nil {
 		return nil, fmt.Errorf("query regional failed: %s", err)
 	}
@@ -285,7 +404,7 @@ func (t *SmartContract) QueryAssetsByPolicyAndHospital(ctx contractapi.Transacti
 	return actualAsset, nil
 }
 
-func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) (*GlobalAsset, error) {
+func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, queryString string) (*PolicyLocator, error) {
 	resultsIterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
 		return nil, err
@@ -295,35 +414,23 @@ func getQueryResultForQueryString(ctx contractapi.TransactionContextInterface, q
 	return constructQueryResponseFromIterator(resultsIterator)
 }
 
-// constructQueryResponseFromIterator constructs a slice of assets from the resultsIterator
-func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*GlobalAsset, error) {
-	// var assets []*GlobalAsset
-	// for resultsIterator.HasNext() {
-	// 	queryResult, err := resultsIterator.Next()
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	var asset GlobalAsset
-	// 	err = json.Unmarshal(queryResult.Value, &asset)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	assets = append(assets, &asset)
-	// }
-
-	// return assets[0], nil
+// constructQueryResponseFromIterator returns the first policy locator from the resultsIterator
+func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorInterface) (*PolicyLocator, error) {
+	if !resultsIterator.HasNext() {
+		return nil, fmt.Errorf("no policy locator found")
+	}
 
 	queryResult, err := resultsIterator.Next()
 	if err != nil {
 		return nil, err
 	}
-	var asset GlobalAsset
-	err = json.Unmarshal(queryResult.Value, &asset)
+	var locator PolicyLocator
+	err = json.Unmarshal(queryResult.Value, &locator)
 	if err != nil {
 		return nil, err
 	}
 
-	return &asset, nil
+	return &locator, nil
 }
 
 func main() {
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// policyLocatorIndex is the composite key object type for policy locators, keyed by policyID then hospitalID
+const policyLocatorIndex = "policy~hospital"
+
+// policyLocatorDocType marks locator documents for CouchDB selectors
+const policyLocatorDocType = "policyLocator"
+
+// PolicyLocator records which hospital, and therefore which region, holds a policy
+type PolicyLocator struct {
+	DocType        string `json:"docType"`
+	PolicyID       string `json:"policyID"`
+	HospitalID     string `json:"hospitalID"`
+	RegionalCCName string `json:"regionalCCName,omitempty" metadata:",optional"`
+}
+
+// RegisterPolicy records that policyID is held by hospitalID. The caller must belong to the
+// hospital's MSP or to the governing org.
+func (s *SmartContract) RegisterPolicy(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) error {
+	if policyID == "" {
+		return fmt.Errorf("policyID must not be empty")
+	}
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	err = requireHospitalOrg(ctx, hospital)
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(policyLocatorIndex, []string{policyID, hospitalID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	locatorJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if locatorJSON != nil {
+		return fmt.Errorf("the policy %s is already registered at hospital %s", policyID, hospitalID)
+	}
+
+	// the region is resolved from the hospital on lookup so that reassigning a hospital
+	// does not leave stale locators behind
+	locator := PolicyLocator{
+		DocType:    policyLocatorDocType,
+		PolicyID:   policyID,
+		HospitalID: hospitalID,
+	}
+	locatorJSON, err = json.Marshal(locator)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, locatorJSON)
+}
+
+// UnregisterPolicy removes the record that policyID is held by hospitalID. The caller must belong
+// to the hospital's MSP or to the governing org, which alone may clear the locators of a hospital
+// removed from the registry.
+func (s *SmartContract) UnregisterPolicy(ctx contractapi.TransactionContextInterface, policyID string, hospitalID string) error {
+	exists, err := s.AssetExists(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		var hospital *types.GlobalAsset
+		hospital, err = s.ReadAsset(ctx, hospitalID)
+		if err != nil {
+			return err
+		}
+		err = requireHospitalOrg(ctx, hospital)
+	} else {
+		err = requireGoverningOrg(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(policyLocatorIndex, []string{policyID, hospitalID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	locatorJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if locatorJSON == nil {
+		return fmt.Errorf("the policy %s is not registered at hospital %s", policyID, hospitalID)
+	}
+
+	return ctx.GetStub().DelState(key)
+}
+
+// LocatePolicy returns the hospital and regional chaincode holding policyID
+func (s *SmartContract) LocatePolicy(ctx contractapi.TransactionContextInterface, policyID string) (*PolicyLocator, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(policyLocatorIndex, []string{policyID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var locators []*PolicyLocator
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var locator PolicyLocator
+		err = json.Unmarshal(queryResponse.Value, &locator)
+		if err != nil {
+			return nil, err
+		}
+		locators = append(locators, &locator)
+	}
+
+	if len(locators) == 0 {
+		return nil, fmt.Errorf("the policy %s is not registered", policyID)
+	}
+	if len(locators) > 1 {
+		var hospitalIDs []string
+		for _, locator := range locators {
+			hospitalIDs = append(hospitalIDs, locator.HospitalID)
+		}
+		return nil, fmt.Errorf("the policy %s is registered at multiple hospitals: %s", policyID, strings.Join(hospitalIDs, ", "))
+	}
+
+	return s.resolveLocator(ctx, locators[0])
+}
+
+// resolveLocator fills in the regional chaincode of the locator's hospital
+func (s *SmartContract) resolveLocator(ctx contractapi.TransactionContextInterface, locator *PolicyLocator) (*PolicyLocator, error) {
+	hospital, err := s.ReadAsset(ctx, locator.HospitalID)
+	if err != nil {
+		return nil, err
+	}
+
+	locator.RegionalCCName = hospital.RegionalCCName
+	return locator, nil
+}
+
+// policyLocatorSelector builds the CouchDB query for the locator of policyID at hospitalID
+func policyLocatorSelector(policyID string, hospitalID string) (string, error) {
+	query := map[string]interface{}{
+		"selector": map[string]string{
+			"docType":    policyLocatorDocType,
+			"policyID":   policyID,
+			"hospitalID": hospitalID,
+		},
+		"use_index": []string{"_design/indexPolicyLocatorDoc", "indexPolicyLocator"},
+	}
+
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(queryJSON), nil
+}
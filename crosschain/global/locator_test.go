@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// attributeOID is the certificate extension in which Fabric CA issues identity attributes
+var attributeOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testIdentity is a serialized Fabric identity of mspID
+func testIdentity(t *testing.T, mspID string) []byte {
+	t.Helper()
+	return testIdentityWithAttrs(t, mspID, nil)
+}
+
+// testIdentityWithAttrs is a serialized Fabric identity of mspID whose certificate carries attrs
+func testIdentityWithAttrs(t *testing.T, mspID string, attrs map[string]string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test", Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		attrsJSON, err := json.Marshal(map[string]interface{}{"attrs": attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributeOID, Value: attrsJSON}}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+// newTestStub returns a MockStub of globalcc on mychannel
+func newTestStub(t *testing.T) *shimtest.MockStub {
+	t.Helper()
+
+	globalChaincode, err := contractapi.NewChaincode(&SmartContract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := shimtest.NewMockStub("globalCC", globalChaincode)
+	stub.ChannelID = "mychannel"
+	return stub
+}
+
+// putTestHospital stores an active hospital of the registry, with no access check
+func putTestHospital(t *testing.T, stub *shimtest.MockStub, hospital types.GlobalAsset) {
+	t.Helper()
+
+	hospital.SchemaVersion = types.GlobalAssetSchemaVersion
+	if hospital.Status == "" {
+		hospital.Status = types.HospitalActive
+	}
+	hospitalJSON, err := json.Marshal(hospital)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionStart("seed")
+	defer stub.MockTransactionEnd("seed")
+	if err := stub.PutState(hospital.HospitalID, hospitalJSON); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invoke calls function as creator and returns the chaincode response
+func invoke(stub *shimtest.MockStub, creator []byte, function string, args ...string) peer.Response {
+	stub.Creator = creator
+	invocation := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invocation = append(invocation, []byte(arg))
+	}
+	return stub.MockInvoke("tx-"+function, invocation)
+}
+
+func TestRegisterPolicyAuthorization(t *testing.T) {
+	stub := newTestStub(t)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", MSPID: "Org2MSP", Region: "region1"})
+
+	for _, caller := range []struct {
+		mspID   string
+		allowed bool
+	}{
+		{"Org3MSP", false},
+		{"Org2MSP", true},
+		{DefaultGoverningMSPID, true},
+	} {
+		policyID := "region1:HP1:pc-" + caller.mspID
+		response := invoke(stub, testIdentity(t, caller.mspID), "RegisterPolicy", policyID, "HP1")
+		if caller.allowed != (response.Status == 200) {
+			t.Fatalf("RegisterPolicy as %s: status %d %s", caller.mspID, response.Status, response.Message)
+		}
+		if !caller.allowed && !strings.Contains(response.Message, "caller is "+caller.mspID) {
+			t.Fatalf("RegisterPolicy as %s: unexpected error %s", caller.mspID, response.Message)
+		}
+
+		response = invoke(stub, testIdentity(t, caller.mspID), "LocatePolicy", policyID)
+		if caller.allowed != (response.Status == 200) {
+			t.Fatalf("LocatePolicy after RegisterPolicy as %s: status %d %s", caller.mspID, response.Status, response.Message)
+		}
+	}
+}
+
+func TestUnregisterPolicyAuthorization(t *testing.T) {
+	stub := newTestStub(t)
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", MSPID: "Org2MSP", Region: "region1"})
+	hospitalOrg := testIdentity(t, "Org2MSP")
+	for _, policyID := range []string{"region1:HP1:pc1", "region1:HP1:pc2"} {
+		if response := invoke(stub, hospitalOrg, "RegisterPolicy", policyID, "HP1"); response.Status != 200 {
+			t.Fatalf("RegisterPolicy failed: %s", response.Message)
+		}
+	}
+
+	response := invoke(stub, testIdentity(t, "Org3MSP"), "UnregisterPolicy", "region1:HP1:pc1", "HP1")
+	if response.Status == 200 {
+		t.Fatalf("another org unregistered a policy of HP1")
+	}
+	response = invoke(stub, hospitalOrg, "UnregisterPolicy", "region1:HP1:pc1", "HP1")
+	if response.Status != 200 {
+		t.Fatalf("the hospital's org failed to unregister its policy: %s", response.Message)
+	}
+
+	// once the hospital is removed, only the governing org may clear its locators
+	stub.MockTransactionStart("remove")
+	stub.DelState("HP1")
+	stub.MockTransactionEnd("remove")
+	response = invoke(stub, hospitalOrg, "UnregisterPolicy", "region1:HP1:pc2", "HP1")
+	if response.Status == 200 {
+		t.Fatalf("the org of a removed hospital unregistered a policy")
+	}
+	response = invoke(stub, testIdentity(t, DefaultGoverningMSPID), "UnregisterPolicy", "region1:HP1:pc2", "HP1")
+	if response.Status != 200 {
+		t.Fatalf("the governing org failed to unregister the policy of a removed hospital: %s", response.Message)
+	}
+}
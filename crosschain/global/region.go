@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// regionIndex is the composite key object type under which region channels are stored
+const regionIndex = "region~channel"
+
+// regionalReadFunctions are the regional chaincode functions that only read the ledger.
+// Fabric does not commit writes made through InvokeChaincode on another channel,
+// so only these may be called on a region that lives on a different channel.
+var regionalReadFunctions = map[string]bool{
+	"ReadAsset":          true,
+	"ReadAssets":         true,
+	"AssetExists":        true,
+	"GetAllAssets":       true,
+	"GetRegionConfig":    true,
+	"VerifyPrivateHash":  true,
+	"ListAssetIDs":       true,
+	"HasOwnerRef":        true,
+	"EmergencyReadAsset": true,
+}
+
+// Region records the channel a region's regional chaincodes are deployed on
+type Region struct {
+	RegionID string `json:"regionID"`
+	Channel  string `json:"channel"`
+}
+
+// regionalRoute is where a call for a hospital's policies is sent
+type regionalRoute struct {
+	ChaincodeName string
+	Channel       string
+}
+
+// CrossChannelWriteError is returned when a write is routed to a regional chaincode on another channel
+type CrossChannelWriteError struct {
+	Chaincode string
+	Function  string
+	Channel   string
+	Current   string
+}
+
+func (e *CrossChannelWriteError) Error() string {
+	return fmt.Sprintf("cannot call %s on %s: it is on channel %s and Fabric only allows reads across channels, submit it on %s instead of %s",
+		e.Function, e.Chaincode, e.Channel, e.Channel, e.Current)
+}
+
+// SetRegionChannel records the channel a region's regional chaincodes are deployed on.
+// Hospitals in the region are routed to this channel from then on.
+func (s *SmartContract) SetRegionChannel(ctx contractapi.TransactionContextInterface, regionID string, channel string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if regionID == "" {
+		return fmt.Errorf("a region ID is required")
+	}
+	if channel == "" {
+		return fmt.Errorf("a channel is required")
+	}
+
+	return putRegion(ctx, &Region{RegionID: regionID, Channel: channel})
+}
+
+// ReadRegion returns the channel recorded for a region
+func (s *SmartContract) ReadRegion(ctx contractapi.TransactionContextInterface, regionID string) (*Region, error) {
+	region, err := getRegion(ctx, regionID)
+	if err != nil {
+		return nil, err
+	}
+	if region == nil {
+		return nil, fmt.Errorf("the region %s does not exist", regionID)
+	}
+	return region, nil
+}
+
+// GetAllRegions returns every region with a recorded channel
+func (s *SmartContract) GetAllRegions(ctx contractapi.TransactionContextInterface) ([]*Region, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(regionIndex, []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var regions []*Region
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		var region Region
+		err = json.Unmarshal(queryResponse.Value, &region)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, &region)
+	}
+
+	return regions, nil
+}
+
+// routeFor returns the regional chaincode and channel serving a hospital.
+// The region's recorded channel wins over the channel stored on the hospital.
+func routeFor(ctx contractapi.TransactionContextInterface, hospital *types.GlobalAsset) (*regionalRoute, error) {
+	route := &regionalRoute{ChaincodeName: hospital.RegionalCCName, Channel: hospital.Channel}
+	if hospital.Region != "" {
+		region, err := getRegion(ctx, hospital.Region)
+		if err != nil {
+			return nil, err
+		}
+		if region != nil {
+			route.Channel = region.Channel
+		}
+	}
+	if route.Channel == "" {
+		route.Channel = DefaultChannel
+	}
+
+	return route, nil
+}
+
+// regionChannel returns the channel for a hospital being registered in region, checking it
+// against the region's recorded channel
+func regionChannel(ctx contractapi.TransactionContextInterface, regionID string, channel string) (string, error) {
+	if regionID != "" {
+		region, err := getRegion(ctx, regionID)
+		if err != nil {
+			return "", err
+		}
+		if region != nil {
+			if channel != "" && channel != region.Channel {
+				return "", fmt.Errorf("the region %s is on channel %s, not %s", regionID, region.Channel, channel)
+			}
+			return region.Channel, nil
+		}
+	}
+	if channel == "" {
+		return DefaultChannel, nil
+	}
+
+	return channel, nil
+}
+
+// invokeRegional calls a regional chaincode on the route's channel.
+// Anything but a read is refused when the route leaves the current channel.
+func invokeRegional(ctx contractapi.TransactionContextInterface, route *regionalRoute, function string, args ...string) ([]byte, error) {
+	current := ctx.GetStub().GetChannelID()
+	if route.Channel != current && !regionalReadFunctions[function] {
+		return nil, &CrossChannelWriteError{Chaincode: route.ChaincodeName, Function: function, Channel: route.Channel, Current: current}
+	}
+
+	invokeArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invokeArgs = append(invokeArgs, []byte(arg))
+	}
+	// an empty channel name means the current channel to the peer
+	channel := route.Channel
+	if channel == current {
+		channel = ""
+	}
+
+	response := ctx.GetStub().InvokeChaincode(route.ChaincodeName, invokeArgs, channel)
+	if response.GetStatus() != shim.OK {
+		return nil, fmt.Errorf("failed to call %s on %s (channel %s): %s", function, route.ChaincodeName, route.Channel, response.GetMessage())
+	}
+
+	return response.Payload, nil
+}
+
+func getRegion(ctx contractapi.TransactionContextInterface, regionID string) (*Region, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(regionIndex, []string{regionID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	regionJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if regionJSON == nil {
+		return nil, nil
+	}
+
+	var region Region
+	err = json.Unmarshal(regionJSON, &region)
+	if err != nil {
+		return nil, err
+	}
+	return &region, nil
+}
+
+func putRegion(ctx contractapi.TransactionContextInterface, region *Region) error {
+	key, err := ctx.GetStub().CreateCompositeKey(regionIndex, []string{region.RegionID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	regionJSON, err := json.Marshal(region)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, regionJSON)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// fakeRegional is a regional chaincode whose ReadAsset and ReadAssets answer with policies naming
+// the chaincode and channel it was deployed as in their metadata, so tests can tell where a call
+// was routed. HasOwnerRef answers from ownerRefs, the ownerRef hash of each asset, and ReadAssets
+// reports the policies in missing as not existing. ListAssetIDs answers with ids. functions
+// records every function called.
+type fakeRegional struct {
+	stub      *shimtest.MockStub
+	ownerRefs map[string]string
+	missing   map[string]bool
+	ids       []string
+	functions []string
+}
+
+func (f *fakeRegional) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (f *fakeRegional) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	function, args := stub.GetFunctionAndParameters()
+	f.functions = append(f.functions, function)
+	var response interface{}
+	switch function {
+	case "HasOwnerRef":
+		response = f.ownerRefs[args[0]] == args[1]
+	case "ReadAsset", "CreateAsset", "EmergencyReadAsset":
+		response = f.asset(args[0])
+	case "ReadAssets":
+		var ids []string
+		if err := json.Unmarshal([]byte(args[0]), &ids); err != nil {
+			return shim.Error(err.Error())
+		}
+		results := []*types.RegionalAssetResult{}
+		for _, id := range ids {
+			if f.missing[id] {
+				results = append(results, &types.RegionalAssetResult{PolicyID: id, Error: "the asset " + id + " does not exist"})
+				continue
+			}
+			results = append(results, &types.RegionalAssetResult{PolicyID: id, Asset: f.asset(id)})
+		}
+		response = results
+	case "ListAssetIDs":
+		response = f.ids
+	default:
+		return shim.Error("unexpected function " + function)
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(responseJSON)
+}
+
+func (f *fakeRegional) asset(id string) *types.RegionalAsset {
+	return &types.RegionalAsset{
+		SchemaVersion: types.RegionalAssetSchemaVersion,
+		ID:            id,
+		Owner:         "PATIENT 1",
+		AuthRoles:     []string{},
+		Grant:         "R",
+		Metadata:      "https://" + f.stub.Name + "." + f.stub.ChannelID,
+	}
+}
+
+// deployFakeRegional makes a fakeRegional named name on channel callable from stub. An empty
+// channel is stub's own.
+func deployFakeRegional(stub *shimtest.MockStub, name string, channel string) *fakeRegional {
+	regional := &fakeRegional{ownerRefs: map[string]string{}, missing: map[string]bool{}}
+	regional.stub = shimtest.NewMockStub(name, regional)
+	regional.stub.ChannelID = channel
+	if channel == stub.ChannelID {
+		channel = ""
+	}
+	stub.MockPeerChaincode(name, regional.stub, channel)
+	return regional
+}
+
+func TestReadRegionalAssetRouting(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	if response := invoke(stub, governingOrg, "SetRegionChannel", "region2", "region2channel"); response.Status != 200 {
+		t.Fatalf("SetRegionChannel failed: %s", response.Message)
+	}
+	if response := invoke(stub, testIdentity(t, "Org2MSP"), "SetRegionChannel", "region2", "otherchannel"); response.Status == 200 {
+		t.Fatalf("an org other than the governing org moved a region")
+	}
+
+	// HP1's region has no recorded channel and HP3's records another one than the hospital
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP1", RegionalCCName: "regionalCC1", Region: "region1"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP2", RegionalCCName: "regionalCC2", Region: "region2"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP3", RegionalCCName: "regionalCC3", Region: "region3", Channel: "region3channel"})
+	putTestHospital(t, stub, types.GlobalAsset{HospitalID: "HP4", RegionalCCName: "regionalCC2", Region: "region2", Channel: "stalechannel"})
+	deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	deployFakeRegional(stub, "regionalCC2", "region2channel")
+	deployFakeRegional(stub, "regionalCC3", "region3channel")
+
+	for _, test := range []struct {
+		hospitalID string
+		metadata   string
+	}{
+		{"HP1", "https://regionalCC1." + DefaultChannel},
+		{"HP2", "https://regionalCC2.region2channel"},
+		{"HP3", "https://regionalCC3.region3channel"},
+		{"HP4", "https://regionalCC2.region2channel"},
+	} {
+		response := invoke(stub, governingOrg, "ReadRegionalAsset", "pc1", test.hospitalID)
+		if response.Status != 200 {
+			t.Fatalf("ReadRegionalAsset at %s failed: %s", test.hospitalID, response.Message)
+		}
+		var asset types.RegionalAsset
+		if err := json.Unmarshal(response.Payload, &asset); err != nil {
+			t.Fatal(err)
+		}
+		if asset.Metadata != test.metadata {
+			t.Errorf("ReadRegionalAsset at %s was routed to %s, expected %s", test.hospitalID, asset.Metadata, test.metadata)
+		}
+	}
+}
+
+func TestInvokeRegionalRefusesCrossChannelWrites(t *testing.T) {
+	stub := newTestStub(t)
+	deployFakeRegional(stub, "regionalCC1", DefaultChannel)
+	deployFakeRegional(stub, "regionalCC2", "region2channel")
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	_, err := invokeRegional(ctx, &regionalRoute{ChaincodeName: "regionalCC2", Channel: "region2channel"}, "CreateAsset", "pc1")
+	var crossChannelErr *CrossChannelWriteError
+	if !errors.As(err, &crossChannelErr) {
+		t.Fatalf("expected a CrossChannelWriteError, got %v", err)
+	}
+	if crossChannelErr.Chaincode != "regionalCC2" || crossChannelErr.Channel != "region2channel" || crossChannelErr.Current != DefaultChannel {
+		t.Fatalf("unexpected error %+v", crossChannelErr)
+	}
+
+	// reads may cross channels, and writes may stay on the current one
+	if _, err := invokeRegional(ctx, &regionalRoute{ChaincodeName: "regionalCC2", Channel: "region2channel"}, "ReadAsset", "pc1"); err != nil {
+		t.Fatalf("cross-channel read failed: %v", err)
+	}
+	if _, err := invokeRegional(ctx, &regionalRoute{ChaincodeName: "regionalCC1", Channel: DefaultChannel}, "CreateAsset", "pc1"); err != nil {
+		t.Fatalf("same-channel write failed: %v", err)
+	}
+}
+
+func TestInitLedgerKeepsRegionChannels(t *testing.T) {
+	stub := newTestStub(t)
+	governingOrg := testIdentity(t, DefaultGoverningMSPID)
+	if response := invoke(stub, governingOrg, "InitLedger"); response.Status != 200 {
+		t.Fatalf("InitLedger failed: %s", response.Message)
+	}
+	if response := invoke(stub, governingOrg, "SetRegionChannel", "region2", "region2channel"); response.Status != 200 {
+		t.Fatalf("SetRegionChannel failed: %s", response.Message)
+	}
+	if response := invoke(stub, governingOrg, "InitLedger"); response.Status != 200 {
+		t.Fatalf("re-running InitLedger failed: %s", response.Message)
+	}
+
+	for regionID, channel := range map[string]string{"region1": DefaultChannel, "region2": "region2channel"} {
+		response := invoke(stub, governingOrg, "ReadRegion", regionID)
+		var region Region
+		if err := json.Unmarshal(response.Payload, &region); err != nil || region.Channel != channel {
+			t.Errorf("expected %s on %s, got %d %s", regionID, channel, response.Status, response.Payload)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GoverningMSPIDEnv overrides DefaultGoverningMSPID
+const GoverningMSPIDEnv = "GOVERNING_MSPID"
+
+// DefaultGoverningMSPID is the org allowed to change the hospital registry
+const DefaultGoverningMSPID = "Org1MSP"
+
+// DefaultChannel is used for hospitals registered without a channel in a region that has none recorded
+const DefaultChannel = "mychannel"
+
+// HospitalUnavailableError is returned when a read is routed to a hospital that is not active
+type HospitalUnavailableError struct {
+	HospitalID string
+	Status     string
+	Reason     string
+}
+
+func (e *HospitalUnavailableError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("the hospital %s is %s", e.HospitalID, e.Status)
+	}
+	return fmt.Sprintf("the hospital %s is %s: %s", e.HospitalID, e.Status, e.Reason)
+}
+
+// RegisterHospital adds an active hospital to the registry.
+// An empty channel takes the region's channel; a channel that differs from it is rejected.
+// types.SeedHospitalID is reserved for the policy IDs regional chaincodes seed.
+func (s *SmartContract) RegisterHospital(ctx contractapi.TransactionContextInterface, hospitalID string, name string, mspID string, region string, rccName string, channel string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if hospitalID == types.SeedHospitalID {
+		return fmt.Errorf("the hospital ID %s is reserved for seeded policy IDs", types.SeedHospitalID)
+	}
+	exists, err := s.AssetExists(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the hospital %s is already registered", hospitalID)
+	}
+	channel, err = regionChannel(ctx, region, channel)
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+	hospital := types.GlobalAsset{
+		SchemaVersion:  types.GlobalAssetSchemaVersion,
+		HospitalID:     hospitalID,
+		RegionalCCName: rccName,
+		Name:           name,
+		MSPID:          mspID,
+		Region:         region,
+		Channel:        channel,
+		Status:         types.HospitalActive,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	return putHospital(ctx, &hospital)
+}
+
+// SuspendHospital stops reads from being routed to an active hospital
+func (s *SmartContract) SuspendHospital(ctx contractapi.TransactionContextInterface, hospitalID string, reason string) error {
+	return s.changeHospitalStatus(ctx, hospitalID, types.HospitalActive, types.HospitalSuspended, reason)
+}
+
+// ReinstateHospital makes a suspended hospital active again
+func (s *SmartContract) ReinstateHospital(ctx contractapi.TransactionContextInterface, hospitalID string, reason string) error {
+	return s.changeHospitalStatus(ctx, hospitalID, types.HospitalSuspended, types.HospitalActive, reason)
+}
+
+// DecommissionHospital permanently retires a hospital; it cannot be reinstated
+func (s *SmartContract) DecommissionHospital(ctx contractapi.TransactionContextInterface, hospitalID string, reason string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required")
+	}
+
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	if hospital.Status == types.HospitalDecommissioned {
+		return fmt.Errorf("the hospital %s is already decommissioned", hospitalID)
+	}
+
+	return updateHospital(ctx, hospital, types.HospitalDecommissioned, reason)
+}
+
+// ReassignHospital moves a hospital to another region, regional chaincode and channel
+func (s *SmartContract) ReassignHospital(ctx contractapi.TransactionContextInterface, hospitalID string, region string, rccName string, channel string, reason string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required")
+	}
+
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	if hospital.Status == types.HospitalDecommissioned {
+		return fmt.Errorf("the hospital %s is decommissioned", hospitalID)
+	}
+	channel, err = regionChannel(ctx, region, channel)
+	if err != nil {
+		return err
+	}
+
+	hospital.Region = region
+	hospital.RegionalCCName = rccName
+	hospital.Channel = channel
+	return updateHospital(ctx, hospital, hospital.Status, reason)
+}
+
+func (s *SmartContract) changeHospitalStatus(ctx contractapi.TransactionContextInterface, hospitalID string, from string, to string, reason string) error {
+	err := requireGoverningOrg(ctx)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return fmt.Errorf("a reason is required")
+	}
+
+	hospital, err := s.ReadAsset(ctx, hospitalID)
+	if err != nil {
+		return err
+	}
+	if hospital.Status != from {
+		return fmt.Errorf("the hospital %s is %s, expected %s", hospitalID, hospital.Status, from)
+	}
+
+	return updateHospital(ctx, hospital, to, reason)
+}
+
+// checkRoutable returns a HospitalUnavailableError unless the hospital is active
+func checkRoutable(hospital *types.GlobalAsset) error {
+	if hospital.Status != types.HospitalActive {
+		return &HospitalUnavailableError{HospitalID: hospital.HospitalID, Status: hospital.Status, Reason: hospital.Reason}
+	}
+	return nil
+}
+
+// requireGoverningOrg fails unless the client belongs to the governing org
+func requireGoverningOrg(ctx contractapi.TransactionContextInterface) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	if mspID != governingMSPID() {
+		return fmt.Errorf("only %s may change the hospital registry, caller is %s", governingMSPID(), mspID)
+	}
+	return nil
+}
+
+// governingMSPID returns the governing org's MSP ID
+func governingMSPID() string {
+	if mspID := os.Getenv(GoverningMSPIDEnv); mspID != "" {
+		return mspID
+	}
+	return DefaultGoverningMSPID
+}
+
+// updateHospital sets status and reason, stamps UpdatedAt and stores the hospital
+func updateHospital(ctx contractapi.TransactionContextInterface, hospital *types.GlobalAsset, status string, reason string) error {
+	now, err := txTime(ctx)
+	if err != nil {
+		return err
+	}
+
+	hospital.SchemaVersion = types.GlobalAssetSchemaVersion
+	hospital.Status = status
+	hospital.Reason = reason
+	hospital.UpdatedAt = now
+	return putHospital(ctx, hospital)
+}
+
+func putHospital(ctx contractapi.TransactionContextInterface, hospital *types.GlobalAsset) error {
+	err := hospital.Validate()
+	if err != nil {
+		return err
+	}
+	hospitalJSON, err := json.Marshal(hospital)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(hospital.HospitalID, hospitalJSON)
+}
+
+// txTime returns the transaction timestamp in RFC 3339 form, which is the same on every endorser
+func txTime(ctx contractapi.TransactionContextInterface) (string, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC().Format(time.RFC3339Nano), nil
+}
@@ -0,0 +1,174 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Grant values stored in RegionalAsset.Grant
+const (
+	GrantRead      = "R"
+	GrantWrite     = "W"
+	GrantReadWrite = "RW"
+)
+
+// RoleAttribute is the certificate attribute that carries the caller's role
+const RoleAttribute = "role"
+
+// Access error codes returned in AccessError.Code
+const (
+	CodeAccessDenied     = "ACCESS_DENIED"
+	CodeMissingAttribute = "MISSING_ATTRIBUTE"
+	CodeInvalidIdentity  = "INVALID_IDENTITY"
+)
+
+// AccessError is returned when the caller may not perform an operation on an asset.
+// Its message is JSON so clients can decode the reason instead of matching strings.
+type AccessError struct {
+	Code      string `json:"code"`
+	AssetID   string `json:"assetID"`
+	Operation string `json:"operation"`
+	MSPID     string `json:"mspID,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+func (e *AccessError) Error() string {
+	errJSON, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+	}
+	return string(errJSON)
+}
+
+// getCallerRole returns the MSP ID and role attribute of the submitting client
+func getCallerRole(ctx contractapi.TransactionContextInterface) (string, string, error) {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return "", "", fmt.Errorf("client identity is not available")
+	}
+
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+
+	role, found, err := identity.GetAttributeValue(RoleAttribute)
+	if err != nil {
+		return mspID, "", fmt.Errorf("failed to get client attribute %s: %v", RoleAttribute, err)
+	}
+	if !found || role == "" {
+		return mspID, "", nil
+	}
+
+	return mspID, role, nil
+}
+
+// checkAccess verifies that the caller's role is listed in the asset's AuthRoles
+// and that the asset's Grant allows the requested operation (GrantRead or GrantWrite).
+// An AuthRoles entry is "<MSPID>.<role>": a role attribute only counts in certificates issued by
+// the MSP it names, so no other org's CA can mint it.
+// Failing that, an active consent covering the transaction time gives access. Emergency reads do
+// not come through here: see EmergencyReadAsset.
+func checkAccess(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset, operation string) error {
+	mspID, role, err := getCallerRole(ctx)
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: asset.ID, Operation: operation, MSPID: mspID, Reason: err.Error()}
+	}
+
+	roleErr := checkRoleAccess(asset, operation, mspID, role)
+	if roleErr == nil {
+		return nil
+	}
+	allowed, err := consentAllows(ctx, asset, operation, mspID, role)
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: asset.ID, Operation: operation, MSPID: mspID, Role: role, Reason: err.Error()}
+	}
+	if allowed {
+		return nil
+	}
+	return roleErr
+}
+
+// checkRoleAccess applies the asset's AuthRoles and Grant to the caller's role
+func checkRoleAccess(asset *types.RegionalAsset, operation string, mspID string, role string) error {
+	if role == "" {
+		return &AccessError{
+			Code:      CodeMissingAttribute,
+			AssetID:   asset.ID,
+			Operation: operation,
+			MSPID:     mspID,
+			Reason:    fmt.Sprintf("client certificate has no %s attribute", RoleAttribute),
+		}
+	}
+
+	if !hasRole(asset.AuthRoles, mspID, role) {
+		return &AccessError{
+			Code:      CodeAccessDenied,
+			AssetID:   asset.ID,
+			Operation: operation,
+			MSPID:     mspID,
+			Role:      role,
+			Reason:    "role is not listed in authRoles",
+		}
+	}
+
+	if !grantAllows(asset.Grant, operation) {
+		return &AccessError{
+			Code:      CodeAccessDenied,
+			AssetID:   asset.ID,
+			Operation: operation,
+			MSPID:     mspID,
+			Role:      role,
+			Reason:    fmt.Sprintf("grant %q does not allow %s", asset.Grant, operation),
+		}
+	}
+
+	return nil
+}
+
+func hasRole(authRoles []string, mspID string, role string) bool {
+	for _, authRole := range authRoles {
+		if authRole == mspID+"."+role {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuthRoles returns an error unless every entry names an MSP and a role, "<MSPID>.<role>"
+func validateAuthRoles(authRoles []string) error {
+	for _, authRole := range authRoles {
+		mspID, role, found := strings.Cut(authRole, ".")
+		if !found || mspID == "" || role == "" {
+			return fmt.Errorf("invalid auth role %q, must be <MSPID>.<role>", authRole)
+		}
+	}
+	return nil
+}
+
+func grantAllows(grant string, operation string) bool {
+	switch grant {
+	case GrantReadWrite:
+		return operation == GrantRead || operation == GrantWrite
+	case GrantRead, GrantWrite:
+		return grant == operation
+	default:
+		return false
+	}
+}
+
+// validateGrant returns an error when grant is not one of GrantRead, GrantWrite or GrantReadWrite
+func validateGrant(grant string) error {
+	switch grant {
+	case GrantRead, GrantWrite, GrantReadWrite:
+		return nil
+	default:
+		return fmt.Errorf("invalid grant %q, must be one of %s, %s, %s", grant, GrantRead, GrantWrite, GrantReadWrite)
+	}
+}
@@ -0,0 +1,432 @@
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// attributeOID is the certificate extension in which Fabric CA issues identity attributes
+var attributeOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testIdentity is a serialized Fabric identity whose certificate carries attrs and, when given, the
+// organizational unit ou (e.g. "admin")
+func testIdentity(t *testing.T, mspID string, ou string, attrs map[string]string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test", Organization: []string{mspID}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if ou != "" {
+		template.Subject.OrganizationalUnit = []string{ou}
+	}
+	if attrs != nil {
+		attrsJSON, err := json.Marshal(map[string]interface{}{"attrs": attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributeOID, Value: attrsJSON}}
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
+
+// newTestStub returns a MockStub of the regional chaincode configured as region1
+func newTestStub(t *testing.T) *shimtest.MockStub {
+	t.Helper()
+
+	t.Setenv(RegionConfigEnv, `{"regionID":"region1","defaultRoles":["Org1MSP.DoctorReg1"],"seed":{"owner":"PATIENT 1","metadata":"https://example.com"}}`)
+	regionalChaincode, err := contractapi.NewChaincode(&SmartContract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := shimtest.NewMockStub("regionalCC1", regionalChaincode)
+	stub.ChannelID = "region1channel"
+	return stub
+}
+
+// putTestAsset stores a public asset, as a record written before private data, with no access check
+func putTestAsset(t *testing.T, stub *shimtest.MockStub, asset types.RegionalAsset) {
+	t.Helper()
+
+	asset.SchemaVersion = types.RegionalAssetSchemaVersion
+	assetJSON, err := json.Marshal(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.MockTransactionStart("seed")
+	defer stub.MockTransactionEnd("seed")
+	if err := stub.PutState(asset.ID, assetJSON); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invoke calls function as creator and returns the chaincode response
+func invoke(stub *shimtest.MockStub, creator []byte, function string, args ...string) peer.Response {
+	stub.Creator = creator
+	invocation := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invocation = append(invocation, []byte(arg))
+	}
+	return stub.MockInvoke("tx-"+function, invocation)
+}
+
+// privateStub is a MockStub that also deletes, purges and lists private data, which MockStub
+// lacks. Its invoke runs the regional chaincode on the privateStub itself.
+type privateStub struct {
+	*shimtest.MockStub
+	chaincode shim.Chaincode
+	args      [][]byte
+}
+
+// newPrivateTestStub is newTestStub as a privateStub
+func newPrivateTestStub(t *testing.T) *privateStub {
+	t.Helper()
+
+	stub := newTestStub(t)
+	regionalChaincode, err := contractapi.NewChaincode(&SmartContract{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &privateStub{MockStub: stub, chaincode: regionalChaincode}
+}
+
+func (stub *privateStub) DelPrivateData(collection string, key string) error {
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+func (stub *privateStub) PurgePrivateData(collection string, key string) error {
+	return stub.DelPrivateData(collection, key)
+}
+
+func (stub *privateStub) GetPrivateDataByPartialCompositeKey(collection string, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for key := range stub.PvtState[collection] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	results := &privateIterator{}
+	for _, key := range keys {
+		results.kvs = append(results.kvs, &queryresult.KV{Namespace: stub.Name, Key: key, Value: stub.PvtState[collection][key]})
+	}
+	return results, nil
+}
+
+// invoke calls function as creator with the transient map transient
+func (stub *privateStub) invoke(creator []byte, transient map[string][]byte, function string, args ...string) peer.Response {
+	stub.Creator = creator
+	stub.TransientMap = transient
+	invocation := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		invocation = append(invocation, []byte(arg))
+	}
+	stub.args = invocation
+	stub.MockTransactionStart("tx-" + function)
+	defer stub.MockTransactionEnd("tx-" + function)
+	return stub.chaincode.Invoke(stub)
+}
+
+func (stub *privateStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *privateStub) GetStringArgs() []string {
+	args := make([]string, 0, len(stub.args))
+	for _, arg := range stub.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (stub *privateStub) GetFunctionAndParameters() (string, []string) {
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+type privateIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *privateIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *privateIterator) Close() error  { return nil }
+
+func (it *privateIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+// fakeGlobal is a globalcc whose GetPolicyAllocation allocates every policy ID to the region it names
+type fakeGlobal struct{}
+
+func (f *fakeGlobal) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	return shim.Success(nil)
+}
+
+func (f *fakeGlobal) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
+	function, args := stub.GetFunctionAndParameters()
+	if function != allocationFunction {
+		return shim.Error("unexpected function " + function)
+	}
+	region, hospitalID, ok := types.ParsePolicyID(args[0])
+	if !ok {
+		return shim.Error("the policy ID " + args[0] + " was not allocated")
+	}
+	allocationJSON, err := json.Marshal(types.PolicyAllocation{PolicyID: args[0], HospitalID: hospitalID, Region: region})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(allocationJSON)
+}
+
+// deployFakeGlobal makes a fakeGlobal callable from stub as the region's globalcc
+func deployFakeGlobal(stub *shimtest.MockStub) {
+	global := shimtest.NewMockStub(DefaultGlobalChaincode, &fakeGlobal{})
+	global.ChannelID = stub.ChannelID
+	stub.MockPeerChaincode(DefaultGlobalChaincode, global, "")
+}
+
+// createTestAsset creates the asset id of patient ownerRef, named owner, with CreateAsset. The patient
+// key is held in the collection.
+func createTestAsset(t *testing.T, stub *privateStub, creator []byte, id string, ownerRef string, owner string) {
+	t.Helper()
+
+	properties, _ := json.Marshal(assetProperties{Owner: owner, Metadata: "https://example.com/" + id, Salt: "0123456789abcdef"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: ownerRef, Key: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")), Custody: CustodyCollection})
+	response := stub.invoke(creator, map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patient}, "CreateAsset", id, `["Org1MSP.DoctorReg1"]`, GrantReadWrite)
+	if response.Status != 200 {
+		t.Fatalf("CreateAsset %s failed: %s", id, response.Message)
+	}
+}
+
+// accessErrorOf decodes the AccessError a denied call returns as its message
+func accessErrorOf(t *testing.T, response peer.Response) AccessError {
+	t.Helper()
+
+	if response.Status == 200 {
+		t.Fatalf("expected the call to be denied, got %s", response.Payload)
+	}
+	var accessErr AccessError
+	if err := json.Unmarshal([]byte(response.Message), &accessErr); err != nil {
+		t.Fatalf("expected a JSON AccessError, got %q", response.Message)
+	}
+	return accessErr
+}
+
+func TestReadAssetAllowed(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1", "Org2MSP.Nurse"}, Grant: GrantRead, Metadata: "https://example.com"})
+
+	for _, caller := range []struct {
+		name  string
+		mspID string
+		role  string
+	}{
+		{"role of the first MSP", "Org1MSP", "DoctorReg1"},
+		{"role of the second MSP", "Org2MSP", "Nurse"},
+	} {
+		t.Run(caller.name, func(t *testing.T) {
+			response := invoke(stub, testIdentity(t, caller.mspID, "client", map[string]string{RoleAttribute: caller.role}), "ReadAsset", "pc1")
+			if response.Status != 200 {
+				t.Fatalf("ReadAsset failed: %s", response.Message)
+			}
+			var asset types.RegionalAsset
+			if err := json.Unmarshal(response.Payload, &asset); err != nil {
+				t.Fatal(err)
+			}
+			if asset.ID != "pc1" || asset.Owner != "PATIENT 1" {
+				t.Fatalf("unexpected asset %+v", asset)
+			}
+		})
+	}
+}
+
+func TestReadAssetDenied(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1", "Org2MSP.Nurse"}, Grant: GrantRead, Metadata: "https://example.com"})
+
+	for _, caller := range []struct {
+		name  string
+		mspID string
+		role  string
+	}{
+		{"unlisted role", "Org1MSP", "DoctorReg2"},
+		{"role of another MSP", "Org1MSP", "Nurse"},
+		{"role minted by another MSP's CA", "Org3MSP", "DoctorReg1"},
+	} {
+		t.Run(caller.name, func(t *testing.T) {
+			response := invoke(stub, testIdentity(t, caller.mspID, "client", map[string]string{RoleAttribute: caller.role}), "ReadAsset", "pc1")
+			accessErr := accessErrorOf(t, response)
+			if accessErr.Code != CodeAccessDenied || accessErr.AssetID != "pc1" || accessErr.Operation != GrantRead || accessErr.Role != caller.role {
+				t.Fatalf("unexpected access error %+v", accessErr)
+			}
+		})
+	}
+}
+
+func TestUpdateAssetDeniedByGrant(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com", Version: 1})
+	properties, _ := json.Marshal(assetProperties{Owner: "PATIENT 1", Metadata: "https://example.org", Salt: "0123456789abcdef"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "patient-1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyOffChain})
+	stub.TransientMap = map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patient}
+
+	response := invoke(stub, testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"}), "UpdateAsset", "pc1", `["Org1MSP.DoctorReg1"]`, GrantRead, "1")
+	accessErr := accessErrorOf(t, response)
+	if accessErr.Code != CodeAccessDenied || accessErr.Operation != GrantWrite {
+		t.Fatalf("unexpected access error %+v", accessErr)
+	}
+}
+
+func TestReadAssetMissingAttribute(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantReadWrite, Metadata: "https://example.com"})
+
+	for _, attrs := range []map[string]string{nil, {"hf.EnrollmentID": "user1"}, {RoleAttribute: ""}} {
+		response := invoke(stub, testIdentity(t, "Org1MSP", "client", attrs), "ReadAsset", "pc1")
+		accessErr := accessErrorOf(t, response)
+		if accessErr.Code != CodeMissingAttribute || accessErr.MSPID != "Org1MSP" || accessErr.Role != "" {
+			t.Fatalf("attrs %v: unexpected access error %+v", attrs, accessErr)
+		}
+	}
+}
+
+func TestGetAllAssetsRedactsUnreadable(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com"})
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc2", Owner: "PATIENT 2", AuthRoles: []string{"Org1MSP.DoctorReg2"}, Grant: GrantRead, Metadata: "https://example.org"})
+
+	response := invoke(stub, testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"}), "GetAllAssets")
+	if response.Status != 200 {
+		t.Fatalf("GetAllAssets failed: %s", response.Message)
+	}
+	var assets []*types.RegionalAsset
+	if err := json.Unmarshal(response.Payload, &assets); err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 2 || assets[0].Owner != "PATIENT 1" || assets[0].Metadata != "https://example.com" {
+		t.Fatalf("expected the readable asset in full, got %s", response.Payload)
+	}
+	if assets[1].ID != "pc2" || assets[1].Owner != "" || assets[1].Metadata != "" || len(assets[1].Attachments) != 0 {
+		t.Fatalf("expected the unreadable asset with its public fields only, got %s", response.Payload)
+	}
+}
+
+func TestUpdateAssetRejectsUnqualifiedRoles(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantReadWrite, Metadata: "https://example.com", Version: 1})
+	properties, _ := json.Marshal(assetProperties{Owner: "PATIENT 1", Metadata: "https://example.org", Salt: "0123456789abcdef"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "patient-1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyOffChain})
+	stub.TransientMap = map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patient}
+
+	response := invoke(stub, testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"}), "UpdateAsset", "pc1", `["DoctorReg1"]`, GrantReadWrite, "1")
+	if response.Status == 200 || !strings.Contains(response.Message, "<MSPID>.<role>") {
+		t.Fatalf("expected a bare role to be refused, got %d %s", response.Status, response.Message)
+	}
+}
+
+func TestUpdateAssetDefaultsEmptyAuthRoles(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	deployFakeGlobal(stub.MockStub)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	id := types.NewPolicyID("region1", "HP1", 1)
+	createTestAsset(t, stub, doctor, id, "patient-1", "PATIENT 1")
+	properties, _ := json.Marshal(assetProperties{Owner: "PATIENT 1", Metadata: "https://example.org", Salt: "0123456789abcdef"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "patient-1", Key: base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")), Custody: CustodyCollection})
+
+	response := stub.invoke(doctor, map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patient}, "UpdateAsset", id, `[]`, GrantReadWrite, "1")
+	if response.Status != 200 {
+		t.Fatalf("UpdateAsset failed: %s", response.Message)
+	}
+	response = stub.invoke(doctor, nil, "ReadAsset", id)
+	var asset types.RegionalAsset
+	if err := json.Unmarshal(response.Payload, &asset); response.Status != 200 || err != nil {
+		t.Fatalf("expected the updated asset to stay readable, got %d %s", response.Status, response.Message)
+	}
+	if len(asset.AuthRoles) != 1 || asset.AuthRoles[0] != "Org1MSP.DoctorReg1" {
+		t.Errorf("expected the region's default roles, got %v", asset.AuthRoles)
+	}
+}
+
+func TestDeleteAssetRequiresWriteAccess(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	deployFakeGlobal(stub.MockStub)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	id := types.NewPolicyID("region1", "HP1", 1)
+	createTestAsset(t, stub, doctor, id, "patient-1", "PATIENT 1")
+	putTestAsset(t, stub.MockStub, types.RegionalAsset{ID: "pc2", Owner: "PATIENT 2", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com"})
+
+	for _, test := range []struct {
+		name    string
+		creator []byte
+		id      string
+	}{
+		{"no role on the asset", testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"}), id},
+		{"a read-only grant", doctor, "pc2"},
+	} {
+		accessErr := accessErrorOf(t, stub.invoke(test.creator, nil, "DeleteAsset", test.id))
+		if accessErr.Code != CodeAccessDenied || accessErr.Operation != GrantWrite {
+			t.Errorf("%s: unexpected access error %+v", test.name, accessErr)
+		}
+	}
+	if stub.State[id] == nil || stub.State["pc2"] == nil {
+		t.Fatalf("a denied DeleteAsset removed the asset")
+	}
+
+	if response := stub.invoke(doctor, nil, "DeleteAsset", id); response.Status != 200 {
+		t.Fatalf("DeleteAsset failed: %s", response.Message)
+	}
+	if stub.State[id] != nil || stub.PvtState["region1PrivateCollection"][id] != nil {
+		t.Errorf("DeleteAsset left the asset behind")
+	}
+}
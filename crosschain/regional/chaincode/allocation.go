@@ -0,0 +1,67 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// allocationFunction is the globalcc transaction that returns the allocation of a policy ID
+const allocationFunction = "GetPolicyAllocation"
+
+// ListAssetIDs returns the ID of every asset in world state, for globalcc's ScanPolicyIDConflicts.
+// IDs are public state keys, so no access check applies.
+func (s *SmartContract) ListAssetIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, queryResponse.Key)
+	}
+
+	return ids, nil
+}
+
+// checkAllocated fails unless globalcc's AllocatePolicyID issued id for a hospital of this region
+func checkAllocated(ctx contractapi.TransactionContextInterface, id string) error {
+	config, err := getRegionConfig(ctx)
+	if err != nil {
+		return err
+	}
+	region, _, ok := types.ParsePolicyID(id)
+	if !ok || region != config.RegionID {
+		return fmt.Errorf("the policy ID %s was not allocated for region %s, allocate one with %s AllocatePolicyID", id, config.RegionID, config.GlobalChaincode)
+	}
+
+	// an empty channel name means the current channel to the peer
+	channel := config.GlobalChannel
+	if channel == ctx.GetStub().GetChannelID() {
+		channel = ""
+	}
+	response := ctx.GetStub().InvokeChaincode(config.GlobalChaincode, [][]byte{[]byte(allocationFunction), []byte(id)}, channel)
+	if response.GetStatus() != shim.OK {
+		return fmt.Errorf("the policy ID %s was not allocated by %s: %s", id, config.GlobalChaincode, response.GetMessage())
+	}
+
+	var allocation types.PolicyAllocation
+	err = json.Unmarshal(response.GetPayload(), &allocation)
+	if err != nil {
+		return fmt.Errorf("failed to decode the allocation of %s: %v", id, err)
+	}
+	if allocation.PolicyID != id || allocation.Region != config.RegionID {
+		return fmt.Errorf("the policy ID %s was allocated for region %s, not %s", id, allocation.Region, config.RegionID)
+	}
+	return nil
+}
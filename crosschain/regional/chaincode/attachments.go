@@ -0,0 +1,138 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransientAttachmentKey carries the JSON types.Attachment for AddAttachment, so its URI never reaches the block
+const TransientAttachmentKey = "attachment"
+
+// AddAttachment adds the attachment passed under TransientAttachmentKey to an asset. Its sha256,
+// mediaType and size are required and createdAt is set to the transaction time. The caller needs
+// write access; the ID must be new to the asset and may not be types.LegacyAttachmentID.
+// expectedVersion works as in UpdateAsset.
+func (s *SmartContract) AddAttachment(ctx contractapi.TransactionContextInterface, id string, expectedVersion int) (*types.Attachment, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	attachmentJSON, ok := transient[TransientAttachmentKey]
+	if !ok {
+		return nil, fmt.Errorf("the attachment must be passed in the transient map under %q", TransientAttachmentKey)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(attachmentJSON))
+	decoder.DisallowUnknownFields()
+	var attachment types.Attachment
+	err = decoder.Decode(&attachment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", TransientAttachmentKey, err)
+	}
+
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAccess(ctx, asset, GrantWrite)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVersion(asset, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	err = appendAttachment(ctx, asset, attachment)
+	if err != nil {
+		return nil, err
+	}
+
+	err = storeAsset(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	return &asset.Attachments[len(asset.Attachments)-1], nil
+}
+
+// RemoveAttachment removes an attachment from an asset. The caller needs write access.
+// Removing types.LegacyAttachmentID clears the Metadata URL and Document digest it mirrors.
+// expectedVersion works as in UpdateAsset.
+func (s *SmartContract) RemoveAttachment(ctx contractapi.TransactionContextInterface, id string, attachmentID string, expectedVersion int) error {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = checkAccess(ctx, asset, GrantWrite)
+	if err != nil {
+		return err
+	}
+	err = checkVersion(asset, expectedVersion)
+	if err != nil {
+		return err
+	}
+	index := asset.FindAttachment(attachmentID)
+	if index < 0 {
+		return fmt.Errorf("the attachment %s does not exist on asset %s", attachmentID, id)
+	}
+
+	if attachmentID == types.LegacyAttachmentID {
+		asset.Metadata = ""
+		asset.Document = nil
+	}
+	asset.Attachments = append(asset.Attachments[:index:index], asset.Attachments[index+1:]...)
+	return storeAsset(ctx, asset)
+}
+
+// ListAttachments returns the attachments of an asset the caller may read
+func (s *SmartContract) ListAttachments(ctx contractapi.TransactionContextInterface, id string) ([]types.Attachment, error) {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = checkAccess(ctx, asset, GrantRead)
+	if err != nil {
+		return nil, err
+	}
+
+	if asset.Attachments == nil {
+		return []types.Attachment{}, nil
+	}
+	return asset.Attachments, nil
+}
+
+// appendAttachment checks a new attachment, stamps it with the transaction time and appends it to the asset
+func appendAttachment(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset, attachment types.Attachment) error {
+	if attachment.ID == types.LegacyAttachmentID {
+		return fmt.Errorf("the attachment ID %s is reserved for the metadata URL", types.LegacyAttachmentID)
+	}
+	if attachment.SHA256 == "" {
+		return fmt.Errorf("attachment %s: sha256, mediaType and size are required", attachment.ID)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	attachment.CreatedAt = formatTime(now)
+	err = attachment.Validate()
+	if err != nil {
+		return fmt.Errorf("attachment %s: %v", attachment.ID, err)
+	}
+	if asset.FindAttachment(attachment.ID) >= 0 {
+		return fmt.Errorf("the attachment %s already exists on asset %s", attachment.ID, asset.ID)
+	}
+	if len(asset.Attachments) >= types.MaxAttachments {
+		return fmt.Errorf("an asset may have at most %d attachments", types.MaxAttachments)
+	}
+
+	asset.Attachments = append(asset.Attachments, attachment)
+	return nil
+}
+
+// attachmentField is the sealField name of an attachment's URI
+func attachmentField(attachmentID string) string {
+	return "attachment/" + attachmentID
+}
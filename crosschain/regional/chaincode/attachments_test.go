@@ -0,0 +1,166 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+// attachmentOf is the transient attachment JSON of a PDF with the given ID and URI
+func attachmentOf(t *testing.T, id string, uri string) []byte {
+	t.Helper()
+
+	attachmentJSON, err := json.Marshal(types.Attachment{ID: id, URI: uri, SHA256: strings.Repeat("ab", 32), MediaType: "application/pdf", Size: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attachmentJSON
+}
+
+// listAttachments lists the attachments of id as creator
+func listAttachments(t *testing.T, stub *privateStub, creator []byte, id string) []types.Attachment {
+	t.Helper()
+
+	response := stub.invoke(creator, nil, "ListAttachments", id)
+	if response.Status != 200 {
+		t.Fatalf("ListAttachments failed: %s", response.Message)
+	}
+	var attachments []types.Attachment
+	if err := json.Unmarshal(response.Payload, &attachments); err != nil {
+		t.Fatal(err)
+	}
+	return attachments
+}
+
+func TestAddAttachment(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	deployFakeGlobal(stub.MockStub)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	id := types.NewPolicyID("region1", "HP1", 1)
+	createTestAsset(t, stub, doctor, id, "patient-1", "PATIENT 1")
+
+	for _, test := range []struct {
+		name       string
+		creator    []byte
+		attachment []byte
+		version    string
+		message    string
+	}{
+		{"no attachment", doctor, nil, "1", "transient map"},
+		{"an unknown field", doctor, []byte(`{"id":"scan","uri":"https://example.com/scan","owner":"PATIENT 1"}`), "1", "unknown field"},
+		{"the reserved ID", doctor, attachmentOf(t, types.LegacyAttachmentID, "https://example.com/scan"), "1", "reserved"},
+		{"no digest", doctor, []byte(`{"id":"scan","uri":"https://example.com/scan"}`), "1", "are required"},
+		{"a relative URI", doctor, attachmentOf(t, "scan", "scans/1"), "1", "absolute URI"},
+		{"a new attachment", doctor, attachmentOf(t, "scan", "https://example.com/scan"), "1", ""},
+		{"a duplicate ID", doctor, attachmentOf(t, "scan", "https://example.com/other"), "2", "already exists"},
+		{"a stale version", doctor, attachmentOf(t, "lab", "https://example.com/lab"), "1", CodeVersionConflict},
+		{"no write access", nurse, attachmentOf(t, "lab", "https://example.com/lab"), "2", CodeAccessDenied},
+	} {
+		transient := map[string][]byte{}
+		if test.attachment != nil {
+			transient[TransientAttachmentKey] = test.attachment
+		}
+		response := stub.invoke(test.creator, transient, "AddAttachment", id, test.version)
+		if test.message == "" {
+			if response.Status != 200 {
+				t.Fatalf("%s: AddAttachment failed: %s", test.name, response.Message)
+			}
+			continue
+		}
+		if response.Status == 200 || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: expected AddAttachment to be refused with %q, got %d %s", test.name, test.message, response.Status, response.Message)
+		}
+	}
+
+	// the URI is sealed: neither public state nor the collection holds it in clear
+	for key, value := range stub.State {
+		if strings.Contains(string(value), "example.com/scan") {
+			t.Errorf("public state key %q holds the attachment URI", key)
+		}
+	}
+	for key, value := range stub.PvtState["region1PrivateCollection"] {
+		if strings.Contains(string(value), "example.com/scan") {
+			t.Errorf("collection key %q holds the attachment URI in clear", key)
+		}
+	}
+
+	attachments := listAttachments(t, stub, doctor, id)
+	if len(attachments) != 2 || attachments[0].ID != types.LegacyAttachmentID || attachments[1].ID != "scan" {
+		t.Fatalf("unexpected attachments %+v", attachments)
+	}
+	if scan := attachments[1]; scan.URI != "https://example.com/scan" || scan.CreatedAt == "" || scan.Size != 1024 {
+		t.Errorf("unexpected attachment %+v", scan)
+	}
+	if accessErr := accessErrorOf(t, stub.invoke(nurse, nil, "ListAttachments", id)); accessErr.Code != CodeAccessDenied {
+		t.Errorf("expected %s listing without read access, got %+v", CodeAccessDenied, accessErr)
+	}
+}
+
+func TestAddAttachmentLimit(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	asset := types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantReadWrite, Version: 1}
+	for i := 0; i < types.MaxAttachments; i++ {
+		asset.Attachments = append(asset.Attachments, types.Attachment{ID: fmt.Sprintf("a%d", i), URI: "https://example.com/" + strconv.Itoa(i)})
+	}
+	putTestAsset(t, stub.MockStub, asset)
+
+	response := stub.invoke(doctor, map[string][]byte{TransientAttachmentKey: attachmentOf(t, "one-more", "https://example.com/more")}, "AddAttachment", "pc1", "1")
+	if response.Status == 200 || !strings.Contains(response.Message, "at most") {
+		t.Errorf("expected attachment %d to be refused, got %d %s", types.MaxAttachments+1, response.Status, response.Message)
+	}
+}
+
+func TestRemoveAttachment(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	deployFakeGlobal(stub.MockStub)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	id := types.NewPolicyID("region1", "HP1", 1)
+	createTestAsset(t, stub, doctor, id, "patient-1", "PATIENT 1")
+	if response := stub.invoke(doctor, map[string][]byte{TransientAttachmentKey: attachmentOf(t, "scan", "https://example.com/scan")}, "AddAttachment", id, "1"); response.Status != 200 {
+		t.Fatalf("AddAttachment failed: %s", response.Message)
+	}
+
+	for _, test := range []struct {
+		name         string
+		creator      []byte
+		attachmentID string
+		version      string
+		message      string
+	}{
+		{"an unknown attachment", doctor, "lab", "2", "does not exist"},
+		{"a stale version", doctor, "scan", "1", CodeVersionConflict},
+		{"no write access", nurse, "scan", "2", CodeAccessDenied},
+	} {
+		response := stub.invoke(test.creator, nil, "RemoveAttachment", id, test.attachmentID, test.version)
+		if response.Status == 200 || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: expected RemoveAttachment to be refused with %q, got %d %s", test.name, test.message, response.Status, response.Message)
+		}
+	}
+
+	if response := stub.invoke(doctor, nil, "RemoveAttachment", id, "scan", "2"); response.Status != 200 {
+		t.Fatalf("RemoveAttachment failed: %s", response.Message)
+	}
+	if attachments := listAttachments(t, stub, doctor, id); len(attachments) != 1 || attachments[0].ID != types.LegacyAttachmentID {
+		t.Fatalf("expected only the metadata attachment to remain, got %+v", attachments)
+	}
+
+	// removing the legacy attachment clears the Metadata URL it mirrors
+	if response := stub.invoke(doctor, nil, "RemoveAttachment", id, types.LegacyAttachmentID, "3"); response.Status != 200 {
+		t.Fatalf("RemoveAttachment %s failed: %s", types.LegacyAttachmentID, response.Message)
+	}
+	if attachments := listAttachments(t, stub, doctor, id); len(attachments) != 0 {
+		t.Errorf("expected no attachments, got %+v", attachments)
+	}
+	response := stub.invoke(doctor, nil, "ReadAsset", id)
+	var asset types.RegionalAsset
+	if err := json.Unmarshal(response.Payload, &asset); response.Status != 200 || err != nil || asset.Metadata != "" {
+		t.Errorf("expected the Metadata URL to be cleared, got %d %s %q", response.Status, response.Message, asset.Metadata)
+	}
+}
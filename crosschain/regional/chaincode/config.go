@@ -0,0 +1,209 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Environment variables read when the region has not been initialised on the ledger,
+// e.g. when running as a chaincode-as-a-service container
+const (
+	RegionConfigEnv     = "REGION_CONFIG"
+	RegionConfigFileEnv = "REGION_CONFIG_FILE"
+)
+
+// RegionAdminMSPIDEnv overrides DefaultRegionAdminMSPID
+const RegionAdminMSPIDEnv = "REGION_ADMIN_MSPID"
+
+// DefaultRegionAdminMSPID is the org whose admins may initialise the region
+const DefaultRegionAdminMSPID = "Org1MSP"
+
+// AdminOU is the organizational unit Fabric's NodeOUs give admin certificates
+const AdminOU = "admin"
+
+// regionConfigIndex is the composite key object type under which the region config is stored
+const regionConfigIndex = "config~region"
+
+// DefaultGlobalChaincode is the name globalcc is deployed under unless the region config says otherwise
+const DefaultGlobalChaincode = "globalCC"
+
+// RegionConfig identifies the region a regional chaincode instance serves.
+// PrivateCollection defaults to "<regionID>PrivateCollection", the name used in ../collections.
+// GlobalChannel is the channel of GlobalChaincode, empty when it shares this chaincode's channel.
+type RegionConfig struct {
+	RegionID          string      `json:"regionID"`
+	DefaultRoles      []string    `json:"defaultRoles"`
+	GlobalChaincode   string      `json:"globalChaincode,omitempty" metadata:",optional"`
+	GlobalChannel     string      `json:"globalChannel,omitempty" metadata:",optional"`
+	PrivateCollection string      `json:"privateCollection,omitempty" metadata:",optional"`
+	Seed              SeedProfile `json:"seed"`
+}
+
+// SeedHospitalID is the hospital part of the policy IDs InitLedger generates
+const SeedHospitalID = types.SeedHospitalID
+
+// SeedProfile describes the records generated by InitLedger
+type SeedProfile struct {
+	Owner     string         `json:"owner"`
+	Grant     string         `json:"grant"`
+	Metadata  string         `json:"metadata"`
+	Overrides []SeedOverride `json:"overrides,omitempty" metadata:",optional"`
+}
+
+// SeedOverride replaces the metadata of generated records From..To (inclusive)
+type SeedOverride struct {
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	Metadata string `json:"metadata"`
+}
+
+func (c *RegionConfig) validate() error {
+	if c.RegionID == "" {
+		return fmt.Errorf("region config has no regionID")
+	}
+	if len(c.DefaultRoles) == 0 {
+		return fmt.Errorf("region config %s has no defaultRoles", c.RegionID)
+	}
+	if err := validateAuthRoles(c.DefaultRoles); err != nil {
+		return fmt.Errorf("region config %s: %v", c.RegionID, err)
+	}
+	if c.GlobalChaincode == "" {
+		c.GlobalChaincode = DefaultGlobalChaincode
+	}
+	if c.PrivateCollection == "" {
+		c.PrivateCollection = c.RegionID + "PrivateCollection"
+	}
+	if c.Seed.Grant == "" {
+		c.Seed.Grant = GrantRead
+	}
+	return validateGrant(c.Seed.Grant)
+}
+
+// metadataFor returns the seed metadata for the i-th generated record
+func (p *SeedProfile) metadataFor(i int) string {
+	for _, override := range p.Overrides {
+		if override.From <= i && i <= override.To {
+			return override.Metadata
+		}
+	}
+	return p.Metadata
+}
+
+// InitRegion stores the region identity, default roles and seed profile given as JSON.
+// It can only be called once per chaincode instance, by an admin of the region admin org.
+func (s *SmartContract) InitRegion(ctx contractapi.TransactionContextInterface, configJSON string) error {
+	err := requireRegionAdmin(ctx)
+	if err != nil {
+		return err
+	}
+
+	var config RegionConfig
+	err = json.Unmarshal([]byte(configJSON), &config)
+	if err != nil {
+		return fmt.Errorf("failed to parse region config: %v", err)
+	}
+	err = config.validate()
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(regionConfigIndex, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("the region is already initialised")
+	}
+
+	storedJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, storedJSON)
+}
+
+// requireRegionAdmin fails unless the caller holds an admin certificate of the region admin org
+func requireRegionAdmin(ctx contractapi.TransactionContextInterface) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	cert, err := identity.GetX509Certificate()
+	if err != nil {
+		return fmt.Errorf("failed to get client certificate: %v", err)
+	}
+
+	adminMSPID := os.Getenv(RegionAdminMSPIDEnv)
+	if adminMSPID == "" {
+		adminMSPID = DefaultRegionAdminMSPID
+	}
+	if mspID != adminMSPID || cert == nil || !slices.Contains(cert.Subject.OrganizationalUnit, AdminOU) {
+		return fmt.Errorf("only an admin of %s may initialise the region, caller is %s", adminMSPID, mspID)
+	}
+	return nil
+}
+
+// GetRegionConfig returns the region config from the ledger, falling back to the environment
+func (s *SmartContract) GetRegionConfig(ctx contractapi.TransactionContextInterface) (*RegionConfig, error) {
+	return getRegionConfig(ctx)
+}
+
+func getRegionConfig(ctx contractapi.TransactionContextInterface) (*RegionConfig, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(regionConfigIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	configJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	if configJSON == nil {
+		configJSON, err = regionConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var config RegionConfig
+	err = json.Unmarshal(configJSON, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse region config: %v", err)
+	}
+	err = config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func regionConfigFromEnv() ([]byte, error) {
+	if configJSON := os.Getenv(RegionConfigEnv); configJSON != "" {
+		return []byte(configJSON), nil
+	}
+	if path := os.Getenv(RegionConfigFileEnv); path != "" {
+		configJSON, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read region config file: %v", err)
+		}
+		return configJSON, nil
+	}
+
+	return nil, fmt.Errorf("the region is not initialised, call InitRegion or set %s", RegionConfigEnv)
+}
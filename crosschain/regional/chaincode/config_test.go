@@ -0,0 +1,42 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInitRegionRequiresAdmin(t *testing.T) {
+	stub := newTestStub(t)
+	config := `{"regionID":"region2","defaultRoles":["Org1MSP.DoctorReg2"],"seed":{"owner":"PATIENT 2","metadata":"https://example.com"}}`
+
+	for _, caller := range []struct {
+		name  string
+		mspID string
+		ou    string
+	}{
+		{"client of the admin org", DefaultRegionAdminMSPID, "client"},
+		{"admin of another org", "Org2MSP", AdminOU},
+	} {
+		response := invoke(stub, testIdentity(t, caller.mspID, caller.ou, nil), "InitRegion", config)
+		if response.Status == 200 || !strings.Contains(response.Message, "only an admin of "+DefaultRegionAdminMSPID) {
+			t.Fatalf("%s: expected InitRegion to be refused, got %d %s", caller.name, response.Status, response.Message)
+		}
+	}
+
+	admin := testIdentity(t, DefaultRegionAdminMSPID, AdminOU, nil)
+	response := invoke(stub, admin, "InitRegion", config)
+	if response.Status != 200 {
+		t.Fatalf("InitRegion failed: %s", response.Message)
+	}
+	response = invoke(stub, admin, "GetRegionConfig")
+	var stored RegionConfig
+	if err := json.Unmarshal(response.Payload, &stored); err != nil || stored.RegionID != "region2" {
+		t.Fatalf("unexpected region config %s", response.Payload)
+	}
+
+	response = invoke(stub, admin, "InitRegion", config)
+	if response.Status == 200 {
+		t.Fatalf("the region was initialised twice")
+	}
+}
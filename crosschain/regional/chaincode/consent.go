@@ -0,0 +1,260 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OwnerAttribute is the certificate attribute that identifies the patient a client acts for.
+// A caller owns an asset when this attribute equals the asset's Owner.
+const OwnerAttribute = "patientID"
+
+// CodeNotOwner is returned in AccessError.Code when a consent call does not come from the owner
+const CodeNotOwner = "NOT_OWNER"
+
+// GrantConsent lets the owner of an asset give a role or identity the permissions ("R", "W")
+// from validFrom (the transaction time when empty) until validUntil, both RFC 3339.
+// The transaction ID becomes the consent ID. expectedVersion works as in UpdateAsset.
+func (s *SmartContract) GrantConsent(ctx contractapi.TransactionContextInterface, id string, granteeType string, grantee string, permissions []string, validFrom string, validUntil string, purpose string, expectedVersion int) (*types.Consent, error) {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = requireOwner(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	err = checkVersion(asset, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	from := now
+	if validFrom != "" {
+		from, err = time.Parse(time.RFC3339Nano, validFrom)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validFrom %q, expected RFC 3339: %v", validFrom, err)
+		}
+	}
+	until, err := time.Parse(time.RFC3339Nano, validUntil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid validUntil %q, expected RFC 3339: %v", validUntil, err)
+	}
+	if !until.After(from) {
+		return nil, fmt.Errorf("validUntil must be after validFrom")
+	}
+	if granteeType == types.GranteeRole {
+		if err := validateAuthRoles([]string{grantee}); err != nil {
+			return nil, err
+		}
+	}
+	for _, permission := range permissions {
+		if permission != GrantRead && permission != GrantWrite {
+			return nil, fmt.Errorf("invalid permission %q, must be %s or %s", permission, GrantRead, GrantWrite)
+		}
+	}
+
+	consent := types.Consent{
+		ConsentID:   ctx.GetStub().GetTxID(),
+		GranteeType: granteeType,
+		Grantee:     grantee,
+		Permissions: permissions,
+		ValidFrom:   formatTime(from),
+		ValidUntil:  formatTime(until),
+		Purpose:     purpose,
+		Status:      types.ConsentActive,
+		GrantedAt:   formatTime(now),
+	}
+	err = consent.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	asset.Consents = append(asset.Consents, consent)
+	err = putAsset(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+// RevokeConsent ends an active consent before its validUntil. Only the owner may revoke.
+// expectedVersion works as in UpdateAsset.
+func (s *SmartContract) RevokeConsent(ctx contractapi.TransactionContextInterface, id string, consentID string, expectedVersion int) error {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = requireOwner(ctx, asset)
+	if err != nil {
+		return err
+	}
+	err = checkVersion(asset, expectedVersion)
+	if err != nil {
+		return err
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range asset.Consents {
+		consent := &asset.Consents[i]
+		if consent.ConsentID != consentID {
+			continue
+		}
+		if consent.Status == types.ConsentRevoked {
+			return fmt.Errorf("the consent %s is already revoked", consentID)
+		}
+
+		consent.Status = types.ConsentRevoked
+		consent.RevokedAt = formatTime(now)
+		return putAsset(ctx, asset)
+	}
+
+	return fmt.Errorf("the consent %s does not exist on asset %s", consentID, id)
+}
+
+// ListConsents returns every consent on an asset, including revoked and expired ones. Only the owner may list.
+func (s *SmartContract) ListConsents(ctx contractapi.TransactionContextInterface, id string) ([]types.Consent, error) {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = requireOwner(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	if asset.Consents == nil {
+		return []types.Consent{}, nil
+	}
+	return asset.Consents, nil
+}
+
+// requireOwner fails unless the caller's OwnerAttribute matches the asset owner
+func requireOwner(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: asset.ID, Operation: GrantWrite, Reason: "client identity is not available"}
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: asset.ID, Operation: GrantWrite, Reason: err.Error()}
+	}
+
+	owner, found, err := identity.GetAttributeValue(OwnerAttribute)
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: asset.ID, Operation: GrantWrite, MSPID: mspID, Reason: err.Error()}
+	}
+	if !found || owner == "" {
+		return &AccessError{
+			Code:      CodeMissingAttribute,
+			AssetID:   asset.ID,
+			Operation: GrantWrite,
+			MSPID:     mspID,
+			Reason:    fmt.Sprintf("client certificate has no %s attribute", OwnerAttribute),
+		}
+	}
+	if owner != asset.Owner {
+		return &AccessError{Code: CodeNotOwner, AssetID: asset.ID, Operation: GrantWrite, MSPID: mspID, Reason: "only the owner may manage consents"}
+	}
+
+	return nil
+}
+
+// consentAllows reports whether an active consent on the asset gives the caller the operation
+// at the transaction time, so every endorser reaches the same answer
+func consentAllows(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset, operation string, mspID string, role string) (bool, error) {
+	if len(asset.Consents) == 0 {
+		return false, nil
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return false, err
+	}
+	identityIDs, err := callerIdentityIDs(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, consent := range asset.Consents {
+		if consent.Status != types.ConsentActive || !hasPermission(consent.Permissions, operation) {
+			continue
+		}
+		from, err := time.Parse(time.RFC3339Nano, consent.ValidFrom)
+		if err != nil {
+			return false, err
+		}
+		until, err := time.Parse(time.RFC3339Nano, consent.ValidUntil)
+		if err != nil {
+			return false, err
+		}
+		if now.Before(from) || !now.Before(until) {
+			continue
+		}
+
+		switch consent.GranteeType {
+		case types.GranteeRole:
+			if role != "" && hasRole([]string{consent.Grantee}, mspID, role) {
+				return true, nil
+			}
+		case types.GranteeIdentity:
+			for _, identityID := range identityIDs {
+				if consent.Grantee == identityID {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// callerIdentityIDs returns the caller's ID as reported by the client identity library,
+// both encoded and in its decoded "x509::<subject>::<issuer>" form
+func callerIdentityIDs(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	id, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+
+	ids := []string{id}
+	if decoded, err := base64.StdEncoding.DecodeString(id); err == nil {
+		ids = append(ids, string(decoded))
+	}
+	return ids, nil
+}
+
+func hasPermission(permissions []string, operation string) bool {
+	for _, permission := range permissions {
+		if permission == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// txTimestamp returns the transaction timestamp, which is the same on every endorser
+func txTimestamp(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	timestamp, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+
+	return time.Unix(timestamp.GetSeconds(), int64(timestamp.GetNanos())).UTC(), nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,116 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestConsentChecksExpectedVersion(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com", Version: 1})
+	owner := testIdentity(t, "Org1MSP", "client", map[string]string{OwnerAttribute: "PATIENT 1"})
+	grant := func(expectedVersion string) []string {
+		return []string{"pc1", "role", "Org2MSP.Nurse", `["R"]`, "", "2099-01-01T00:00:00Z", "treatment", expectedVersion}
+	}
+
+	var conflict VersionConflictError
+	response := invoke(stub, owner, "GrantConsent", grant("0")...)
+	if response.Status == 200 || json.Unmarshal([]byte(response.Message), &conflict) != nil || conflict.Code != CodeVersionConflict || conflict.CurrentVersion != 1 {
+		t.Fatalf("expected a version conflict for a stale GrantConsent, got %d %s", response.Status, response.Message)
+	}
+	response = invoke(stub, owner, "GrantConsent", grant("1")...)
+	if response.Status != 200 {
+		t.Fatalf("GrantConsent failed: %s", response.Message)
+	}
+	var consent types.Consent
+	if err := json.Unmarshal(response.Payload, &consent); err != nil {
+		t.Fatal(err)
+	}
+
+	response = invoke(stub, owner, "RevokeConsent", "pc1", consent.ConsentID, "1")
+	if response.Status == 200 || json.Unmarshal([]byte(response.Message), &conflict) != nil || conflict.CurrentVersion != 2 {
+		t.Fatalf("expected a version conflict for a stale RevokeConsent, got %d %s", response.Status, response.Message)
+	}
+	if response := invoke(stub, owner, "RevokeConsent", "pc1", consent.ConsentID, "2"); response.Status != 200 {
+		t.Fatalf("RevokeConsent failed: %s", response.Message)
+	}
+}
+
+func TestConsentGrantsAccess(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantReadWrite, Metadata: "https://example.com", Version: 1})
+	owner := testIdentity(t, "Org1MSP", "client", map[string]string{OwnerAttribute: "PATIENT 1"})
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+
+	if accessErr := accessErrorOf(t, invoke(stub, nurse, "ReadAsset", "pc1")); accessErr.Code != CodeAccessDenied {
+		t.Fatalf("expected %s before the consent, got %+v", CodeAccessDenied, accessErr)
+	}
+
+	response := invoke(stub, owner, "GrantConsent", "pc1", "role", "Org2MSP.Nurse", `["R"]`, "", "2099-01-01T00:00:00Z", "treatment", "1")
+	if response.Status != 200 {
+		t.Fatalf("GrantConsent failed: %s", response.Message)
+	}
+	var consent types.Consent
+	if err := json.Unmarshal(response.Payload, &consent); err != nil {
+		t.Fatal(err)
+	}
+
+	if response := invoke(stub, nurse, "ReadAsset", "pc1"); response.Status != 200 {
+		t.Fatalf("the consented role could not read: %s", response.Message)
+	}
+	// the consent only gives what it lists, and only to the role minted by its MSP
+	properties, _ := json.Marshal(assetProperties{Owner: "PATIENT 1", Metadata: "https://example.org", Salt: "0123456789abcdef"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "patient-1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyOffChain})
+	stub.TransientMap = map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patient}
+	if accessErr := accessErrorOf(t, invoke(stub, nurse, "UpdateAsset", "pc1", `["Org1MSP.DoctorReg1"]`, GrantReadWrite, "2")); accessErr.Operation != GrantWrite {
+		t.Errorf("expected the write to be denied, got %+v", accessErr)
+	}
+	if response := invoke(stub, testIdentity(t, "Org3MSP", "client", map[string]string{RoleAttribute: "Nurse"}), "ReadAsset", "pc1"); response.Status == 200 {
+		t.Errorf("the consent let the same role of another MSP read")
+	}
+
+	if response := invoke(stub, owner, "RevokeConsent", "pc1", consent.ConsentID, "2"); response.Status != 200 {
+		t.Fatalf("RevokeConsent failed: %s", response.Message)
+	}
+	if accessErr := accessErrorOf(t, invoke(stub, nurse, "ReadAsset", "pc1")); accessErr.Code != CodeAccessDenied {
+		t.Errorf("expected %s after the revocation, got %+v", CodeAccessDenied, accessErr)
+	}
+}
+
+func TestConsentOutsideItsWindowIsRefused(t *testing.T) {
+	stub := newTestStub(t)
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	consent := func(id string, validFrom string, validUntil string, status string) types.Consent {
+		return types.Consent{
+			ConsentID:   id,
+			GranteeType: types.GranteeRole,
+			Grantee:     "Org2MSP.Nurse",
+			Permissions: []string{GrantRead},
+			ValidFrom:   validFrom,
+			ValidUntil:  validUntil,
+			Purpose:     "treatment",
+			Status:      status,
+			GrantedAt:   "2020-01-01T00:00:00Z",
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		consent types.Consent
+		allowed bool
+	}{
+		{"current", consent("c1", "2020-01-01T00:00:00Z", "2099-01-01T00:00:00Z", types.ConsentActive), true},
+		{"expired", consent("c2", "2020-01-01T00:00:00Z", "2021-01-01T00:00:00Z", types.ConsentActive), false},
+		{"not yet valid", consent("c3", "2098-01-01T00:00:00Z", "2099-01-01T00:00:00Z", types.ConsentActive), false},
+		{"revoked", consent("c4", "2020-01-01T00:00:00Z", "2099-01-01T00:00:00Z", types.ConsentRevoked), false},
+	} {
+		putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com", Consents: []types.Consent{test.consent}})
+		response := invoke(stub, nurse, "ReadAsset", "pc1")
+		if (response.Status == 200) != test.allowed {
+			t.Errorf("%s consent: expected allowed %v, got %d %s", test.name, test.allowed, response.Status, response.Message)
+		}
+	}
+}
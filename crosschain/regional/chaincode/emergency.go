@@ -0,0 +1,113 @@
+package chaincode
+
+import (
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// EmergencyAttribute is the certificate attribute, set to "true", that marks an emergency clinician
+const EmergencyAttribute = "emergency"
+
+// EmergencyReadAsset returns an asset to a caller with EmergencyAttribute whatever its AuthRoles
+// and consents say. Only globalcc may call it: globalcc's EmergencyRead does, once it has found the
+// caller's committed, unexpired emergency access grant for the asset, which is the audit record.
+// The grant lives in globalcc's state, which this chaincode cannot query while globalcc is calling
+// it, so the check here is that the transaction was proposed to globalcc.
+func (s *SmartContract) EmergencyReadAsset(ctx contractapi.TransactionContextInterface, id string) (*types.RegionalAsset, error) {
+	err := requireEmergencyCaller(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = decryptMetadata(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// requireEmergencyCaller fails unless the caller carries EmergencyAttribute and the transaction
+// was proposed to globalcc
+func requireEmergencyCaller(ctx contractapi.TransactionContextInterface, id string) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: id, Operation: GrantRead, Reason: "client identity is not available"}
+	}
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: id, Operation: GrantRead, Reason: err.Error()}
+	}
+	value, found, err := identity.GetAttributeValue(EmergencyAttribute)
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: id, Operation: GrantRead, MSPID: mspID, Reason: err.Error()}
+	}
+	if !found || value != "true" {
+		return &AccessError{
+			Code:      CodeMissingAttribute,
+			AssetID:   id,
+			Operation: GrantRead,
+			MSPID:     mspID,
+			Reason:    fmt.Sprintf("client certificate does not carry %s=true", EmergencyAttribute),
+		}
+	}
+
+	chaincode, err := proposedChaincode(ctx)
+	if err != nil {
+		return &AccessError{Code: CodeInvalidIdentity, AssetID: id, Operation: GrantRead, MSPID: mspID, Reason: err.Error()}
+	}
+	config, err := getRegionConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if chaincode != config.GlobalChaincode {
+		return &AccessError{
+			Code:      CodeAccessDenied,
+			AssetID:   id,
+			Operation: GrantRead,
+			MSPID:     mspID,
+			Reason:    fmt.Sprintf("emergency reads are only served to %s EmergencyRead", config.GlobalChaincode),
+		}
+	}
+	return nil
+}
+
+// proposedChaincode returns the chaincode the transaction was proposed to, as named in the
+// proposal header the peer dispatches on. For a chaincode-to-chaincode call it is the calling
+// chaincode rather than this one.
+func proposedChaincode(ctx contractapi.TransactionContextInterface) (string, error) {
+	signedProposal, err := ctx.GetStub().GetSignedProposal()
+	if err != nil {
+		return "", fmt.Errorf("failed to get signed proposal: %v", err)
+	}
+	if signedProposal == nil {
+		return "", nil
+	}
+
+	var proposal peer.Proposal
+	if err := proto.Unmarshal(signedProposal.GetProposalBytes(), &proposal); err != nil {
+		return "", fmt.Errorf("failed to parse proposal: %v", err)
+	}
+	var header common.Header
+	if err := proto.Unmarshal(proposal.GetHeader(), &header); err != nil {
+		return "", fmt.Errorf("failed to parse proposal header: %v", err)
+	}
+	var channelHeader common.ChannelHeader
+	if err := proto.Unmarshal(header.GetChannelHeader(), &channelHeader); err != nil {
+		return "", fmt.Errorf("failed to parse channel header: %v", err)
+	}
+	var extension peer.ChaincodeHeaderExtension
+	if err := proto.Unmarshal(channelHeader.GetExtension(), &extension); err != nil {
+		return "", fmt.Errorf("failed to parse chaincode header extension: %v", err)
+	}
+	return extension.GetChaincodeId().GetName(), nil
+}
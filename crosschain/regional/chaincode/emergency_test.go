@@ -0,0 +1,78 @@
+package chaincode
+
+import (
+	"testing"
+
+	"crosschain/types"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// proposalOf is the signed proposal a client sends to invoke function of chaincode with args
+func proposalOf(t *testing.T, chaincode string, function string, args ...string) *peer.SignedProposal {
+	t.Helper()
+
+	input := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+	invocation, err := proto.Marshal(&peer.ChaincodeInvocationSpec{ChaincodeSpec: &peer.ChaincodeSpec{
+		ChaincodeId: &peer.ChaincodeID{Name: chaincode},
+		Input:       &peer.ChaincodeInput{Args: input},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := proto.Marshal(&peer.ChaincodeProposalPayload{Input: invocation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extension, err := proto.Marshal(&peer.ChaincodeHeaderExtension{ChaincodeId: &peer.ChaincodeID{Name: chaincode}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelHeader, err := proto.Marshal(&common.ChannelHeader{Type: int32(common.HeaderType_ENDORSER_TRANSACTION), Extension: extension})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := proto.Marshal(&common.Header{ChannelHeader: channelHeader})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposal, err := proto.Marshal(&peer.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &peer.SignedProposal{ProposalBytes: proposal}
+}
+
+func TestEmergencyReadAssetOnlyServesGlobalCC(t *testing.T) {
+	stub := newTestStub(t)
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com"})
+	paramedic := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Paramedic", EmergencyAttribute: "true"})
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	emergencyRead := proposalOf(t, DefaultGlobalChaincode, "EmergencyRead", "HP1", "pc1", "tx1")
+
+	for _, test := range []struct {
+		name     string
+		creator  []byte
+		function string
+		proposal *peer.SignedProposal
+		allowed  bool
+	}{
+		{"globalcc EmergencyRead", paramedic, "EmergencyReadAsset", emergencyRead, true},
+		{"called directly", paramedic, "EmergencyReadAsset", proposalOf(t, "regionalCC1", "EmergencyReadAsset", "pc1"), false},
+		{"another chaincode", paramedic, "EmergencyReadAsset", proposalOf(t, "otherCC", "EmergencyRead", "HP1", "pc1", "tx1"), false},
+		{"no emergency attribute", nurse, "EmergencyReadAsset", emergencyRead, false},
+		// an emergency certificate gives nothing through the ordinary read
+		{"ReadAsset from globalcc", paramedic, "ReadAsset", emergencyRead, false},
+	} {
+		stub.Creator = test.creator
+		response := stub.MockInvokeWithSignedProposal("tx-"+test.function, [][]byte{[]byte(test.function), []byte("pc1")}, test.proposal)
+		if (response.Status == 200) != test.allowed {
+			t.Errorf("%s: expected allowed %v, got %d %s", test.name, test.allowed, response.Status, response.Message)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transient map keys that carry metadata data keys as JSON metadataKey objects
+const (
+	TransientMetadataKey    = "metadata_key"     // the key to encrypt Metadata under on writes, or to decrypt it on reads
+	TransientNewMetadataKey = "metadata_new_key" // the key RotateMetadataKey re-encrypts under
+)
+
+// metadataKey is a data key passed in the transient map. Key is the base64 AES-128, -192 or -256 key;
+// KeyID is the name stored with every record encrypted under it.
+type metadataKey struct {
+	KeyID string `json:"keyID"`
+	Key   string `json:"key"`
+}
+
+// RotateMetadataKey re-encrypts the metadata of the given assets from the TransientMetadataKey
+// to the TransientNewMetadataKey. Every asset must currently be encrypted under the old key ID and
+// the caller needs write access to each; list records with GetAllAssetsWithPagination to find them.
+func (s *SmartContract) RotateMetadataKey(ctx contractapi.TransactionContextInterface, ids []string) (int, error) {
+	if len(ids) > types.MaxBatchSize {
+		return 0, fmt.Errorf("a rotation may cover at most %d assets, got %d", types.MaxBatchSize, len(ids))
+	}
+	oldKey, err := transientMetadataKey(ctx, TransientMetadataKey)
+	if err != nil {
+		return 0, err
+	}
+	newKey, err := transientMetadataKey(ctx, TransientNewMetadataKey)
+	if err != nil {
+		return 0, err
+	}
+	if oldKey == nil || newKey == nil {
+		return 0, fmt.Errorf("both %q and %q are required in the transient map", TransientMetadataKey, TransientNewMetadataKey)
+	}
+	if oldKey.KeyID == newKey.KeyID {
+		return 0, fmt.Errorf("the new key must have a different key ID than %s", oldKey.KeyID)
+	}
+
+	for _, id := range ids {
+		asset, err := getAsset(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		err = checkAccess(ctx, asset, GrantWrite)
+		if err != nil {
+			return 0, err
+		}
+		if asset.MetadataKeyID != oldKey.KeyID {
+			return 0, fmt.Errorf("the metadata of asset %s is not encrypted under key %s", id, oldKey.KeyID)
+		}
+
+		plaintext, err := openMetadata(oldKey, asset.ID, asset.Metadata)
+		if err != nil {
+			return 0, err
+		}
+		asset.Metadata, err = sealMetadata(ctx, newKey, asset.ID, plaintext)
+		if err != nil {
+			return 0, err
+		}
+		asset.MetadataKeyID = newKey.KeyID
+
+		err = storeAsset(ctx, asset)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// encryptMetadata encrypts metadata under the TransientMetadataKey when the writer passed one.
+// It returns the value to store and the key ID, which is empty for plaintext.
+func encryptMetadata(ctx contractapi.TransactionContextInterface, id string, metadata string) (string, string, error) {
+	key, err := transientMetadataKey(ctx, TransientMetadataKey)
+	if err != nil || key == nil {
+		return metadata, "", err
+	}
+
+	ciphertext, err := sealMetadata(ctx, key, id, metadata)
+	if err != nil {
+		return "", "", err
+	}
+	return ciphertext, key.KeyID, nil
+}
+
+// decryptMetadata replaces encrypted metadata with its plaintext when the reader passed the
+// TransientMetadataKey. Without a key the asset keeps its ciphertext.
+func decryptMetadata(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	if asset.MetadataKeyID == "" {
+		return nil
+	}
+	key, err := transientMetadataKey(ctx, TransientMetadataKey)
+	if err != nil || key == nil {
+		return err
+	}
+	if key.KeyID != asset.MetadataKeyID {
+		return fmt.Errorf("the metadata of asset %s is encrypted under key %s, not %s", asset.ID, asset.MetadataKeyID, key.KeyID)
+	}
+
+	asset.Metadata, err = openMetadata(key, asset.ID, asset.Metadata)
+	return err
+}
+
+// transientMetadataKey returns the data key passed under name, or nil when there is none
+func transientMetadataKey(ctx contractapi.TransactionContextInterface, name string) (*metadataKey, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	keyJSON, ok := transient[name]
+	if !ok {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(keyJSON))
+	decoder.DisallowUnknownFields()
+	var key metadataKey
+	err = decoder.Decode(&key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+	}
+	if key.KeyID == "" {
+		return nil, fmt.Errorf("%s has no keyID", name)
+	}
+	return &key, nil
+}
+
+// sealMetadata encrypts plaintext with AES-GCM and returns base64(nonce || ciphertext). The nonce
+// is derived from the key, transaction ID and asset ID rather than drawn at random, so every
+// endorser produces the same ciphertext; the asset ID and key ID are authenticated as well.
+func sealMetadata(ctx contractapi.TransactionContextInterface, key *metadataKey, id string, plaintext string) (string, error) {
+	return sealField(ctx, key, id, "", plaintext)
+}
+
+// openMetadata reverses sealMetadata
+func openMetadata(key *metadataKey, id string, envelope string) (string, error) {
+	return openField(key, id, "", envelope)
+}
+
+// sealField is sealMetadata for any field of an asset. The field name feeds the nonce and the
+// authenticated data, so several fields of one asset can be sealed under one key in one transaction.
+func sealField(ctx contractapi.TransactionContextInterface, key *metadataKey, id string, field string, plaintext string) (string, error) {
+	aead, rawKey, err := key.aead()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, rawKey)
+	mac.Write([]byte(ctx.GetStub().GetTxID() + "\x00" + id))
+	if field != "" {
+		mac.Write([]byte("\x00" + field))
+	}
+	nonce := mac.Sum(nil)[:aead.NonceSize()]
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData(id, key.KeyID, field))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openField reverses sealField
+func openField(key *metadataKey, id string, field string, envelope string) (string, error) {
+	name := field
+	if name == "" {
+		name = "metadata"
+	}
+	aead, _, err := key.aead()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(envelope)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("the %s of asset %s is not a valid envelope", name, id)
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(id, key.KeyID, field))
+	if err != nil {
+		return "", fmt.Errorf("key %s does not decrypt the %s of asset %s", key.KeyID, name, id)
+	}
+	return string(plaintext), nil
+}
+
+func (k *metadataKey) aead() (cipher.AEAD, []byte, error) {
+	rawKey, err := base64.StdEncoding.DecodeString(k.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key %s is not base64: %v", k.KeyID, err)
+	}
+	block, err := aes.NewCipher(rawKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("key %s: %v", k.KeyID, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, rawKey, nil
+}
+
+func additionalData(id string, keyID string, field string) []byte {
+	if field == "" {
+		return []byte(id + "\x00" + keyID)
+	}
+	return []byte(id + "\x00" + keyID + "\x00" + field)
+}
@@ -0,0 +1,143 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+)
+
+// metadataKeyOf is the transient metadataKey JSON of keyID, with fill repeated as its AES-256 key
+func metadataKeyOf(t *testing.T, keyID string, fill byte) []byte {
+	t.Helper()
+
+	raw := make([]byte, 32)
+	for i := range raw {
+		raw[i] = fill
+	}
+	keyJSON, err := json.Marshal(metadataKey{KeyID: keyID, Key: base64.StdEncoding.EncodeToString(raw)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyJSON
+}
+
+// readMetadata reads id as creator with transient and returns its metadata and key ID
+func readMetadata(t *testing.T, stub *shimtest.MockStub, creator []byte, id string, transient map[string][]byte) (string, string, error) {
+	t.Helper()
+
+	stub.TransientMap = transient
+	response := invoke(stub, creator, "ReadAsset", id)
+	if response.Status != 200 {
+		return "", "", errors.New(response.Message)
+	}
+	var asset types.RegionalAsset
+	if err := json.Unmarshal(response.Payload, &asset); err != nil {
+		t.Fatal(err)
+	}
+	return asset.Metadata, asset.MetadataKeyID, nil
+}
+
+func TestMetadataEncryption(t *testing.T) {
+	stub := newTestStub(t)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "seed-region1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyCollection})
+	k1 := metadataKeyOf(t, "k1", 1)
+
+	stub.TransientMap = map[string][]byte{TransientPatientKey: patient, TransientSeedSaltKey: []byte("0123456789abcdef"), TransientMetadataKey: k1}
+	if response := invoke(stub, doctor, "InitLedger", "1"); response.Status != 200 {
+		t.Fatalf("InitLedger failed: %s", response.Message)
+	}
+	id := types.NewPolicyID("region1", SeedHospitalID, 1)
+
+	metadata, keyID, err := readMetadata(t, stub, doctor, id, map[string][]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "k1" || metadata == "" || strings.Contains(metadata, "example.com") {
+		t.Fatalf("expected ciphertext under k1 without a key, got %q under %q", metadata, keyID)
+	}
+	ciphertext := metadata
+
+	metadata, _, err = readMetadata(t, stub, doctor, id, map[string][]byte{TransientMetadataKey: k1})
+	if err != nil || metadata != "https://example.com" {
+		t.Fatalf("expected the plaintext with k1, got %q, %v", metadata, err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		key     []byte
+		message string
+	}{
+		{"another key ID", metadataKeyOf(t, "k2", 1), "encrypted under key k1"},
+		{"the wrong key", metadataKeyOf(t, "k1", 2), "does not decrypt"},
+	} {
+		if _, _, err := readMetadata(t, stub, doctor, id, map[string][]byte{TransientMetadataKey: test.key}); err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.message, err)
+		}
+	}
+
+	// the ciphertext is bound to its asset: moved to another one, k1 no longer opens it
+	putTestAsset(t, stub, types.RegionalAsset{ID: "pc2", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: ciphertext, MetadataKeyID: "k1"})
+	if _, _, err := readMetadata(t, stub, doctor, "pc2", map[string][]byte{TransientMetadataKey: k1}); err == nil || !strings.Contains(err.Error(), "does not decrypt") {
+		t.Errorf("expected a ciphertext copied to another asset not to decrypt, got %v", err)
+	}
+}
+
+func TestRotateMetadataKey(t *testing.T) {
+	stub := newTestStub(t)
+	t.Setenv(RegionConfigEnv, `{"regionID":"region1","defaultRoles":["Org1MSP.DoctorReg1"],"seed":{"owner":"PATIENT 1","metadata":"https://example.com","grant":"RW"}}`)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "seed-region1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyCollection})
+	k1, k2 := metadataKeyOf(t, "k1", 1), metadataKeyOf(t, "k2", 2)
+
+	stub.TransientMap = map[string][]byte{TransientPatientKey: patient, TransientSeedSaltKey: []byte("0123456789abcdef"), TransientMetadataKey: k1}
+	if response := invoke(stub, doctor, "InitLedger", "2"); response.Status != 200 {
+		t.Fatalf("InitLedger failed: %s", response.Message)
+	}
+	id1, id2 := types.NewPolicyID("region1", SeedHospitalID, 1), types.NewPolicyID("region1", SeedHospitalID, 2)
+	ids, _ := json.Marshal([]string{id1})
+
+	for _, test := range []struct {
+		name      string
+		creator   []byte
+		transient map[string][]byte
+		message   string
+	}{
+		{"no new key", doctor, map[string][]byte{TransientMetadataKey: k1}, "are required"},
+		{"same key ID", doctor, map[string][]byte{TransientMetadataKey: k1, TransientNewMetadataKey: metadataKeyOf(t, "k1", 2)}, "different key ID"},
+		{"not the current key", doctor, map[string][]byte{TransientMetadataKey: k2, TransientNewMetadataKey: metadataKeyOf(t, "k3", 3)}, "not encrypted under key k2"},
+		{"the wrong old key", doctor, map[string][]byte{TransientMetadataKey: metadataKeyOf(t, "k1", 9), TransientNewMetadataKey: k2}, "does not decrypt"},
+		{"no write access", nurse, map[string][]byte{TransientMetadataKey: k1, TransientNewMetadataKey: k2}, CodeAccessDenied},
+	} {
+		stub.TransientMap = test.transient
+		response := invoke(stub, test.creator, "RotateMetadataKey", string(ids))
+		if response.Status == 200 || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: expected the rotation to be refused with %q, got %d %s", test.name, test.message, response.Status, response.Message)
+		}
+	}
+
+	stub.TransientMap = map[string][]byte{TransientMetadataKey: k1, TransientNewMetadataKey: k2}
+	response := invoke(stub, doctor, "RotateMetadataKey", string(ids))
+	if response.Status != 200 || string(response.Payload) != "1" {
+		t.Fatalf("RotateMetadataKey failed: %d %s", response.Status, response.Message)
+	}
+
+	metadata, keyID, err := readMetadata(t, stub, doctor, id1, map[string][]byte{TransientMetadataKey: k2})
+	if err != nil || keyID != "k2" || metadata != "https://example.com" {
+		t.Fatalf("expected the rotated asset to open under k2, got %q under %q, %v", metadata, keyID, err)
+	}
+	if _, _, err := readMetadata(t, stub, doctor, id1, map[string][]byte{TransientMetadataKey: k1}); err == nil {
+		t.Errorf("the old key still opens the rotated asset")
+	}
+	// assets left out of the rotation stay under the old key
+	if metadata, _, err := readMetadata(t, stub, doctor, id2, map[string][]byte{TransientMetadataKey: k1}); err != nil || metadata != "https://example.com" {
+		t.Errorf("expected the unrotated asset to open under k1, got %q, %v", metadata, err)
+	}
+}
@@ -0,0 +1,524 @@
+package chaincode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransientPatientKey is the transient map key under which writers pass the JSON patientKey of the
+// patient whose Owner and Metadata they store. Every asset of a patient is sealed under that key.
+const TransientPatientKey = "patient_key"
+
+// Where a patient key is held, stored in patientKey.Custody
+const (
+	CustodyCollection = "collection" // in the region's private data collection, destroyed by ErasePatient
+	CustodyOffChain   = "offchain"   // only by the client, which passes it with every read and write; such assets cannot be transferred
+)
+
+// ErasureAttribute is the certificate attribute, set to "true", of officers who execute erasure requests
+const ErasureAttribute = "erasure"
+
+// CodeErased is returned in AccessError.Code for assets whose patient was erased
+const CodeErased = "ERASED"
+
+// PatientErasedEvent is the chaincode event emitted by ErasePatient
+const PatientErasedEvent = "PatientErased"
+
+// Composite key object types used by erasure. Everything keyed by a patient stays in the
+// collection: a public key derived from the ownerRef, even hashed, would link the patient's assets.
+const (
+	patientKeyIndex  = "patientkey~ref"   // collection: ownerRef -> patient key
+	ownerRefIndex    = "ownerref~asset"   // collection: sha256(ownerRef), assetID
+	erasureIndex     = "erasure~ownerref" // collection: sha256(ownerRef) -> ErasureRecord
+	erasedAssetIndex = "erased~asset"     // public: assetID -> erasure timestamp
+)
+
+// patientKey is a per-patient data key passed under TransientPatientKey. OwnerRef is a pseudonymous
+// reference to the patient, never the Owner itself; Key is a base64 AES key.
+type patientKey struct {
+	OwnerRef string `json:"ownerRef"`
+	Key      string `json:"key,omitempty"`
+	Custody  string `json:"custody,omitempty"`
+}
+
+// ErasureRecord is the tombstone ErasePatient leaves in the collection. It names the patient only by
+// the hash of the ownerRef and is never deleted; public state only marks each asset as erased.
+type ErasureRecord struct {
+	OwnerRefHash string   `json:"ownerRefHash"`
+	Reason       string   `json:"reason"`
+	AssetIDs     []string `json:"assetIDs"`
+	MSPID        string   `json:"mspID"`
+	CallerID     string   `json:"callerID"`
+	TxID         string   `json:"txID"`
+	Timestamp    string   `json:"timestamp"`
+}
+
+// ErasureEvent is the payload of PatientErasedEvent. Events are written to the block, so it only
+// says how many assets were erased and when.
+type ErasureEvent struct {
+	Assets    int    `json:"assets"`
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ErasePatient crypto-shreds every asset of a patient: it destroys the patient key held in the
+// collection, purges the sealed private data and the ownerRef index entries of the patient's assets
+// and writes an ErasureRecord.
+// The public remains of the assets then read as CodeErased. Callers holding the key off-chain must
+// destroy their copy. Only an erasure officer or the patient may erase. Needs Fabric 2.5 or later.
+func (s *SmartContract) ErasePatient(ctx contractapi.TransactionContextInterface, ownerRef string, reason string) (*ErasureRecord, error) {
+	if ownerRef == "" {
+		return nil, fmt.Errorf("an ownerRef is required")
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, fmt.Errorf("a reason is required for erasure")
+	}
+	refHash := hashOwnerRef(ownerRef)
+	existing, err := getErasure(ctx, refHash)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("the patient was already erased at %s", existing.Timestamp)
+	}
+
+	ids, err := patientAssetIDs(ctx, refHash)
+	if err != nil {
+		return nil, err
+	}
+	err = requireErasureRight(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record, err := newErasureRecord(ctx, refHash, reason, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		err = ctx.GetStub().PurgePrivateData(collection, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge private data of asset %s: %v", id, err)
+		}
+		key, err := ctx.GetStub().CreateCompositeKey(ownerRefIndex, []string{refHash, id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().PurgePrivateData(collection, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge the ownerRef index of asset %s: %v", id, err)
+		}
+		key, err = ctx.GetStub().CreateCompositeKey(erasedAssetIndex, []string{id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().PutState(key, []byte(record.Timestamp))
+		if err != nil {
+			return nil, fmt.Errorf("failed to put to world state. %v", err)
+		}
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(patientKeyIndex, []string{ownerRef})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	storedKey, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	if storedKey != nil {
+		err = ctx.GetStub().PurgePrivateData(collection, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to purge the patient key: %v", err)
+		}
+	}
+
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	key, err = ctx.GetStub().CreateCompositeKey(erasureIndex, []string{refHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	err = ctx.GetStub().PutPrivateData(collection, key, recordJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put to private data collection %s: %v", collection, err)
+	}
+	eventJSON, err := json.Marshal(ErasureEvent{Assets: len(ids), TxID: record.TxID, Timestamp: record.Timestamp})
+	if err != nil {
+		return nil, err
+	}
+	err = ctx.GetStub().SetEvent(PatientErasedEvent, eventJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set event: %v", err)
+	}
+
+	fmt.Printf("[ERASURE] %d assets, TxID: %s\n", len(ids), record.TxID)
+	return record, nil
+}
+
+// GetErasure returns the tombstone of an erased patient
+func (s *SmartContract) GetErasure(ctx contractapi.TransactionContextInterface, ownerRef string) (*ErasureRecord, error) {
+	record, err := getErasure(ctx, hashOwnerRef(ownerRef))
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("the patient has not been erased")
+	}
+	return record, nil
+}
+
+// HasOwnerRef reports whether the asset id is indexed under refHash, the sha256 of an ownerRef, so
+// globalcc can check a patient's claim to a policy without the ownerRef leaving the client. The
+// index is in the collection, so the peer answering must be a member.
+func (s *SmartContract) HasOwnerRef(ctx contractapi.TransactionContextInterface, id string, refHash string) (bool, error) {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return false, err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(ownerRefIndex, []string{refHash, id})
+	if err != nil {
+		return false, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	indexed, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	return indexed != nil, nil
+}
+
+// transientPatientKey resolves the TransientPatientKey a writer passed. A collection-held key is
+// stored the first time it is seen; afterwards the writer may pass just the ownerRef.
+func transientPatientKey(ctx contractapi.TransactionContextInterface) (*metadataKey, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	keyJSON, ok := transient[TransientPatientKey]
+	if !ok {
+		return nil, fmt.Errorf("the patient key must be passed in the transient map under %q", TransientPatientKey)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(keyJSON))
+	decoder.DisallowUnknownFields()
+	var patient patientKey
+	err = decoder.Decode(&patient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", TransientPatientKey, err)
+	}
+	if patient.OwnerRef == "" {
+		return nil, fmt.Errorf("%s has no ownerRef", TransientPatientKey)
+	}
+
+	erasure, err := getErasure(ctx, hashOwnerRef(patient.OwnerRef))
+	if err != nil {
+		return nil, err
+	}
+	if erasure != nil {
+		return nil, fmt.Errorf("the patient was erased at %s; new records need a new ownerRef", erasure.Timestamp)
+	}
+
+	stored, err := storedPatientKey(ctx, patient.OwnerRef)
+	if err != nil {
+		return nil, err
+	}
+	switch patient.Custody {
+	case CustodyOffChain:
+		if stored != nil {
+			return nil, fmt.Errorf("the key of this patient is held in the collection")
+		}
+	case "", CustodyCollection:
+		if stored != nil {
+			if patient.Key != "" && patient.Key != stored.Key {
+				return nil, fmt.Errorf("the key passed does not match the key held for this patient")
+			}
+			return stored, nil
+		}
+	default:
+		return nil, fmt.Errorf("invalid custody %q, must be %s or %s", patient.Custody, CustodyCollection, CustodyOffChain)
+	}
+
+	key := &metadataKey{KeyID: patient.OwnerRef, Key: patient.Key}
+	if patient.Key == "" {
+		return nil, fmt.Errorf("%s has no key", TransientPatientKey)
+	}
+	_, _, err = key.aead()
+	if err != nil {
+		return nil, err
+	}
+	if patient.Custody == CustodyOffChain {
+		return key, nil
+	}
+	return key, putPatientKey(ctx, key)
+}
+
+// patientKeyFor returns the key that seals the assets of ownerRef: the one held in the collection,
+// or one the reader passed under TransientPatientKey
+func patientKeyFor(ctx contractapi.TransactionContextInterface, ownerRef string) (*metadataKey, error) {
+	stored, err := storedPatientKey(ctx, ownerRef)
+	if err != nil || stored != nil {
+		return stored, err
+	}
+
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	if keyJSON, ok := transient[TransientPatientKey]; ok {
+		var patient patientKey
+		err = json.Unmarshal(keyJSON, &patient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", TransientPatientKey, err)
+		}
+		if patient.OwnerRef == ownerRef && patient.Key != "" {
+			return &metadataKey{KeyID: ownerRef, Key: patient.Key}, nil
+		}
+	}
+	return nil, fmt.Errorf("the patient key is held off-chain; pass it under %q", TransientPatientKey)
+}
+
+func storedPatientKey(ctx contractapi.TransactionContextInterface, ownerRef string) (*metadataKey, error) {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(patientKeyIndex, []string{ownerRef})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	keyJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	if keyJSON == nil {
+		return nil, nil
+	}
+
+	var stored metadataKey
+	err = json.Unmarshal(keyJSON, &stored)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func putPatientKey(ctx contractapi.TransactionContextInterface, patient *metadataKey) error {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(patientKeyIndex, []string{patient.KeyID})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	keyJSON, err := json.Marshal(patient)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, key, keyJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to private data collection %s: %v", collection, err)
+	}
+	return nil
+}
+
+// indexOwnerRef points the asset's ownerRef index entry at ownerRef, dropping the entry of the
+// patient the asset belonged to before
+func indexOwnerRef(ctx contractapi.TransactionContextInterface, collection string, id string, ownerRef string) error {
+	previousJSON, err := ctx.GetStub().GetPrivateData(collection, id)
+	if err != nil {
+		return fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	previous := ""
+	if previousJSON != nil {
+		var private struct {
+			OwnerRef string `json:"ownerRef"`
+		}
+		err = json.Unmarshal(previousJSON, &private)
+		if err != nil {
+			return err
+		}
+		previous = private.OwnerRef
+	}
+	if previous == ownerRef {
+		return nil
+	}
+
+	if previous != "" {
+		key, err := ctx.GetStub().CreateCompositeKey(ownerRefIndex, []string{hashOwnerRef(previous), id})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().DelPrivateData(collection, key)
+		if err != nil {
+			return fmt.Errorf("failed to delete from private data collection %s: %v", collection, err)
+		}
+	}
+	if ownerRef != "" {
+		key, err := ctx.GetStub().CreateCompositeKey(ownerRefIndex, []string{hashOwnerRef(ownerRef), id})
+		if err != nil {
+			return fmt.Errorf("failed to create composite key: %v", err)
+		}
+		err = ctx.GetStub().PutPrivateData(collection, key, []byte{0x00})
+		if err != nil {
+			return fmt.Errorf("failed to put to private data collection %s: %v", collection, err)
+		}
+	}
+	return nil
+}
+
+// checkNotErased returns an AccessError with CodeErased when the asset's patient was erased
+func checkNotErased(ctx contractapi.TransactionContextInterface, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(erasedAssetIndex, []string{id})
+	if err != nil {
+		return fmt.Errorf("failed to create composite key: %v", err)
+	}
+	erasedAt, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if erasedAt == nil {
+		return nil
+	}
+
+	reason := fmt.Sprintf("the patient's records were erased at %s", erasedAt)
+	return &AccessError{Code: CodeErased, AssetID: id, Operation: GrantRead, Reason: reason}
+}
+
+// isErased reports whether err is the error checkNotErased returns
+func isErased(err error) bool {
+	var accessErr *AccessError
+	return errors.As(err, &accessErr) && accessErr.Code == CodeErased
+}
+
+// requireErasureRight fails unless the caller is an erasure officer or the patient owning the assets
+func requireErasureRight(ctx contractapi.TransactionContextInterface, ids []string) error {
+	identity := ctx.GetClientIdentity()
+	if identity == nil {
+		return fmt.Errorf("client identity is not available")
+	}
+	officer, found, err := identity.GetAttributeValue(ErasureAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to get client attribute %s: %v", ErasureAttribute, err)
+	}
+	if found && officer == "true" {
+		return nil
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("only an erasure officer may erase a patient without assets")
+	}
+
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	owner, _, err := identity.GetAttributeValue(OwnerAttribute)
+	if err != nil {
+		return fmt.Errorf("failed to get client attribute %s: %v", OwnerAttribute, err)
+	}
+	for _, id := range ids {
+		asset, err := getAsset(ctx, id)
+		if err != nil {
+			return err
+		}
+		if owner == "" || owner != asset.Owner {
+			return &AccessError{Code: CodeNotOwner, AssetID: id, Operation: GrantWrite, MSPID: mspID, Reason: "only the patient or an erasure officer may erase"}
+		}
+	}
+	return nil
+}
+
+// patientAssetIDs lists the assets indexed under the hash of an ownerRef, in key order
+func patientAssetIDs(ctx contractapi.TransactionContextInterface, refHash string) ([]string, error) {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByPartialCompositeKey(collection, ownerRefIndex, []string{refHash})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, attributes[1])
+	}
+	return ids, nil
+}
+
+func getErasure(ctx contractapi.TransactionContextInterface, refHash string) (*ErasureRecord, error) {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.GetStub().CreateCompositeKey(erasureIndex, []string{refHash})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create composite key: %v", err)
+	}
+	recordJSON, err := ctx.GetStub().GetPrivateData(collection, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	if recordJSON == nil {
+		return nil, nil
+	}
+
+	var record ErasureRecord
+	err = json.Unmarshal(recordJSON, &record)
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func newErasureRecord(ctx contractapi.TransactionContextInterface, refHash string, reason string, ids []string) (*ErasureRecord, error) {
+	identity := ctx.GetClientIdentity()
+	mspID, err := identity.GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client MSP ID: %v", err)
+	}
+	callerID, err := identity.GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client ID: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ErasureRecord{
+		OwnerRefHash: refHash,
+		Reason:       reason,
+		AssetIDs:     ids,
+		MSPID:        mspID,
+		CallerID:     callerID,
+		TxID:         ctx.GetStub().GetTxID(),
+		Timestamp:    formatTime(now),
+	}, nil
+}
+
+func hashOwnerRef(ownerRef string) string {
+	sum := sha256.Sum256([]byte(ownerRef))
+	return hex.EncodeToString(sum[:])
+}
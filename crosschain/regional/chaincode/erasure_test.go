@@ -0,0 +1,105 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestErasePatient(t *testing.T) {
+	stub := newPrivateTestStub(t)
+	deployFakeGlobal(stub.MockStub)
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	a, b, c := types.NewPolicyID("region1", "HP1", 1), types.NewPolicyID("region1", "HP1", 2), types.NewPolicyID("region1", "HP1", 3)
+	createTestAsset(t, stub, doctor, a, "patient-1", "PATIENT 1")
+	createTestAsset(t, stub, doctor, b, "patient-1", "PATIENT 1")
+	createTestAsset(t, stub, doctor, c, "patient-2", "PATIENT 2")
+
+	// nothing in public state is keyed by the patient
+	refHash := hashOwnerRef("patient-1")
+	for key := range stub.State {
+		if strings.Contains(key, refHash) {
+			t.Fatalf("public state key %q is derived from the ownerRef", key)
+		}
+	}
+	hasOwnerRef := func(id string) bool {
+		t.Helper()
+		response := stub.invoke(doctor, nil, "HasOwnerRef", id, refHash)
+		if response.Status != 200 {
+			t.Fatalf("HasOwnerRef failed: %s", response.Message)
+		}
+		return string(response.Payload) == "true"
+	}
+	if !hasOwnerRef(a) || hasOwnerRef(c) {
+		t.Fatalf("HasOwnerRef does not follow the ownerRef index")
+	}
+
+	for _, test := range []struct {
+		name    string
+		creator []byte
+		args    []string
+	}{
+		{"a clinician", doctor, []string{"patient-1", "request"}},
+		{"another patient", testIdentity(t, "Org1MSP", "client", map[string]string{OwnerAttribute: "PATIENT 2"}), []string{"patient-1", "request"}},
+		{"no reason", testIdentity(t, "Org1MSP", "client", map[string]string{ErasureAttribute: "true"}), []string{"patient-1", " "}},
+	} {
+		if response := stub.invoke(test.creator, nil, "ErasePatient", test.args...); response.Status == 200 {
+			t.Errorf("%s: ErasePatient succeeded", test.name)
+		}
+	}
+
+	patient := testIdentity(t, "Org1MSP", "client", map[string]string{OwnerAttribute: "PATIENT 1"})
+	response := stub.invoke(patient, nil, "ErasePatient", "patient-1", "PDPA erasure request")
+	if response.Status != 200 {
+		t.Fatalf("ErasePatient failed: %s", response.Message)
+	}
+	var record ErasureRecord
+	if err := json.Unmarshal(response.Payload, &record); err != nil {
+		t.Fatal(err)
+	}
+	if len(record.AssetIDs) != 2 || record.AssetIDs[0] != a || record.AssetIDs[1] != b || record.OwnerRefHash != refHash {
+		t.Fatalf("unexpected erasure record %+v", record)
+	}
+	if event := <-stub.ChaincodeEventsChannel; event.EventName != PatientErasedEvent || strings.Contains(string(event.Payload), refHash) || strings.Contains(string(event.Payload), a) {
+		t.Fatalf("unexpected %s event %s", event.EventName, event.Payload)
+	}
+
+	// the sealed fields, the patient key and the index entries are gone
+	collection := stub.PvtState["region1PrivateCollection"]
+	for _, id := range []string{a, b} {
+		if collection[id] != nil {
+			t.Errorf("the private data of %s survived the erasure", id)
+		}
+		if accessErr := accessErrorOf(t, stub.invoke(doctor, nil, "ReadAsset", id)); accessErr.Code != CodeErased {
+			t.Errorf("expected %s reading %s, got %+v", CodeErased, id, accessErr)
+		}
+	}
+	for key := range collection {
+		if strings.Contains(key, "patient-1") || (strings.Contains(key, refHash) && !strings.Contains(key, erasureIndex)) {
+			t.Errorf("collection key %q of the erased patient survived", key)
+		}
+	}
+	if hasOwnerRef(a) {
+		t.Errorf("HasOwnerRef still finds the erased patient")
+	}
+	if response := stub.invoke(doctor, nil, "ReadAsset", c); response.Status != 200 {
+		t.Errorf("the other patient's asset became unreadable: %s", response.Message)
+	}
+
+	response = stub.invoke(doctor, nil, "GetErasure", "patient-1")
+	if response.Status != 200 || !strings.Contains(string(response.Payload), "PDPA erasure request") {
+		t.Errorf("expected the tombstone with its reason, got %d %s %s", response.Status, response.Message, response.Payload)
+	}
+	if response := stub.invoke(patient, nil, "ErasePatient", "patient-1", "again"); response.Status == 200 || !strings.Contains(response.Message, "already erased") {
+		t.Errorf("expected a second erasure to be refused, got %d %s", response.Status, response.Message)
+	}
+	// the erased ownerRef cannot be reused for new records
+	properties, _ := json.Marshal(assetProperties{Owner: "PATIENT 1", Metadata: "https://example.com", Salt: "0123456789abcdef"})
+	patientJSON, _ := json.Marshal(patientKey{OwnerRef: "patient-1", Key: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", Custody: CustodyCollection})
+	response = stub.invoke(doctor, map[string][]byte{TransientAssetKey: properties, TransientPatientKey: patientJSON}, "CreateAsset", types.NewPolicyID("region1", "HP1", 4), `["Org1MSP.DoctorReg1"]`, GrantRead)
+	if response.Status == 200 || !strings.Contains(response.Message, "erased") {
+		t.Errorf("expected a new record of the erased patient to be refused, got %d %s", response.Status, response.Message)
+	}
+}
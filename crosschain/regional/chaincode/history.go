@@ -0,0 +1,132 @@
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// GetAssetHistory returns every committed version of an asset in the order the peer reports them
+// (newest first since Fabric 2.0), including deletes.
+// Versions of a private asset carry only the PrivateHash: the collection keeps the current Owner and Metadata.
+// The caller needs read access under the asset's latest version.
+func (s *SmartContract) GetAssetHistory(ctx contractapi.TransactionContextInterface, id string) ([]*types.RegionalAssetVersion, error) {
+	history, err := getAssetHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkHistoryAccess(ctx, id, history)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// ReadAssetAsOf returns the version of an asset that was current at timestamp (RFC 3339).
+// The caller needs read access under the asset's latest version.
+func (s *SmartContract) ReadAssetAsOf(ctx contractapi.TransactionContextInterface, id string, timestamp string) (*types.RegionalAssetVersion, error) {
+	asOf, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp %q, expected RFC 3339: %v", timestamp, err)
+	}
+
+	history, err := getAssetHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = checkHistoryAccess(ctx, id, history)
+	if err != nil {
+		return nil, err
+	}
+
+	// the newest version written at or before asOf
+	var current *types.RegionalAssetVersion
+	var currentTime time.Time
+	for _, version := range history {
+		written, err := time.Parse(time.RFC3339Nano, version.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if written.After(asOf) {
+			continue
+		}
+		if current == nil || written.After(currentTime) {
+			current = version
+			currentTime = written
+		}
+	}
+
+	if current == nil || current.IsDelete {
+		return nil, fmt.Errorf("the asset %s did not exist at %s", id, timestamp)
+	}
+	return current, nil
+}
+
+// getAssetHistory reads the history of an asset from the history database without any access check.
+// The history of an erased patient's asset is unreadable.
+func getAssetHistory(ctx contractapi.TransactionContextInterface, id string) ([]*types.RegionalAssetVersion, error) {
+	err := checkNotErased(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	history := []*types.RegionalAssetVersion{}
+	for resultsIterator.HasNext() {
+		modification, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		version := &types.RegionalAssetVersion{
+			TxID:      modification.GetTxId(),
+			Timestamp: time.Unix(modification.GetTimestamp().GetSeconds(), int64(modification.GetTimestamp().GetNanos())).UTC().Format(time.RFC3339Nano),
+			IsDelete:  modification.GetIsDelete(),
+		}
+		if !version.IsDelete {
+			version.Asset, err = types.DecodeRegionalAsset(modification.GetValue())
+			if err != nil {
+				return nil, err
+			}
+		}
+		history = append(history, version)
+	}
+
+	if len(history) == 0 {
+		return nil, fmt.Errorf("the asset %s does not exist", id)
+	}
+	return history, nil
+}
+
+// checkHistoryAccess checks read access against the newest version that was not a delete
+func checkHistoryAccess(ctx contractapi.TransactionContextInterface, id string, history []*types.RegionalAssetVersion) error {
+	var latest *types.RegionalAssetVersion
+	var latestTime time.Time
+	for _, version := range history {
+		if version.IsDelete {
+			continue
+		}
+		written, err := time.Parse(time.RFC3339Nano, version.Timestamp)
+		if err != nil {
+			return err
+		}
+		if latest == nil || written.After(latestTime) {
+			latest = version
+			latestTime = written
+		}
+	}
+	if latest == nil {
+		return fmt.Errorf("the asset %s has no readable version", id)
+	}
+
+	return checkAccess(ctx, latest.Asset, GrantRead)
+}
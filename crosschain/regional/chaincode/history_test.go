@@ -0,0 +1,159 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// historyStub is a MockStub whose history database holds modifications, which MockStub lacks
+type historyStub struct {
+	*shimtest.MockStub
+	modifications map[string][]*queryresult.KeyModification
+}
+
+func (stub *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: stub.modifications[key]}, nil
+}
+
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool { return len(it.modifications) > 0 }
+func (it *historyIterator) Close() error  { return nil }
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+// historyContextOf is a transaction context on stub for creator
+func historyContextOf(t *testing.T, stub *historyStub, creator []byte) contractapi.TransactionContextInterface {
+	t.Helper()
+
+	stub.Creator = creator
+	identity, err := cid.New(stub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(identity)
+	return ctx
+}
+
+// modificationOf is a history entry for asset written by txID at written; a nil asset is a delete
+func modificationOf(t *testing.T, txID string, written time.Time, asset *types.RegionalAsset) *queryresult.KeyModification {
+	t.Helper()
+
+	modification := &queryresult.KeyModification{TxId: txID, Timestamp: timestamppb.New(written), IsDelete: asset == nil}
+	if asset != nil {
+		asset.SchemaVersion = types.RegionalAssetSchemaVersion
+		assetJSON, err := json.Marshal(asset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modification.Value = assetJSON
+	}
+	return modification
+}
+
+func TestReadAssetAsOf(t *testing.T) {
+	created := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	deleted := updated.Add(time.Hour)
+	recreated := deleted.Add(time.Hour)
+	version := func(grant string, authRoles ...string) *types.RegionalAsset {
+		return &types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: authRoles, Grant: grant, Metadata: "https://example.com"}
+	}
+
+	stub := &historyStub{MockStub: newTestStub(t), modifications: map[string][]*queryresult.KeyModification{
+		// newest first, as the peer reports them
+		"pc1": {
+			modificationOf(t, "tx4", recreated, version(GrantRead, "Org1MSP.DoctorReg1")),
+			modificationOf(t, "tx3", deleted, nil),
+			modificationOf(t, "tx2", updated, version(GrantWrite, "Org1MSP.DoctorReg1", "Org2MSP.Nurse")),
+			modificationOf(t, "tx1", created, version(GrantRead, "Org1MSP.DoctorReg1")),
+		},
+	}}
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	contract := &SmartContract{}
+
+	for _, test := range []struct {
+		asOf time.Time
+		txID string
+		err  string
+	}{
+		{asOf: created.Add(-time.Second), err: "did not exist"},
+		{asOf: created, txID: "tx1"},
+		{asOf: updated.Add(-time.Nanosecond), txID: "tx1"},
+		{asOf: updated.Add(time.Minute), txID: "tx2"},
+		{asOf: deleted.Add(time.Minute), err: "did not exist"},
+		{asOf: recreated.Add(24 * time.Hour), txID: "tx4"},
+	} {
+		timestamp := test.asOf.Format(time.RFC3339Nano)
+		version, err := contract.ReadAssetAsOf(historyContextOf(t, stub, doctor), "pc1", timestamp)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("as of %s: expected an error containing %q, got %v", timestamp, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("as of %s: %v", timestamp, err)
+			continue
+		}
+		if version.TxID != test.txID || version.Asset == nil || version.Asset.ID != "pc1" {
+			t.Errorf("as of %s: expected the version of %s, got %+v", timestamp, test.txID, version)
+		}
+	}
+
+	if _, err := contract.ReadAssetAsOf(historyContextOf(t, stub, doctor), "pc1", "yesterday"); err == nil || !strings.Contains(err.Error(), "invalid timestamp") {
+		t.Errorf("expected an invalid timestamp to be refused, got %v", err)
+	}
+}
+
+func TestGetAssetHistoryAccess(t *testing.T) {
+	written := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	stub := &historyStub{MockStub: newTestStub(t), modifications: map[string][]*queryresult.KeyModification{
+		// a nurse was dropped from the latest version, so only its roles decide access
+		"pc1": {
+			modificationOf(t, "tx2", written.Add(time.Hour), &types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1"}, Grant: GrantRead, Metadata: "https://example.com"}),
+			modificationOf(t, "tx1", written, &types.RegionalAsset{ID: "pc1", Owner: "PATIENT 1", AuthRoles: []string{"Org1MSP.DoctorReg1", "Org2MSP.Nurse"}, Grant: GrantRead, Metadata: "https://example.com"}),
+		},
+	}}
+	contract := &SmartContract{}
+
+	doctor := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	history, err := contract.GetAssetHistory(historyContextOf(t, stub, doctor), "pc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].TxID != "tx2" || history[1].TxID != "tx1" {
+		t.Fatalf("expected the history tx2, tx1, got %+v", history)
+	}
+
+	nurse := testIdentity(t, "Org2MSP", "client", map[string]string{RoleAttribute: "Nurse"})
+	if _, err := contract.GetAssetHistory(historyContextOf(t, stub, nurse), "pc1"); err == nil {
+		t.Errorf("a role dropped from the latest version read the history")
+	}
+	if _, err := contract.ReadAssetAsOf(historyContextOf(t, stub, nurse), "pc1", written.Format(time.RFC3339Nano)); err == nil {
+		t.Errorf("a role dropped from the latest version read an earlier version")
+	}
+
+	if _, err := contract.GetAssetHistory(historyContextOf(t, stub, doctor), "pc2"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected the history of an unknown asset to be refused, got %v", err)
+	}
+}
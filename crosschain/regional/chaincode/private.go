@@ -0,0 +1,255 @@
+package chaincode
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transient map keys read by the regional chaincode. Values passed in the transient map reach the
+// endorsing peers but are not written to the transaction, so identifying fields never hit the block.
+const (
+	TransientAssetKey    = "asset_properties" // JSON assetProperties for CreateAsset, UpdateAsset, TransferAsset and VerifyPrivateHash
+	TransientSeedSaltKey = "seed_salt"        // salt for the records generated by InitLedger
+)
+
+// MinSaltLength is the shortest salt accepted, so the public hash of a short owner name cannot be brute-forced
+const MinSaltLength = 16
+
+// assetProperties are the identifying fields of an asset passed under TransientAssetKey.
+// Writers name the patient in TransientPatientKey; OwnerRef here is only read by VerifyPrivateHash.
+type assetProperties struct {
+	Owner    string `json:"owner"`
+	Metadata string `json:"metadata"`
+	Salt     string `json:"salt"`
+	OwnerRef string `json:"ownerRef,omitempty"`
+	// Document is the digest of the document Metadata points at; it is stored in public state
+	Document *types.ContentDigest `json:"document,omitempty"`
+	// Attachments are the documents CreateAsset starts the policy with; other writers keep the
+	// current ones and VerifyPrivateHash compares them as stored
+	Attachments []types.Attachment `json:"attachments,omitempty"`
+
+	// patientKey seals Owner and Metadata in the collection; nil means the key of OwnerRef, if any
+	patientKey *metadataKey
+}
+
+// VerifyPrivateHash reports whether the owner, metadata and salt passed under TransientAssetKey
+// are the asset's private data; encrypted metadata is compared as stored, i.e. as ciphertext.
+// It only compares them with the public hash, so callers outside the region's collection can
+// check a value the patient shared with them without reading the collection.
+func (s *SmartContract) VerifyPrivateHash(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	asset, err := getPublicAsset(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if asset.PrivateHash == "" {
+		return false, fmt.Errorf("the asset %s does not keep its identifying fields in private data", id)
+	}
+
+	properties, err := transientProperties(ctx)
+	if err != nil {
+		return false, err
+	}
+	private := types.RegionalAssetPrivate{ID: id, Owner: properties.Owner, Metadata: properties.Metadata, Salt: properties.Salt, OwnerRef: properties.OwnerRef, Attachments: properties.Attachments}
+	return private.Hash() == asset.PrivateHash, nil
+}
+
+// transientProperties reads and checks the assetProperties passed under TransientAssetKey
+func transientProperties(ctx contractapi.TransactionContextInterface) (*assetProperties, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	propertiesJSON, ok := transient[TransientAssetKey]
+	if !ok {
+		return nil, fmt.Errorf("the asset properties must be passed in the transient map under %q", TransientAssetKey)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(propertiesJSON))
+	decoder.DisallowUnknownFields()
+	var properties assetProperties
+	err = decoder.Decode(&properties)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", TransientAssetKey, err)
+	}
+	if len(properties.Salt) < MinSaltLength {
+		return nil, fmt.Errorf("the salt must be at least %d characters", MinSaltLength)
+	}
+	if properties.Document != nil {
+		err = properties.Document.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid document in %s: %v", TransientAssetKey, err)
+		}
+	}
+
+	return &properties, nil
+}
+
+// seedSalt returns the salt for InitLedger records, which must be passed under TransientSeedSaltKey
+func seedSalt(ctx contractapi.TransactionContextInterface) (string, error) {
+	transient, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the transient map: %v", err)
+	}
+	salt, ok := transient[TransientSeedSaltKey]
+	if !ok {
+		return "", fmt.Errorf("the seed salt must be passed in the transient map under %q", TransientSeedSaltKey)
+	}
+	if len(salt) < MinSaltLength {
+		return "", fmt.Errorf("the salt must be at least %d characters", MinSaltLength)
+	}
+	return string(salt), nil
+}
+
+// putPrivateAsset stores the identifying fields and the attachments in the region's collection,
+// sealed under the patient key when the asset has an OwnerRef, and the rest of the asset, with the
+// salted hash of the unsealed fields, in public state
+func putPrivateAsset(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset, properties *assetProperties) error {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return err
+	}
+	key := properties.patientKey
+	if key != nil {
+		properties.OwnerRef = key.KeyID
+	} else if properties.OwnerRef != "" {
+		key, err = patientKeyFor(ctx, properties.OwnerRef)
+		if err != nil {
+			return err
+		}
+	}
+	err = indexOwnerRef(ctx, collection, asset.ID, properties.OwnerRef)
+	if err != nil {
+		return err
+	}
+
+	asset.Owner = properties.Owner
+	asset.Metadata = properties.Metadata
+	asset.Attachments = properties.Attachments
+	asset.SyncLegacyAttachment()
+
+	private := types.RegionalAssetPrivate{ID: asset.ID, Owner: asset.Owner, Metadata: asset.Metadata, Salt: properties.Salt, OwnerRef: properties.OwnerRef, Attachments: asset.Attachments}
+	stored := private
+	if key != nil {
+		stored.Owner, err = sealField(ctx, key, asset.ID, "owner", private.Owner)
+		if err != nil {
+			return err
+		}
+		stored.Metadata, err = sealField(ctx, key, asset.ID, "metadata", private.Metadata)
+		if err != nil {
+			return err
+		}
+		stored.Attachments = make([]types.Attachment, len(private.Attachments))
+		for i, attachment := range private.Attachments {
+			attachment.URI, err = sealField(ctx, key, asset.ID, attachmentField(attachment.ID), attachment.URI)
+			if err != nil {
+				return err
+			}
+			stored.Attachments[i] = attachment
+		}
+	}
+	privateJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().PutPrivateData(collection, asset.ID, privateJSON)
+	if err != nil {
+		return fmt.Errorf("failed to put to private data collection %s: %v", collection, err)
+	}
+
+	asset.PrivateHash = private.Hash()
+	return putAsset(ctx, asset)
+}
+
+// loadPrivateDetails fills in Owner, Metadata and the attachments from the region's collection for an asset that keeps them there
+func loadPrivateDetails(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	if asset.PrivateHash == "" {
+		return nil
+	}
+	private, err := getPrivateDetails(ctx, asset)
+	if err != nil {
+		return err
+	}
+
+	asset.Owner = private.Owner
+	asset.Metadata = private.Metadata
+	asset.Attachments = private.Attachments
+	asset.SyncLegacyAttachment()
+	return nil
+}
+
+// getPrivateDetails reads a private asset's collection entry, unseals it with the patient key
+// and checks it against the public hash
+func getPrivateDetails(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) (*types.RegionalAssetPrivate, error) {
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	privateJSON, err := ctx.GetStub().GetPrivateData(collection, asset.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from private data collection %s: %v", collection, err)
+	}
+	if privateJSON == nil {
+		err = checkNotErased(ctx, asset.ID)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("the private data of asset %s is not available on this peer", asset.ID)
+	}
+
+	var private types.RegionalAssetPrivate
+	err = json.Unmarshal(privateJSON, &private)
+	if err != nil {
+		return nil, err
+	}
+	if private.OwnerRef != "" {
+		key, err := patientKeyFor(ctx, private.OwnerRef)
+		if err != nil {
+			return nil, err
+		}
+		private.Owner, err = openField(key, asset.ID, "owner", private.Owner)
+		if err != nil {
+			return nil, err
+		}
+		private.Metadata, err = openField(key, asset.ID, "metadata", private.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		for i := range private.Attachments {
+			attachment := &private.Attachments[i]
+			attachment.URI, err = openField(key, asset.ID, attachmentField(attachment.ID), attachment.URI)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if private.Hash() != asset.PrivateHash {
+		return nil, fmt.Errorf("the private data of asset %s does not match its public hash", asset.ID)
+	}
+	return &private, nil
+}
+
+// storeAsset writes back an asset read with getAsset, keeping the salt and patient key of its private data
+func storeAsset(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	if asset.PrivateHash == "" {
+		return putAsset(ctx, asset)
+	}
+	private, err := getPrivateDetails(ctx, asset)
+	if err != nil {
+		return err
+	}
+	return putPrivateAsset(ctx, asset, &assetProperties{Owner: asset.Owner, Metadata: asset.Metadata, Salt: private.Salt, OwnerRef: private.OwnerRef, Attachments: asset.Attachments})
+}
+
+func privateCollection(ctx contractapi.TransactionContextInterface) (string, error) {
+	config, err := getRegionConfig(ctx)
+	if err != nil {
+		return "", err
+	}
+	return config.PrivateCollection, nil
+}
@@ -0,0 +1,493 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"crosschain/types"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SmartContract provides functions for managing RegionalAsset records of one region
+type SmartContract struct {
+	contractapi.Contract
+}
+
+func generateRegionalAssets(config *RegionConfig, numAssets int) []types.RegionalAsset {
+	var assets []types.RegionalAsset
+	for i := 1; i <= numAssets; i++ {
+		asset := types.RegionalAsset{
+			SchemaVersion: types.RegionalAssetSchemaVersion,
+			ID:            types.NewPolicyID(config.RegionID, SeedHospitalID, i),
+			Owner:         config.Seed.Owner,
+			AuthRoles:     config.DefaultRoles,
+			Grant:         config.Seed.Grant,
+			Metadata:      config.Seed.metadataFor(i),
+		}
+
+		assets = append(assets, asset)
+	}
+	fmt.Printf("Successfully generated %d records for %s\n", numAssets, config.RegionID)
+	return assets
+}
+
+// InitLedger adds a base set of assets to the ledger using the region's seed profile.
+// Owner and Metadata go to the region's private data collection, salted with TransientSeedSaltKey
+// and sealed under the TransientPatientKey; both are required, so no seeded record is left unsealed.
+func (s *SmartContract) InitLedger(ctx contractapi.TransactionContextInterface, numRows int) error {
+	config, err := getRegionConfig(ctx)
+	if err != nil {
+		return err
+	}
+	salt, err := seedSalt(ctx)
+	if err != nil {
+		return err
+	}
+	patient, err := transientPatientKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	assets := generateRegionalAssets(config, numRows)
+
+	for i := range assets {
+		asset := &assets[i]
+		metadata, keyID, err := encryptMetadata(ctx, asset.ID, asset.Metadata)
+		if err != nil {
+			return err
+		}
+		asset.MetadataKeyID = keyID
+		properties := &assetProperties{Owner: asset.Owner, Metadata: metadata, Salt: salt, patientKey: patient}
+		err = putPrivateAsset(ctx, asset, properties)
+		if err != nil {
+			fmt.Printf("INIT: PUT STATE ERR!\n")
+			return err
+		}
+	}
+
+	// fmt.Printf("Initial data added!\n")
+	return nil
+}
+
+// CreateAsset issues a new asset to the world state with given details.
+// Owner, metadata and salt are passed in the transient map under TransientAssetKey and sealed
+// under the TransientPatientKey; the metadata is also encrypted when a TransientMetadataKey is passed.
+// The optional document digest in the same properties is kept in public state, and the optional
+// attachments are added like AddAttachment adds them. The ID must have been issued for this region
+// by globalcc's AllocatePolicyID.
+func (s *SmartContract) CreateAsset(ctx contractapi.TransactionContextInterface, id string, authRoles []string, grant string) error {
+	properties, err := transientProperties(ctx)
+	if err != nil {
+		return err
+	}
+	properties.patientKey, err = transientPatientKey(ctx)
+	if err != nil {
+		return err
+	}
+	exists, err := s.AssetExists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("the asset %s already exists", id)
+	}
+	err = checkAllocated(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := validateGrant(grant); err != nil {
+		return err
+	}
+	if err := validateAuthRoles(authRoles); err != nil {
+		return err
+	}
+	if len(authRoles) == 0 {
+		config, err := getRegionConfig(ctx)
+		if err != nil {
+			return err
+		}
+		authRoles = config.DefaultRoles
+	}
+
+	metadata, keyID, err := encryptMetadata(ctx, id, properties.Metadata)
+	if err != nil {
+		return err
+	}
+	properties.Metadata = metadata
+
+	asset := types.RegionalAsset{
+		SchemaVersion: types.RegionalAssetSchemaVersion,
+		ID:            id,
+		AuthRoles:     authRoles,
+		Grant:         grant,
+		Metadata:      metadata,
+		MetadataKeyID: keyID,
+		Document:      properties.Document,
+	}
+	asset.SyncLegacyAttachment()
+	for _, attachment := range properties.Attachments {
+		err = appendAttachment(ctx, &asset, attachment)
+		if err != nil {
+			return err
+		}
+	}
+	properties.Attachments = asset.Attachments
+
+	return putPrivateAsset(ctx, &asset, properties)
+}
+
+// ReadAsset returns the asset stored in the world state with given id.
+// Encrypted metadata is decrypted when the caller passes its key under TransientMetadataKey.
+func (s *SmartContract) ReadAsset(ctx contractapi.TransactionContextInterface, id string) (*types.RegionalAsset, error) {
+	startTime := time.Now()
+	assetJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("FAIL TO READ!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJSON == nil {
+		duration := time.Since(startTime)
+		fmt.Printf("NOT FOUND!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, fmt.Errorf("the asset %s does not exist", id)
+	}
+
+	asset, err := types.DecodeRegionalAsset(assetJSON)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("UNMARSHAL ERR!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, err
+	}
+
+	err = loadPrivateDetails(ctx, asset)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("PRIVATE DATA ERR!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, err
+	}
+
+	err = checkAccess(ctx, asset, GrantRead)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("ACCESS DENIED!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, err
+	}
+
+	err = decryptMetadata(ctx, asset)
+	if err != nil {
+		duration := time.Since(startTime)
+		fmt.Printf("DECRYPT ERR!! Time taken for query for id (%s): %s\n", id, duration)
+
+		return nil, err
+	}
+
+	duration := time.Since(startTime)
+	fmt.Printf("Time taken for query for id (%s): %s\n", id, duration)
+
+	return asset, nil
+}
+
+// ReadAssets returns the assets with the given ids in the same order.
+// An id that cannot be read gets an error in its result instead of failing the whole batch.
+func (s *SmartContract) ReadAssets(ctx contractapi.TransactionContextInterface, ids []string) ([]*types.RegionalAssetResult, error) {
+	if len(ids) > types.MaxBatchSize {
+		return nil, fmt.Errorf("a batch may read at most %d assets, got %d", types.MaxBatchSize, len(ids))
+	}
+
+	startTime := time.Now()
+	results := make([]*types.RegionalAssetResult, 0, len(ids))
+	for _, id := range ids {
+		result := &types.RegionalAssetResult{PolicyID: id}
+		asset, err := getAsset(ctx, id)
+		if err == nil {
+			err = checkAccess(ctx, asset, GrantRead)
+		}
+		if err == nil {
+			err = decryptMetadata(ctx, asset)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Asset = asset
+		}
+		results = append(results, result)
+	}
+
+	duration := time.Since(startTime)
+	fmt.Printf("Time taken for batch query of %d ids: %s\n", len(ids), duration)
+
+	return results, nil
+}
+
+// UpdateAsset updates an existing asset in the world state with provided parameters.
+// Owner, metadata and salt are passed in the transient map under TransientAssetKey and sealed
+// under the TransientPatientKey; the metadata is also encrypted when a TransientMetadataKey is passed.
+// The optional document digest in the same properties is kept in public state; the attachments
+// are kept, and the one mirroring the metadata URL follows the new URL. expectedVersion is the
+// version the caller read; the update fails with a VersionConflictError when it is stale.
+// Empty authRoles take the region's defaultRoles, as in CreateAsset.
+func (s *SmartContract) UpdateAsset(ctx contractapi.TransactionContextInterface, id string, authRoles []string, grant string, expectedVersion int) error {
+	properties, err := transientProperties(ctx)
+	if err != nil {
+		return err
+	}
+	properties.patientKey, err = transientPatientKey(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := getAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = checkAccess(ctx, current, GrantWrite)
+	if err != nil {
+		return err
+	}
+	err = checkVersion(current, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if err := validateGrant(grant); err != nil {
+		return err
+	}
+	if err := validateAuthRoles(authRoles); err != nil {
+		return err
+	}
+	if len(authRoles) == 0 {
+		config, err := getRegionConfig(ctx)
+		if err != nil {
+			return err
+		}
+		authRoles = config.DefaultRoles
+	}
+
+	// overwriting original asset with new asset, keeping the owner's consents
+	metadata, keyID, err := encryptMetadata(ctx, id, properties.Metadata)
+	if err != nil {
+		return err
+	}
+	properties.Metadata = metadata
+
+	asset := types.RegionalAsset{
+		SchemaVersion: types.RegionalAssetSchemaVersion,
+		ID:            id,
+		AuthRoles:     authRoles,
+		Grant:         grant,
+		Consents:      current.Consents,
+		MetadataKeyID: keyID,
+		Document:      properties.Document,
+		Version:       current.Version,
+	}
+	properties.Attachments = current.Attachments
+
+	return putPrivateAsset(ctx, &asset, properties)
+}
+
+// DeleteAsset deletes an given asset from the world state. The caller needs write access.
+func (s *SmartContract) DeleteAsset(ctx contractapi.TransactionContextInterface, id string) error {
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = checkAccess(ctx, asset, GrantWrite)
+	if err != nil {
+		return err
+	}
+
+	collection, err := privateCollection(ctx)
+	if err != nil {
+		return err
+	}
+	err = indexOwnerRef(ctx, collection, id, "")
+	if err != nil {
+		return err
+	}
+	err = ctx.GetStub().DelPrivateData(collection, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete from private data collection %s: %v", collection, err)
+	}
+	return ctx.GetStub().DelState(id)
+}
+
+// AssetExists returns true when asset with given ID exists in world state
+func (s *SmartContract) AssetExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	assetJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to read from world state: %v", err)
+	}
+
+	return assetJSON != nil, nil
+}
+
+// getAsset reads an asset, with its private data, without any access check
+func getAsset(ctx contractapi.TransactionContextInterface, id string) (*types.RegionalAsset, error) {
+	asset, err := getPublicAsset(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	err = loadPrivateDetails(ctx, asset)
+	if err != nil {
+		return nil, err
+	}
+	return asset, nil
+}
+
+// getPublicAsset reads an asset from world state only; a private asset comes back with an empty Owner and Metadata
+func getPublicAsset(ctx contractapi.TransactionContextInterface, id string) (*types.RegionalAsset, error) {
+	assetJSON, err := ctx.GetStub().GetState(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from world state: %v", err)
+	}
+	if assetJSON == nil {
+		return nil, fmt.Errorf("the asset %s does not exist", id)
+	}
+
+	return types.DecodeRegionalAsset(assetJSON)
+}
+
+// putAsset stores an asset at the current schema version and the next version number. The Owner,
+// Metadata and attachments of a private asset are left out of public state; putPrivateAsset writes
+// them to the collection.
+func putAsset(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	asset.SchemaVersion = types.RegionalAssetSchemaVersion
+	asset.Version++
+	asset.SyncLegacyAttachment()
+	stored := *asset
+	if stored.PrivateHash != "" {
+		stored.Owner = ""
+		stored.Metadata = ""
+		stored.Attachments = nil
+	}
+	assetJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(asset.ID, assetJSON)
+}
+
+// TransferAsset updates the owner field of asset with given id in world state.
+// The new owner and a fresh salt are passed under TransientAssetKey and the new owner's key under
+// TransientPatientKey; the metadata and attachments are kept. expectedVersion works as in UpdateAsset.
+func (s *SmartContract) TransferAsset(ctx contractapi.TransactionContextInterface, id string, expectedVersion int) error {
+	properties, err := transientProperties(ctx)
+	if err != nil {
+		return err
+	}
+	properties.patientKey, err = transientPatientKey(ctx)
+	if err != nil {
+		return err
+	}
+	asset, err := getAsset(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = checkAccess(ctx, asset, GrantWrite)
+	if err != nil {
+		return err
+	}
+
+	err = checkVersion(asset, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	properties.Metadata = asset.Metadata
+	properties.Attachments = asset.Attachments
+	return putPrivateAsset(ctx, asset, properties)
+}
+
+// GetAllAssets returns all assets found in world state. Assets the caller may not read come back
+// with their public fields only.
+func (s *SmartContract) GetAllAssets(ctx contractapi.TransactionContextInterface) ([]*types.RegionalAsset, error) {
+	// range query with empty string for startKey and endKey does an
+	// open-ended query of all assets in the chaincode namespace.
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var assets []*types.RegionalAsset
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := types.DecodeRegionalAsset(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		err = loadListedDetails(ctx, asset)
+		if isErased(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+
+	return assets, nil
+}
+
+// loadListedDetails fills in the private details of a listed asset the caller may read, and clears
+// Owner, Metadata and the attachments, which older assets keep in public state, of any other
+func loadListedDetails(ctx contractapi.TransactionContextInterface, asset *types.RegionalAsset) error {
+	if checkAccess(ctx, asset, GrantRead) == nil {
+		return loadPrivateDetails(ctx, asset)
+	}
+	asset.Owner = ""
+	asset.Metadata = ""
+	asset.Attachments = nil
+	return checkNotErased(ctx, asset.ID)
+}
+
+// GetAllAssetsWithPagination returns one page of assets in key order, with the public fields only
+// of those the caller may not read.
+// Pass the returned bookmark to get the next page; pagination only works in evaluated (query) transactions.
+func (s *SmartContract) GetAllAssetsWithPagination(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*types.RegionalAssetPage, error) {
+	err := types.ValidatePageSize(pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, responseMetadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &types.RegionalAssetPage{Records: []*types.RegionalAsset{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		asset, err := types.DecodeRegionalAsset(queryResponse.Value)
+		if err != nil {
+			return nil, err
+		}
+		err = loadListedDetails(ctx, asset)
+		if isErased(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+
+	page.FetchedCount = responseMetadata.GetFetchedRecordsCount()
+	page.Bookmark = responseMetadata.GetBookmark()
+	return page, nil
+}
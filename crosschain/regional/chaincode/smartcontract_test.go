@@ -0,0 +1,58 @@
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"crosschain/types"
+)
+
+func TestInitLedgerRequiresPatientKeyAndSalt(t *testing.T) {
+	stub := newTestStub(t)
+	caller := testIdentity(t, "Org1MSP", "client", map[string]string{RoleAttribute: "DoctorReg1"})
+	patient, _ := json.Marshal(patientKey{OwnerRef: "seed-region1", Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Custody: CustodyCollection})
+	salt := []byte("0123456789abcdef")
+
+	for _, test := range []struct {
+		name      string
+		transient map[string][]byte
+		message   string
+	}{
+		{"nothing", map[string][]byte{}, TransientSeedSaltKey},
+		{"salt only", map[string][]byte{TransientSeedSaltKey: salt}, TransientPatientKey},
+		{"patient key only", map[string][]byte{TransientPatientKey: patient}, TransientSeedSaltKey},
+		{"short salt", map[string][]byte{TransientPatientKey: patient, TransientSeedSaltKey: []byte("short")}, "at least"},
+	} {
+		stub.TransientMap = test.transient
+		response := invoke(stub, caller, "InitLedger", "2")
+		if response.Status == 200 || !strings.Contains(response.Message, test.message) {
+			t.Errorf("%s: expected InitLedger to be refused for %s, got %d %s", test.name, test.message, response.Status, response.Message)
+		}
+	}
+
+	stub.TransientMap = map[string][]byte{TransientPatientKey: patient, TransientSeedSaltKey: salt}
+	response := invoke(stub, caller, "InitLedger", "2")
+	if response.Status != 200 {
+		t.Fatalf("InitLedger failed: %s", response.Message)
+	}
+	for i := 1; i <= 2; i++ {
+		id := types.NewPolicyID("region1", SeedHospitalID, i)
+		if state, _ := stub.GetState(id); state == nil {
+			t.Fatalf("InitLedger did not seed %s", id)
+		}
+	}
+	if state, _ := stub.GetState("pc1"); state != nil {
+		t.Fatalf("InitLedger seeded the bare ID pc1, which every region shares")
+	}
+	collection := stub.PvtState["region1PrivateCollection"]
+	if len(collection) == 0 {
+		t.Fatalf("InitLedger stored no private data")
+	}
+	for key, value := range collection {
+		if strings.Contains(string(value), "PATIENT 1") || strings.Contains(string(value), "https://example.com") {
+			t.Fatalf("the seeded record %s was stored unsealed: %s", key, value)
+		}
+	}
+}
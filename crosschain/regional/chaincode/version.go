@@ -0,0 +1,42 @@
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"crosschain/types"
+)
+
+// CodeVersionConflict is returned in VersionConflictError.Code
+const CodeVersionConflict = "VERSION_CONFLICT"
+
+// VersionConflictError is returned when an update names a version of the asset that is no longer
+// current, so a writer working from a stale read cannot overwrite someone else's change. Like
+// AccessError, its message is JSON.
+type VersionConflictError struct {
+	Code            string `json:"code"`
+	AssetID         string `json:"assetID"`
+	ExpectedVersion int    `json:"expectedVersion"`
+	CurrentVersion  int    `json:"currentVersion"`
+}
+
+func (e *VersionConflictError) Error() string {
+	errJSON, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprintf("%s: asset %s is at version %d, not %d", e.Code, e.AssetID, e.CurrentVersion, e.ExpectedVersion)
+	}
+	return string(errJSON)
+}
+
+// checkVersion fails with a VersionConflictError unless the asset is at expectedVersion
+func checkVersion(asset *types.RegionalAsset, expectedVersion int) error {
+	if asset.Version == expectedVersion {
+		return nil
+	}
+	return &VersionConflictError{
+		Code:            CodeVersionConflict,
+		AssetID:         asset.ID,
+		ExpectedVersion: expectedVersion,
+		CurrentVersion:  asset.Version,
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"regional/chaincode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+func main() {
+	assetChaincode, err := contractapi.NewChaincode(&chaincode.SmartContract{})
+	if err != nil {
+		log.Panicf("Error creating regionalCC chaincode: %v", err)
+	}
+
+	// Run as an external chaincode service when the peer connects to us
+	if address := os.Getenv("CHAINCODE_SERVER_ADDRESS"); address != "" {
+		server := &shim.ChaincodeServer{
+			CCID:    os.Getenv("CHAINCODE_ID"),
+			Address: address,
+			CC:      assetChaincode,
+			TLSProps: shim.TLSProperties{
+				Disabled: true,
+			},
+		}
+		if err := server.Start(); err != nil {
+			log.Panicf("Error starting regionalCC chaincode service: %v", err)
+		}
+		return
+	}
+
+	if err := assetChaincode.Start(); err != nil {
+		log.Panicf("Error starting regionalCC chaincode: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// MaxAttachments is the largest number of attachments a single policy may reference
+const MaxAttachments = 64
+
+// LegacyAttachmentID is the ID of the attachment that mirrors a policy's Metadata URL, so records
+// written before policies held a list of attachments read as a policy with one attachment
+const LegacyAttachmentID = "metadata"
+
+// Attachment describes one off-chain document a policy references, such as lab results, imaging
+// or a signed consent. SHA256, MediaType and Size pin its content. CreatedAt is the RFC 3339
+// transaction timestamp of the write that added it. The LegacyAttachmentID attachment mirrors
+// Metadata: it has no CreatedAt, and no digest unless the policy has a Document.
+type Attachment struct {
+	ID        string `json:"id"`
+	URI       string `json:"uri"`
+	SHA256    string `json:"sha256"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// Digest returns the content digest of the attachment, or nil when it has none
+func (a *Attachment) Digest() *ContentDigest {
+	if a.SHA256 == "" {
+		return nil
+	}
+	return &ContentDigest{SHA256: a.SHA256, Size: a.Size, MediaType: a.MediaType}
+}
+
+// Validate checks that the attachment has an ID, an absolute URI and, when it has one, a valid digest
+func (a *Attachment) Validate() error {
+	if a.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if uri, err := url.Parse(a.URI); err != nil || !uri.IsAbs() {
+		return fmt.Errorf("uri must be an absolute URI")
+	}
+	if digest := a.Digest(); digest != nil {
+		return digest.Validate()
+	}
+	if a.MediaType != "" || a.Size != 0 {
+		return fmt.Errorf("mediaType and size need a sha256")
+	}
+	return nil
+}
+
+// FindAttachment returns the index of the attachment with the given ID, or -1
+func (a *RegionalAsset) FindAttachment(attachmentID string) int {
+	for i := range a.Attachments {
+		if a.Attachments[i].ID == attachmentID {
+			return i
+		}
+	}
+	return -1
+}
+
+// SyncLegacyAttachment keeps the attachment LegacyAttachmentID in step with the Metadata URL and
+// the Document digest pinned to it, which is how single-URL records take the list form. The
+// attachment is dropped while Metadata is encrypted or is not an absolute URI.
+func (a *RegionalAsset) SyncLegacyAttachment() {
+	index := a.FindAttachment(LegacyAttachmentID)
+	if uri, err := url.Parse(a.Metadata); a.MetadataKeyID != "" || err != nil || !uri.IsAbs() {
+		if index >= 0 {
+			a.Attachments = append(a.Attachments[:index:index], a.Attachments[index+1:]...)
+		}
+		return
+	}
+
+	attachment := Attachment{ID: LegacyAttachmentID, URI: a.Metadata}
+	if a.Document != nil {
+		attachment.SHA256 = a.Document.SHA256
+		attachment.MediaType = a.Document.MediaType
+		attachment.Size = a.Document.Size
+	}
+	if index >= 0 {
+		a.Attachments[index] = attachment
+		return
+	}
+	a.Attachments = append([]Attachment{attachment}, a.Attachments...)
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MaxBatchSize is the largest number of policies a single batch read may ask for
+const MaxBatchSize = 500
+
+// PolicyRequest names a policy and the hospital whose region holds it
+type PolicyRequest struct {
+	HospitalID string `json:"hospitalID"`
+	PolicyID   string `json:"policyID"`
+}
+
+// RegionalAssetResult is one item of a batch read: either the asset or the reason it could not be read
+type RegionalAssetResult struct {
+	PolicyID   string         `json:"policyID"`
+	HospitalID string         `json:"hospitalID,omitempty" metadata:",optional"`
+	Asset      *RegionalAsset `json:"asset,omitempty" metadata:",optional"`
+	Error      string         `json:"error,omitempty" metadata:",optional"`
+}
+
+// regionalAssetResultJSON defers decoding of the asset so it goes through DecodeRegionalAsset
+type regionalAssetResultJSON struct {
+	PolicyID   string          `json:"policyID"`
+	HospitalID string          `json:"hospitalID,omitempty"`
+	Asset      json.RawMessage `json:"asset,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// DecodeRegionalAssetResults strictly decodes a batch read result list.
+// An asset that fails to decode becomes that item's error rather than failing the batch.
+func DecodeRegionalAssetResults(data []byte) ([]*RegionalAssetResult, error) {
+	var items []regionalAssetResultJSON
+	if err := decodeStrict(data, &items); err != nil {
+		return nil, &SchemaError{Type: "RegionalAssetResult", Reason: err.Error()}
+	}
+
+	results := make([]*RegionalAssetResult, 0, len(items))
+	for _, item := range items {
+		if item.PolicyID == "" {
+			return nil, &SchemaError{Type: "RegionalAssetResult", Reason: "policyID is required"}
+		}
+
+		result := &RegionalAssetResult{PolicyID: item.PolicyID, HospitalID: item.HospitalID, Error: item.Error}
+		if len(item.Asset) > 0 && string(item.Asset) != "null" {
+			asset, err := DecodeRegionalAsset(item.Asset)
+			if err != nil {
+				result.Error = err.Error()
+			} else if asset.ID != item.PolicyID {
+				result.Error = fmt.Sprintf("asked for policy %s, got %s", item.PolicyID, asset.ID)
+			} else {
+				result.Asset = asset
+			}
+		}
+		if result.Asset == nil && result.Error == "" {
+			result.Error = "no asset returned"
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
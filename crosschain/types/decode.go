@@ -0,0 +1,198 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// SchemaError is returned when a payload does not match the schema it claims to follow
+type SchemaError struct {
+	Type    string
+	Version int
+	Reason  string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("invalid %s payload (schema version %d): %s", e.Type, e.Version, e.Reason)
+}
+
+// globalAssetV1 is the unversioned GlobalAsset layout
+type globalAssetV1 struct {
+	HospitalID     string `json:"hospitalID"`
+	RegionalCCName string `json:"regionalCCName"`
+}
+
+// globalAssetV2 is the GlobalAsset layout before the hospital lifecycle fields
+type globalAssetV2 struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	HospitalID     string `json:"hospitalID"`
+	RegionalCCName string `json:"regionalCCName"`
+}
+
+// assetV1 is the unversioned Asset layout
+type assetV1 struct {
+	ID             string `json:"ID"`
+	Color          string `json:"color"`
+	Size           int    `json:"size"`
+	Owner          string `json:"owner"`
+	AppraisedValue int    `json:"appraisedValue"`
+}
+
+// DecodeRegionalAsset strictly decodes a RegionalAsset of any known schema version
+// and upgrades it to RegionalAssetSchemaVersion. Every field added since the unversioned layout
+// is optional, so every version decodes into RegionalAsset and is left with their zero values;
+// unlike GlobalAsset, whose version 3 made Status required, there is no layout to upgrade from.
+func DecodeRegionalAsset(data []byte) (*RegionalAsset, error) {
+	version, err := schemaVersionOf("RegionalAsset", data)
+	if err != nil {
+		return nil, err
+	}
+	if version > RegionalAssetSchemaVersion {
+		return nil, &SchemaError{Type: "RegionalAsset", Version: version, Reason: "unsupported schema version"}
+	}
+
+	var asset RegionalAsset
+	if err := decodeStrict(data, &asset); err != nil {
+		return nil, &SchemaError{Type: "RegionalAsset", Version: version, Reason: err.Error()}
+	}
+	asset.SchemaVersion = RegionalAssetSchemaVersion
+	// records written before attachments have none: their Metadata URL becomes the only one when
+	// public state holds it. The regional chaincode does the same for private records once it has
+	// read their Metadata.
+	if asset.Attachments == nil {
+		asset.SyncLegacyAttachment()
+	}
+
+	if err := asset.Validate(); err != nil {
+		return nil, &SchemaError{Type: "RegionalAsset", Version: version, Reason: err.Error()}
+	}
+	return &asset, nil
+}
+
+// DecodeGlobalAsset strictly decodes a GlobalAsset of any known schema version
+// and upgrades it to GlobalAssetSchemaVersion.
+func DecodeGlobalAsset(data []byte) (*GlobalAsset, error) {
+	version, err := schemaVersionOf("GlobalAsset", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var asset GlobalAsset
+	switch version {
+	case 1:
+		var v1 globalAssetV1
+		if err := decodeStrict(data, &v1); err != nil {
+			return nil, &SchemaError{Type: "GlobalAsset", Version: version, Reason: err.Error()}
+		}
+		asset = upgradeGlobalAssetV2(upgradeGlobalAssetV1(v1))
+	case 2:
+		var v2 globalAssetV2
+		if err := decodeStrict(data, &v2); err != nil {
+			return nil, &SchemaError{Type: "GlobalAsset", Version: version, Reason: err.Error()}
+		}
+		asset = upgradeGlobalAssetV2(v2)
+	case GlobalAssetSchemaVersion:
+		if err := decodeStrict(data, &asset); err != nil {
+			return nil, &SchemaError{Type: "GlobalAsset", Version: version, Reason: err.Error()}
+		}
+	default:
+		return nil, &SchemaError{Type: "GlobalAsset", Version: version, Reason: "unsupported schema version"}
+	}
+
+	if err := asset.Validate(); err != nil {
+		return nil, &SchemaError{Type: "GlobalAsset", Version: version, Reason: err.Error()}
+	}
+	return &asset, nil
+}
+
+// DecodeAsset strictly decodes an Asset of any known schema version
+// and upgrades it to AssetSchemaVersion.
+func DecodeAsset(data []byte) (*Asset, error) {
+	version, err := schemaVersionOf("Asset", data)
+	if err != nil {
+		return nil, err
+	}
+
+	var asset Asset
+	switch version {
+	case 1:
+		var v1 assetV1
+		if err := decodeStrict(data, &v1); err != nil {
+			return nil, &SchemaError{Type: "Asset", Version: version, Reason: err.Error()}
+		}
+		asset = upgradeAssetV1(v1)
+	case AssetSchemaVersion:
+		if err := decodeStrict(data, &asset); err != nil {
+			return nil, &SchemaError{Type: "Asset", Version: version, Reason: err.Error()}
+		}
+	default:
+		return nil, &SchemaError{Type: "Asset", Version: version, Reason: "unsupported schema version"}
+	}
+
+	if err := asset.Validate(); err != nil {
+		return nil, &SchemaError{Type: "Asset", Version: version, Reason: err.Error()}
+	}
+	return &asset, nil
+}
+
+func upgradeGlobalAssetV1(v1 globalAssetV1) globalAssetV2 {
+	return globalAssetV2{
+		SchemaVersion:  2,
+		HospitalID:     v1.HospitalID,
+		RegionalCCName: v1.RegionalCCName,
+	}
+}
+
+// upgradeGlobalAssetV2 treats hospitals registered before the lifecycle existed as active
+func upgradeGlobalAssetV2(v2 globalAssetV2) GlobalAsset {
+	return GlobalAsset{
+		SchemaVersion:  GlobalAssetSchemaVersion,
+		HospitalID:     v2.HospitalID,
+		RegionalCCName: v2.RegionalCCName,
+		Status:         HospitalActive,
+	}
+}
+
+func upgradeAssetV1(v1 assetV1) Asset {
+	return Asset{
+		SchemaVersion:  AssetSchemaVersion,
+		ID:             v1.ID,
+		Color:          v1.Color,
+		Size:           v1.Size,
+		Owner:          v1.Owner,
+		AppraisedValue: v1.AppraisedValue,
+	}
+}
+
+// schemaVersionOf returns the schemaVersion of a JSON object, or 1 when it has none
+func schemaVersionOf(typeName string, data []byte) (int, error) {
+	var probe struct {
+		SchemaVersion *int `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return 0, &SchemaError{Type: typeName, Reason: err.Error()}
+	}
+
+	if probe.SchemaVersion == nil {
+		return 1, nil
+	}
+	if *probe.SchemaVersion < 1 {
+		return 0, &SchemaError{Type: typeName, Version: *probe.SchemaVersion, Reason: "invalid schema version"}
+	}
+	return *probe.SchemaVersion, nil
+}
+
+// decodeStrict decodes a single JSON value into v, rejecting unknown fields and trailing data
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeRegionalAsset(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		data        string
+		attachments int
+		version     int
+	}{
+		{"unversioned", `{"ID":"pc1","owner":"P","authRoles":[],"grant":"R","metadata":"https://example.com"}`, 1, 0},
+		{"without optional fields", `{"schemaVersion":2,"ID":"pc1","owner":"P","authRoles":[],"grant":"R","metadata":"https://example.com","version":4}`, 1, 4},
+		{"private", `{"schemaVersion":2,"ID":"pc1","owner":"","authRoles":[],"grant":"R","metadata":"","privateHash":"ab","version":1}`, 0, 1},
+		{"with attachments", `{"schemaVersion":2,"ID":"pc1","owner":"P","authRoles":[],"grant":"R","metadata":"https://example.com","attachments":[{"id":"metadata","uri":"https://example.com","createdAt":"2024-01-01T00:00:00Z"},{"id":"scan","uri":"https://example.org","sha256":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa","mediaType":"image/png","size":5}],"version":2}`, 2, 2},
+	} {
+		asset, err := DecodeRegionalAsset([]byte(test.data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if asset.SchemaVersion != RegionalAssetSchemaVersion || len(asset.Attachments) != test.attachments || asset.Version != test.version {
+			t.Errorf("%s: unexpected asset %+v", test.name, asset)
+		}
+	}
+}
+
+func TestDecodeRegionalAssetRejects(t *testing.T) {
+	for _, data := range []string{
+		`{"schemaVersion":3,"ID":"pc1","owner":"P","authRoles":[],"grant":"R","metadata":""}`,
+		`{"schemaVersion":2,"ID":"pc1","owner":"P","authRoles":[],"grant":"R","metadata":"","color":"blue"}`,
+		`{"schemaVersion":2,"ID":"","owner":"P","authRoles":[],"grant":"R","metadata":""}`,
+	} {
+		var schemaErr *SchemaError
+		if _, err := DecodeRegionalAsset([]byte(data)); !errors.As(err, &schemaErr) {
+			t.Errorf("expected a SchemaError for %s, got %v", data, err)
+		}
+	}
+}
+
+func TestDecodeGlobalAssetUpgrades(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+	}{
+		{"unversioned", `{"hospitalID":"HP1","regionalCCName":"regionalCC1"}`},
+		{"version 2", `{"schemaVersion":2,"hospitalID":"HP1","regionalCCName":"regionalCC1"}`},
+		{"current", `{"schemaVersion":3,"hospitalID":"HP1","regionalCCName":"regionalCC1","status":"active"}`},
+	} {
+		asset, err := DecodeGlobalAsset([]byte(test.data))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if asset.SchemaVersion != GlobalAssetSchemaVersion || asset.HospitalID != "HP1" || asset.RegionalCCName != "regionalCC1" || asset.Status != HospitalActive {
+			t.Errorf("%s: unexpected asset %+v", test.name, asset)
+		}
+	}
+
+	// a version 3 record must carry its status, and older layouts have none to carry
+	for _, data := range []string{
+		`{"schemaVersion":3,"hospitalID":"HP1","regionalCCName":"regionalCC1"}`,
+		`{"schemaVersion":2,"hospitalID":"HP1","regionalCCName":"regionalCC1","status":"suspended"}`,
+		`{"schemaVersion":4,"hospitalID":"HP1","regionalCCName":"regionalCC1","status":"active"}`,
+	} {
+		var schemaErr *SchemaError
+		if _, err := DecodeGlobalAsset([]byte(data)); !errors.As(err, &schemaErr) {
+			t.Errorf("expected a SchemaError for %s, got %v", data, err)
+		}
+	}
+}
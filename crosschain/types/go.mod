@@ -0,0 +1,3 @@
+module crosschain/types
+
+go 1.22.2
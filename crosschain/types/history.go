@@ -0,0 +1,40 @@
+package types
+
+import "encoding/json"
+
+// RegionalAssetVersion is one committed version of a RegionalAsset.
+// Timestamp is the RFC 3339 time of the transaction that wrote it; Asset is nil for a delete.
+type RegionalAssetVersion struct {
+	TxID      string         `json:"txID"`
+	Timestamp string         `json:"timestamp"`
+	IsDelete  bool           `json:"isDelete"`
+	Asset     *RegionalAsset `json:"asset,omitempty" metadata:",optional"`
+}
+
+// DecodeRegionalAssetHistory strictly decodes a RegionalAsset history, upgrading each version
+func DecodeRegionalAssetHistory(data []byte) ([]*RegionalAssetVersion, error) {
+	var raw []struct {
+		TxID      string          `json:"txID"`
+		Timestamp string          `json:"timestamp"`
+		IsDelete  bool            `json:"isDelete"`
+		Asset     json.RawMessage `json:"asset,omitempty"`
+	}
+	if err := decodeStrict(data, &raw); err != nil {
+		return nil, &SchemaError{Type: "RegionalAssetVersion", Reason: err.Error()}
+	}
+
+	history := make([]*RegionalAssetVersion, 0, len(raw))
+	for _, item := range raw {
+		version := &RegionalAssetVersion{TxID: item.TxID, Timestamp: item.Timestamp, IsDelete: item.IsDelete}
+		if len(item.Asset) > 0 && string(item.Asset) != "null" {
+			asset, err := DecodeRegionalAsset(item.Asset)
+			if err != nil {
+				return nil, err
+			}
+			version.Asset = asset
+		}
+		history = append(history, version)
+	}
+
+	return history, nil
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MaxPageSize is the largest page a paginated query may ask for
+const MaxPageSize = 1000
+
+// GlobalAssetPage is one page of hospital registry records.
+// Bookmark is passed back to fetch the next page; a page with fewer records than asked for is the last one.
+type GlobalAssetPage struct {
+	Records      []*GlobalAsset `json:"records"`
+	FetchedCount int32          `json:"fetchedCount"`
+	Bookmark     string         `json:"bookmark"`
+}
+
+// RegionalAssetPage is one page of a regional chaincode's policies
+type RegionalAssetPage struct {
+	Records      []*RegionalAsset `json:"records"`
+	FetchedCount int32            `json:"fetchedCount"`
+	Bookmark     string           `json:"bookmark"`
+}
+
+// AssetPage is one page of atcc assets
+type AssetPage struct {
+	Records      []*Asset `json:"records"`
+	FetchedCount int32    `json:"fetchedCount"`
+	Bookmark     string   `json:"bookmark"`
+}
+
+// ValidatePageSize checks a requested page size against MaxPageSize
+func ValidatePageSize(pageSize int32) error {
+	if pageSize < 1 || pageSize > MaxPageSize {
+		return fmt.Errorf("page size must be between 1 and %d, got %d", MaxPageSize, pageSize)
+	}
+	return nil
+}
+
+// DecodeRegionalAssetPage strictly decodes a page of regional assets, upgrading each record
+func DecodeRegionalAssetPage(data []byte) (*RegionalAssetPage, error) {
+	var raw struct {
+		Records      []json.RawMessage `json:"records"`
+		FetchedCount int32             `json:"fetchedCount"`
+		Bookmark     string            `json:"bookmark"`
+	}
+	if err := decodeStrict(data, &raw); err != nil {
+		return nil, &SchemaError{Type: "RegionalAssetPage", Reason: err.Error()}
+	}
+
+	page := &RegionalAssetPage{Records: make([]*RegionalAsset, 0, len(raw.Records)), FetchedCount: raw.FetchedCount, Bookmark: raw.Bookmark}
+	for _, record := range raw.Records {
+		asset, err := DecodeRegionalAsset(record)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, asset)
+	}
+
+	return page, nil
+}
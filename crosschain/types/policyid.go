@@ -0,0 +1,44 @@
+package types
+
+import (
+	"strconv"
+	"strings"
+)
+
+// PolicyIDSeparator separates the parts of a policy ID allocated by globalcc, so region and
+// hospital IDs that take part in allocation may not contain it
+const PolicyIDSeparator = ":"
+
+// SeedHospitalID is the hospital part of the policy IDs a regional InitLedger generates, e.g.
+// "region1:seed:pc7". No hospital may register under it, so seeded IDs never collide with the IDs
+// globalcc's AllocatePolicyID issues.
+const SeedHospitalID = "seed"
+
+// PolicyAllocation is globalcc's record of a policy ID it issued to a hospital.
+// AllocatedAt is the RFC 3339 transaction timestamp.
+type PolicyAllocation struct {
+	PolicyID    string `json:"policyID"`
+	HospitalID  string `json:"hospitalID"`
+	Region      string `json:"region"`
+	TxID        string `json:"txID"`
+	AllocatedAt string `json:"allocatedAt"`
+}
+
+// NewPolicyID returns the policy ID for a hospital's sequence-th allocation, namespaced by the
+// region and the hospital so that no two regions can mint the same ID, e.g. "region1:HP1:pc7"
+func NewPolicyID(region string, hospitalID string, sequence int) string {
+	return region + PolicyIDSeparator + hospitalID + PolicyIDSeparator + "pc" + strconv.Itoa(sequence)
+}
+
+// ParsePolicyID returns the region and hospital of an ID made by NewPolicyID. ok is false for
+// other IDs, such as the bare pcN IDs written before allocation.
+func ParsePolicyID(policyID string) (region string, hospitalID string, ok bool) {
+	parts := strings.Split(policyID, PolicyIDSeparator)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || !strings.HasPrefix(parts[2], "pc") {
+		return "", "", false
+	}
+	if sequence, err := strconv.Atoi(strings.TrimPrefix(parts[2], "pc")); err != nil || sequence < 1 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,233 @@
+// Package types holds the ledger record types shared by globalcc, the regional
+// chaincode, atcc and the gateway, together with strict, version-aware decoding.
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// Current schema versions written by this package's users.
+// Version 1 is the unversioned layout written before schemaVersion existed. A version is only
+// bumped by changes older records cannot be read under, such as a new required field; optional
+// fields are added without one.
+const (
+	RegionalAssetSchemaVersion = 2
+	GlobalAssetSchemaVersion   = 3
+	AssetSchemaVersion         = 2
+)
+
+// RegionalAsset is a patient policy held by a regional chaincode
+type RegionalAsset struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	ID            string    `json:"ID"`
+	Owner         string    `json:"owner"`
+	AuthRoles     []string  `json:"authRoles"`
+	Grant         string    `json:"grant"`
+	Metadata      string    `json:"metadata"`
+	Consents      []Consent `json:"consents,omitempty" metadata:",optional"`
+	// PrivateHash is the hex SHA-256 of the asset's RegionalAssetPrivate. When it is set, Owner and
+	// Metadata live in the region's private data collection and are empty in public state.
+	PrivateHash string `json:"privateHash,omitempty" metadata:",optional"`
+	// MetadataKeyID names the data key Metadata is AES-GCM encrypted under. Metadata holds the
+	// ciphertext unless the reader supplied that key.
+	MetadataKeyID string `json:"metadataKeyID,omitempty" metadata:",optional"`
+	// Document describes the content the Metadata URL pointed at when it was registered
+	Document *ContentDigest `json:"document,omitempty" metadata:",optional"`
+	// Attachments are the documents the policy references. Like Owner and Metadata they live in
+	// the region's private data collection when PrivateHash is set.
+	Attachments []Attachment `json:"attachments,omitempty" metadata:",optional"`
+	// Version counts the writes to the asset, starting at 1 when it is created. Records written
+	// before versioning read as version 0. Update-type transactions take the version they expect.
+	Version int `json:"version"`
+}
+
+// ContentDigest pins the content of an off-chain document: its hex SHA-256, size in bytes
+// and media type (e.g. "application/json")
+type ContentDigest struct {
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"mediaType"`
+}
+
+// RegionalAssetPrivate holds the patient-identifying fields of a RegionalAsset in the region's
+// private data collection. The writer picks Salt so the public hash cannot be guessed from Owner.
+// OwnerRef is the pseudonymous patient reference whose key seals Owner, Metadata and the attachment
+// URIs in the collection.
+type RegionalAssetPrivate struct {
+	ID          string       `json:"ID"`
+	Owner       string       `json:"owner"`
+	Metadata    string       `json:"metadata"`
+	Salt        string       `json:"salt"`
+	OwnerRef    string       `json:"ownerRef,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Hash returns the hex SHA-256 of the JSON encoding of p, as stored in RegionalAsset.PrivateHash
+func (p *RegionalAssetPrivate) Hash() string {
+	privateJSON, _ := json.Marshal(p)
+	sum := sha256.Sum256(privateJSON)
+	return hex.EncodeToString(sum[:])
+}
+
+// Consent states stored in Consent.Status
+const (
+	ConsentActive  = "active"
+	ConsentRevoked = "revoked"
+)
+
+// Consent grantee types stored in Consent.GranteeType
+const (
+	GranteeRole     = "role"
+	GranteeIdentity = "identity"
+)
+
+// Consent is a time-bounded permission a policy owner gives to a role or a single identity.
+// Permissions holds "R" and/or "W". ValidFrom, ValidUntil, GrantedAt and RevokedAt are
+// RFC 3339 transaction timestamps.
+type Consent struct {
+	ConsentID   string   `json:"consentID"`
+	GranteeType string   `json:"granteeType"`
+	Grantee     string   `json:"grantee"`
+	Permissions []string `json:"permissions"`
+	ValidFrom   string   `json:"validFrom"`
+	ValidUntil  string   `json:"validUntil"`
+	Purpose     string   `json:"purpose"`
+	Status      string   `json:"status"`
+	GrantedAt   string   `json:"grantedAt"`
+	RevokedAt   string   `json:"revokedAt,omitempty" metadata:",optional"`
+}
+
+// Hospital lifecycle states stored in GlobalAsset.Status
+const (
+	HospitalActive         = "active"
+	HospitalSuspended      = "suspended"
+	HospitalDecommissioned = "decommissioned"
+)
+
+// GlobalAsset is the hospital registry record: it maps a hospital to the regional
+// chaincode that holds its policies and tracks the hospital's lifecycle.
+// CreatedAt and UpdatedAt are RFC 3339 transaction timestamps.
+type GlobalAsset struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	HospitalID     string `json:"hospitalID"`
+	RegionalCCName string `json:"regionalCCName"`
+	Name           string `json:"name,omitempty" metadata:",optional"`
+	MSPID          string `json:"mspID,omitempty" metadata:",optional"`
+	Region         string `json:"region,omitempty" metadata:",optional"`
+	Channel        string `json:"channel,omitempty" metadata:",optional"`
+	Status         string `json:"status"`
+	CreatedAt      string `json:"createdAt,omitempty" metadata:",optional"`
+	UpdatedAt      string `json:"updatedAt,omitempty" metadata:",optional"`
+	Reason         string `json:"reason,omitempty" metadata:",optional"`
+}
+
+// Asset is the single-chain baseline record used by atcc
+type Asset struct {
+	SchemaVersion  int    `json:"schemaVersion"`
+	ID             string `json:"ID"`
+	Color          string `json:"color"`
+	Size           int    `json:"size"`
+	Owner          string `json:"owner"`
+	AppraisedValue int    `json:"appraisedValue"`
+}
+
+// Validate checks the fields every RegionalAsset must carry
+func (a *RegionalAsset) Validate() error {
+	if a.ID == "" {
+		return fmt.Errorf("ID is required")
+	}
+	if a.Version < 0 {
+		return fmt.Errorf("version must not be negative")
+	}
+	for i := range a.Consents {
+		if err := a.Consents[i].Validate(); err != nil {
+			return fmt.Errorf("consent %s: %v", a.Consents[i].ConsentID, err)
+		}
+	}
+	if a.Document != nil {
+		if err := a.Document.Validate(); err != nil {
+			return fmt.Errorf("document: %v", err)
+		}
+	}
+	if len(a.Attachments) > MaxAttachments {
+		return fmt.Errorf("a policy may have at most %d attachments, got %d", MaxAttachments, len(a.Attachments))
+	}
+	for i := range a.Attachments {
+		if err := a.Attachments[i].Validate(); err != nil {
+			return fmt.Errorf("attachment %s: %v", a.Attachments[i].ID, err)
+		}
+		if a.FindAttachment(a.Attachments[i].ID) != i {
+			return fmt.Errorf("attachment %s is listed twice", a.Attachments[i].ID)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the digest is a lowercase hex SHA-256, a size and a media type
+func (d *ContentDigest) Validate() error {
+	if len(d.SHA256) != sha256.Size*2 || strings.ToLower(d.SHA256) != d.SHA256 {
+		return fmt.Errorf("sha256 must be %d lowercase hex characters", sha256.Size*2)
+	}
+	if _, err := hex.DecodeString(d.SHA256); err != nil {
+		return fmt.Errorf("sha256 must be %d lowercase hex characters", sha256.Size*2)
+	}
+	if d.Size < 0 {
+		return fmt.Errorf("size must not be negative")
+	}
+	if _, _, err := mime.ParseMediaType(d.MediaType); err != nil {
+		return fmt.Errorf("invalid mediaType %q: %v", d.MediaType, err)
+	}
+	return nil
+}
+
+// Validate checks the fields every Consent must carry
+func (c *Consent) Validate() error {
+	if c.ConsentID == "" {
+		return fmt.Errorf("consentID is required")
+	}
+	if c.GranteeType != GranteeRole && c.GranteeType != GranteeIdentity {
+		return fmt.Errorf("invalid granteeType %q", c.GranteeType)
+	}
+	if c.Grantee == "" {
+		return fmt.Errorf("grantee is required")
+	}
+	if len(c.Permissions) == 0 {
+		return fmt.Errorf("permissions are required")
+	}
+	if c.ValidFrom == "" || c.ValidUntil == "" {
+		return fmt.Errorf("validFrom and validUntil are required")
+	}
+	if c.Status != ConsentActive && c.Status != ConsentRevoked {
+		return fmt.Errorf("invalid status %q", c.Status)
+	}
+	return nil
+}
+
+// Validate checks the fields every GlobalAsset must carry
+func (a *GlobalAsset) Validate() error {
+	if a.HospitalID == "" {
+		return fmt.Errorf("hospitalID is required")
+	}
+	if a.RegionalCCName == "" {
+		return fmt.Errorf("regionalCCName is required")
+	}
+	switch a.Status {
+	case HospitalActive, HospitalSuspended, HospitalDecommissioned:
+		return nil
+	default:
+		return fmt.Errorf("invalid status %q", a.Status)
+	}
+}
+
+// Validate checks the fields every Asset must carry
+func (a *Asset) Validate() error {
+	if a.ID == "" {
+		return fmt.Errorf("ID is required")
+	}
+	return nil
+}
@@ -1,40 +1,206 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"gateway/internal/fabric"
+	"gateway/internal/handlers"
+	"gateway/internal/index"
 	"gateway/internal/server"
+	"gateway/internal/store"
 )
 
 func main() {
-    // Initial Setup
-    Setup(1)
+	// Initial Setup
+	Setup(1)
+
+	// Select how the gateway reaches the Fabric network
+	ledger, err := NewLedger(1)
+	if err != nil {
+		log.Fatalf("Failed to create ledger client: %v", err)
+	}
+
+	// Load the hospital index, failing loudly rather than routing with an empty one
+	hospitals, err := OpenIndex()
+	if err != nil {
+		log.Fatalf("Failed to load hospital index: %v", err)
+	}
+
+	// Select how hospitals are routed to their regional chaincode
+	routing, err := handlers.NewRouting(ledger, hospitals, handlers.RoutingConfig{
+		Strategy:        os.Getenv("GATEWAY_ROUTER"),
+		GlobalChaincode: os.Getenv("GLOBALCC_NAME"),
+		GlobalChannel:   os.Getenv("GLOBALCC_CHANNEL"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create router: %v", err)
+	}
+
+	// Watch the hospital index and, if GATEWAY_INDEX_SYNC is an interval such as "5m", sync it from globalcc
+	var syncInterval time.Duration
+	if value := os.Getenv("GATEWAY_INDEX_SYNC"); value != "" {
+		syncInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid GATEWAY_INDEX_SYNC: %v", err)
+		}
+	}
+	admin := handlers.NewIndexAdmin(hospitals, routing, handlers.IndexAdminConfig{
+		Token:        os.Getenv("GATEWAY_ADMIN_TOKEN"),
+		SyncInterval: syncInterval,
+	})
+	admin.Start(context.Background())
+
+	// Open the local store of idempotency keys and submitted transactions
+	db, err := OpenStore()
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	defer db.Close()
 
 	// Initialize and start the HTTP server
-	if err := server.Start(); err != nil {
+	if err := server.Start(ledger, db, hospitals, routing, admin); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
+// NewLedger creates the ledger backend named by LEDGER_BACKEND: "cli" (default), "gateway" or "fake"
+func NewLedger(orgID int) (fabric.Ledger, error) {
+	channel := os.Getenv("CHANNEL_NAME")
+	if channel == "" {
+		channel = "mychannel"
+	}
+
+	switch backend := os.Getenv("LEDGER_BACKEND"); backend {
+	case "", "cli":
+		fmt.Println("Using peer CLI ledger backend")
+		return NewPeerCLI(channel), nil
+	case "gateway":
+		orgDir := fmt.Sprintf("%s/organizations/peerOrganizations/org%d.example.com", os.Getenv("PWD"), orgID)
+		userMSP := fmt.Sprintf("%s/users/Gateway@org%d.example.com/msp", orgDir, orgID)
+
+		fmt.Println("Using Fabric Gateway ledger backend")
+		return fabric.NewGatewayLedger(fabric.GatewayConfig{
+			Endpoint:      os.Getenv("CORE_PEER_ADDRESS"),
+			TLSCertPath:   os.Getenv("CORE_PEER_TLS_ROOTCERT_FILE"),
+			TLSServerName: fmt.Sprintf("peer0.org%d.example.com", orgID),
+			MSPID:         os.Getenv("CORE_PEER_LOCALMSPID"),
+			CertPath:      userMSP + "/signcerts",
+			KeyPath:       userMSP + "/keystore",
+			Channel:       channel,
+		})
+	case "fake":
+		fmt.Println("Using in-memory fake ledger backend")
+		return fabric.NewFakeLedger(), nil
+	default:
+		return nil, fmt.Errorf("unknown ledger backend %q", backend)
+	}
+}
+
+// NewPeerCLI configures the peer CLI backend as the test-network scripts invoke: submits are ordered
+// through ORDERER_ADDRESS, by default the test network's localhost:7050, over TLS with ORDERER_CA and
+// ORDERER_TLS_HOSTNAME. PEER_ADDRESSES and PEER_TLS_ROOTCERT_FILES list the endorsing peers, comma
+// separated and in the same order; when unset the peer at CORE_PEER_ADDRESS endorses alone.
+func NewPeerCLI(channel string) *fabric.PeerCLI {
+	cli := &fabric.PeerCLI{
+		Channel:            channel,
+		OrdererAddress:     os.Getenv("ORDERER_ADDRESS"),
+		OrdererTLSHostname: os.Getenv("ORDERER_TLS_HOSTNAME"),
+		OrdererCAFile:      os.Getenv("ORDERER_CA"),
+		PeerAddresses:      splitList(os.Getenv("PEER_ADDRESSES")),
+		PeerTLSRootCerts:   splitList(os.Getenv("PEER_TLS_ROOTCERT_FILES")),
+	}
+	if cli.OrdererAddress == "" {
+		cli.OrdererAddress = "localhost:7050"
+	}
+	if cli.OrdererTLSHostname == "" {
+		cli.OrdererTLSHostname = "orderer.example.com"
+	}
+	if cli.OrdererCAFile == "" {
+		cli.OrdererCAFile = os.Getenv("PWD") + "/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem"
+	}
+	return cli
+}
+
+// splitList splits a comma-separated environment variable, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// OpenIndex loads the hospital index at GATEWAY_INDEX_PATH, by default the repository's
+// hospital_index_table.csv
+func OpenIndex() (*index.Index, error) {
+	path := os.Getenv("GATEWAY_INDEX_PATH")
+	if path == "" {
+		path = index.DefaultPath
+	}
+	fmt.Printf("Using hospital index %s\n", path)
+	return index.Open(path)
+}
+
+// defaultStorePath is the local store, in the directory the gateway is started from
+const defaultStorePath = "gateway-store.jsonl"
+
+// OpenStore opens the gateway's local store at GATEWAY_STORE_PATH, by default defaultStorePath
+func OpenStore() (*store.Store, error) {
+	path := os.Getenv("GATEWAY_STORE_PATH")
+	if path == "" {
+		path = defaultStorePath
+	}
+	fmt.Printf("Using local store %s\n", path)
+	return store.Open(path)
+}
+
+// resolvePaths makes a relative GATEWAY_INDEX_PATH or GATEWAY_STORE_PATH, and the default store,
+// absolute, so they keep pointing where the gateway was started from once Setup changes directory
+func resolvePaths() {
+	if os.Getenv("GATEWAY_STORE_PATH") == "" {
+		os.Setenv("GATEWAY_STORE_PATH", defaultStorePath)
+	}
+	for _, name := range []string{"GATEWAY_INDEX_PATH", "GATEWAY_STORE_PATH"} {
+		path := os.Getenv(name)
+		if path == "" || filepath.IsAbs(path) {
+			continue
+		}
+		absolute, err := filepath.Abs(path)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+		os.Setenv(name, absolute)
+	}
+}
+
 func Setup(orgID int) {
-    // Change Directory
-    os.Chdir("../test-network")
-    cwd, err := os.Getwd()
-    if err != nil {
-        fmt.Println("Error getting current working directory:", err)
-        return
-    }
-
-    // Modify the PWD env var
-    os.Setenv("PWD", cwd)
-
-    // Modify the PATH env var
-    newPath := fmt.Sprintf("%s/../bin:%s", cwd, os.Getenv("PATH"))
-    os.Setenv("PATH", newPath)
-
-    // Set peer cmd path
+	// Resolve the configured paths before leaving the starting directory
+	resolvePaths()
+
+	// Change Directory
+	os.Chdir("../test-network")
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Println("Error getting current working directory:", err)
+		return
+	}
+
+	// Modify the PWD env var
+	os.Setenv("PWD", cwd)
+
+	// Modify the PATH env var
+	newPath := fmt.Sprintf("%s/../bin:%s", cwd, os.Getenv("PATH"))
+	os.Setenv("PATH", newPath)
+
+	// Set peer cmd path
 	SetPeerCmdPath()
 
 	// Set the path for Org1
@@ -43,35 +209,36 @@ func Setup(orgID int) {
 
 func SetPeerCmdPath() {
 	// Set the required environment variables
-    os.Setenv("PATH", os.Getenv("PWD")+"/../bin:"+os.Getenv("PATH"))
-    os.Setenv("FABRIC_CFG_PATH", os.Getenv("PWD")+"/../config/")
+	os.Setenv("PATH", os.Getenv("PWD")+"/../bin:"+os.Getenv("PATH"))
+	os.Setenv("FABRIC_CFG_PATH", os.Getenv("PWD")+"/../config/")
 
 	fmt.Println("Peer chaincode cmd path set")
 }
 
 // SetPeerPath sets the peer path based on the organization identifier.
 func SetPeerPath(orgID int) {
-    var mspID, peerAddress, tlsCertPath string
-
-    switch orgID {
-    case 1:
-        mspID = "Org1MSP"
-        peerAddress = "localhost:7051"
-        tlsCertPath = os.Getenv("PWD") + "/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt"
-    case 2:
-        mspID = "Org2MSP"
-        peerAddress = "localhost:9051"
-        tlsCertPath = os.Getenv("PWD") + "/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt"
-    default:
-        log.Fatalf("Invalid organization ID: %d", orgID)
-    }
-
-    // Set environment variables
-    os.Setenv("CORE_PEER_TLS_ENABLED", "true")
-    os.Setenv("CORE_PEER_LOCALMSPID", mspID)
-    os.Setenv("CORE_PEER_TLS_ROOTCERT_FILE", tlsCertPath)
-    os.Setenv("CORE_PEER_MSPCONFIGPATH", os.Getenv("PWD")+"/organizations/peerOrganizations/org"+fmt.Sprintf("%d", orgID)+".example.com/users/Admin@org"+fmt.Sprintf("%d", orgID)+".example.com/msp")
-    os.Setenv("CORE_PEER_ADDRESS", peerAddress)
-
-    fmt.Printf("Peer path set for Org%d\n", orgID)
+	var mspID, peerAddress, tlsCertPath string
+
+	switch orgID {
+	case 1:
+		mspID = "Org1MSP"
+		peerAddress = "localhost:7051"
+		tlsCertPath = os.Getenv("PWD") + "/organizations/peerOrganizations/org1.example.com/peers/peer0.org1.example.com/tls/ca.crt"
+	case 2:
+		mspID = "Org2MSP"
+		peerAddress = "localhost:9051"
+		tlsCertPath = os.Getenv("PWD") + "/organizations/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt"
+	default:
+		log.Fatalf("Invalid organization ID: %d", orgID)
+	}
+
+	// Set environment variables. The gateway calls the chaincodes as the Gateway user, which can only
+	// read the policies whose authRoles list <MSPID>.gateway
+	os.Setenv("CORE_PEER_TLS_ENABLED", "true")
+	os.Setenv("CORE_PEER_LOCALMSPID", mspID)
+	os.Setenv("CORE_PEER_TLS_ROOTCERT_FILE", tlsCertPath)
+	os.Setenv("CORE_PEER_MSPCONFIGPATH", os.Getenv("PWD")+"/organizations/peerOrganizations/org"+fmt.Sprintf("%d", orgID)+".example.com/users/Gateway@org"+fmt.Sprintf("%d", orgID)+".example.com/msp")
+	os.Setenv("CORE_PEER_ADDRESS", peerAddress)
+
+	fmt.Printf("Peer path set for Org%d\n", orgID)
 }
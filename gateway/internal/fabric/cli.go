@@ -0,0 +1,225 @@
+package fabric
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+// PeerCLI runs transactions through the peer binary.
+// The peer environment (CORE_PEER_*, FABRIC_CFG_PATH) is inherited from the gateway process.
+type PeerCLI struct {
+	Binary  string
+	Channel string // default channel
+
+	// Orderer and endorsing peers used by Submit
+	OrdererAddress     string
+	OrdererTLSHostname string
+	OrdererCAFile      string
+	PeerAddresses      []string
+	PeerTLSRootCerts   []string
+}
+
+var (
+	cliMessagePattern = regexp.MustCompile(`message:("(?:[^"\\]|\\.)*")`)
+	cliPayloadPattern = regexp.MustCompile(`payload:("(?:[^"\\]|\\.)*")`)
+
+	// --waitForEvent logs the commit, or fails with the validation code of an invalidated transaction
+	cliCommittedPattern   = regexp.MustCompile(`txid \[([0-9a-f]+)\] committed with status \((\w+)\)`)
+	cliInvalidatedPattern = regexp.MustCompile(`transaction invalidated with status \((\w+)\)`)
+	cliTxIDPattern        = regexp.MustCompile(`txid \[([0-9a-f]+)\]`)
+)
+
+// Evaluate runs "peer chaincode query" and returns its standard output
+func (p *PeerCLI) Evaluate(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	input, err := cliInput(function, args)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := []string{"chaincode", "query", "-C", p.channelOrDefault(channel), "-n", chaincode, "-c", input}
+	stdout, err := p.run(ctx, chaincode, function, argv)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(stdout, "\n"), nil
+}
+
+// Submit runs "peer chaincode invoke", waits for the commit event and returns the transaction result
+func (p *PeerCLI) Submit(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	commit, err := p.SubmitTransaction(ctx, &Transaction{Channel: channel, Chaincode: chaincode, Function: function, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return commit.Result, nil
+}
+
+// SubmitTransaction runs "peer chaincode invoke" with the transient data, waits for the commit event
+// and returns the transaction ID and status the peer logged. The CLI does not report block numbers.
+func (p *PeerCLI) SubmitTransaction(ctx context.Context, tx *Transaction) (*Commit, error) {
+	return p.invoke(ctx, tx)
+}
+
+// SubmitAsync is SubmitTransaction: the peer binary only reports the transaction ID once it has committed
+func (p *PeerCLI) SubmitAsync(ctx context.Context, tx *Transaction) (string, []byte, error) {
+	commit, err := p.invoke(ctx, tx)
+	if err != nil {
+		return "", nil, err
+	}
+	if commit.TxID == "" {
+		return "", nil, fmt.Errorf("peer %s %s committed without reporting its transaction ID", tx.Chaincode, tx.Function)
+	}
+	return commit.TxID, commit.Result, nil
+}
+
+// CommitStatus looks the transaction up with qscc's GetTransactionByID. It fails, rather than waits,
+// for a transaction that has not committed yet.
+func (p *PeerCLI) CommitStatus(ctx context.Context, channel string, txID string) (*Commit, error) {
+	channel = p.channelOrDefault(channel)
+	input, err := cliInput("GetTransactionByID", []string{channel, txID})
+	if err != nil {
+		return nil, err
+	}
+
+	// --hex keeps the protobuf response intact on standard output
+	argv := []string{"chaincode", "query", "-C", channel, "-n", "qscc", "-c", input, "--hex"}
+	stdout, err := p.run(ctx, "qscc", "GetTransactionByID", argv)
+	if err != nil {
+		return nil, err
+	}
+	processedBytes, err := hex.DecodeString(string(bytes.TrimSpace(stdout)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the transaction %s: %v", txID, err)
+	}
+	var processed peer.ProcessedTransaction
+	err = proto.Unmarshal(processedBytes, &processed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the transaction %s: %v", txID, err)
+	}
+
+	return &Commit{TxID: txID, Status: peer.TxValidationCode(processed.GetValidationCode()).String()}, nil
+}
+
+// invoke runs "peer chaincode invoke" and waits for the commit event
+func (p *PeerCLI) invoke(ctx context.Context, tx *Transaction) (*Commit, error) {
+	input, err := cliInput(tx.Function, tx.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	argv := []string{"chaincode", "invoke", "-C", p.channelOrDefault(tx.Channel), "-n", tx.Chaincode, "-c", input, "--waitForEvent"}
+	if len(tx.Transient) > 0 {
+		// the peer decodes each value from base64, as encoding/json encodes []byte
+		transient, err := json.Marshal(tx.Transient)
+		if err != nil {
+			return nil, err
+		}
+		argv = append(argv, "--transient", string(transient))
+	}
+	if p.OrdererAddress != "" {
+		argv = append(argv, "-o", p.OrdererAddress, "--tls", "--cafile", p.OrdererCAFile)
+		if p.OrdererTLSHostname != "" {
+			argv = append(argv, "--ordererTLSHostnameOverride", p.OrdererTLSHostname)
+		}
+	}
+	for i, address := range p.PeerAddresses {
+		argv = append(argv, "--peerAddresses", address)
+		if i < len(p.PeerTLSRootCerts) {
+			argv = append(argv, "--tlsRootCertFiles", p.PeerTLSRootCerts[i])
+		}
+	}
+
+	// invoke reports its result and the commit event on stderr as part of log lines
+	cmd := exec.CommandContext(ctx, p.binary(), argv...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if match := cliInvalidatedPattern.FindSubmatch(stderr.Bytes()); match != nil {
+			commitErr := &CommitError{Status: string(match[1])}
+			if txMatch := cliTxIDPattern.FindSubmatch(stderr.Bytes()); txMatch != nil {
+				commitErr.TxID = string(txMatch[1])
+			}
+			return nil, commitErr
+		}
+		return nil, cliError(tx.Chaincode, tx.Function, err, stderr.Bytes())
+	}
+
+	commit := &Commit{Status: StatusValid}
+	if match := cliCommittedPattern.FindSubmatch(stderr.Bytes()); match != nil {
+		commit.TxID, commit.Status = string(match[1]), string(match[2])
+	}
+	if match := cliPayloadPattern.FindSubmatch(stderr.Bytes()); match != nil {
+		payload, err := strconv.Unquote(string(match[1]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse invoke payload: %v", err)
+		}
+		commit.Result = []byte(payload)
+	}
+
+	return commit, nil
+}
+
+func (p *PeerCLI) run(ctx context.Context, chaincode string, function string, argv []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, p.binary(), argv...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.Output()
+	if err != nil {
+		return nil, cliError(chaincode, function, err, stderr.Bytes())
+	}
+	return stdout, nil
+}
+
+func (p *PeerCLI) channelOrDefault(channel string) string {
+	if channel == "" {
+		return p.Channel
+	}
+	return channel
+}
+
+func (p *PeerCLI) binary() string {
+	if p.Binary == "" {
+		return "peer"
+	}
+	return p.Binary
+}
+
+// cliInput builds the -c argument for the peer binary
+func cliInput(function string, args []string) (string, error) {
+	input := struct {
+		Args []string `json:"Args"`
+	}{Args: append([]string{function}, args...)}
+
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	return string(inputJSON), nil
+}
+
+// cliError turns a failed peer command into a ChaincodeError when the peer reported a chaincode message
+func cliError(chaincode string, function string, err error, stderr []byte) error {
+	if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("failed to run peer: %v", err)
+	}
+
+	match := cliMessagePattern.FindSubmatch(stderr)
+	if match != nil {
+		if message, unquoteErr := strconv.Unquote(string(match[1])); unquoteErr == nil {
+			return &ChaincodeError{Chaincode: chaincode, Function: function, Message: message}
+		}
+	}
+
+	return fmt.Errorf("peer %s %s failed: %v: %s", chaincode, function, err, strings.TrimSpace(string(stderr)))
+}
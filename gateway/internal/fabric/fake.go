@@ -0,0 +1,110 @@
+package fabric
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeFunc handles a transaction function call on a FakeLedger
+type FakeFunc func(args []string) ([]byte, error)
+
+// FakeCall records a single call made to a FakeLedger
+type FakeCall struct {
+	Submit    bool
+	Channel   string
+	Chaincode string
+	Function  string
+	Args      []string
+	Transient map[string][]byte
+}
+
+// FakeLedger is an in-memory Ledger for tests and for running the gateway without a network.
+// Handlers are registered per chaincode regardless of channel; Calls records the channel used.
+// Every submitted transaction commits in a block of its own, numbered from 1.
+type FakeLedger struct {
+	mu       sync.Mutex
+	handlers map[string]FakeFunc
+	calls    []FakeCall
+	blocks   uint64
+	commits  map[string]*Commit
+}
+
+// NewFakeLedger returns an empty FakeLedger
+func NewFakeLedger() *FakeLedger {
+	return &FakeLedger{handlers: make(map[string]FakeFunc), commits: make(map[string]*Commit)}
+}
+
+// Handle registers fn as the implementation of function on chaincode
+func (f *FakeLedger) Handle(chaincode string, function string, fn FakeFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[chaincode+"/"+function] = fn
+}
+
+// Calls returns every call made so far
+func (f *FakeLedger) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// Evaluate calls the registered handler
+func (f *FakeLedger) Evaluate(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	return f.call(false, channel, chaincode, function, args, nil)
+}
+
+// Submit calls the registered handler
+func (f *FakeLedger) Submit(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	return f.call(true, channel, chaincode, function, args, nil)
+}
+
+// SubmitTransaction calls the registered handler and commits the result in a new block.
+// A handler returning a CommitError simulates an invalidated transaction.
+func (f *FakeLedger) SubmitTransaction(ctx context.Context, tx *Transaction) (*Commit, error) {
+	return submitAndWait(ctx, f, tx)
+}
+
+// SubmitAsync calls the registered handler and commits the result in a new block at once.
+// A handler returning a CommitError commits the transaction with that status.
+func (f *FakeLedger) SubmitAsync(ctx context.Context, tx *Transaction) (string, []byte, error) {
+	result, err := f.call(true, tx.Channel, tx.Chaincode, tx.Function, tx.Args, tx.Transient)
+	status := StatusValid
+	if commitErr, ok := err.(*CommitError); ok {
+		status, result, err = commitErr.Status, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks++
+	txID := fmt.Sprintf("fake-tx-%d", f.blocks)
+	f.commits[txID] = &Commit{TxID: txID, Status: status, BlockNumber: f.blocks}
+	return txID, result, nil
+}
+
+// CommitStatus returns the commit of a transaction submitted to the FakeLedger
+func (f *FakeLedger) CommitStatus(ctx context.Context, channel string, txID string) (*Commit, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	commit, ok := f.commits[txID]
+	if !ok {
+		return nil, fmt.Errorf("transaction %s was not submitted", txID)
+	}
+	copied := *commit
+	return &copied, nil
+}
+
+func (f *FakeLedger) call(submit bool, channel string, chaincode string, function string, args []string, transient map[string][]byte) ([]byte, error) {
+	f.mu.Lock()
+	fn, ok := f.handlers[chaincode+"/"+function]
+	f.calls = append(f.calls, FakeCall{Submit: submit, Channel: channel, Chaincode: chaincode, Function: function, Args: args, Transient: transient})
+	f.mu.Unlock()
+
+	if !ok {
+		return nil, &ChaincodeError{Chaincode: chaincode, Function: function, Message: fmt.Sprintf("function %s not found", function)}
+	}
+	return fn(args)
+}
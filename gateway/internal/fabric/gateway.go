@@ -0,0 +1,243 @@
+package fabric
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// GatewayConfig describes how to reach a peer's Fabric Gateway service and who to sign as
+type GatewayConfig struct {
+	Endpoint      string // peer address, e.g. localhost:7051
+	TLSCertPath   string // peer TLS CA certificate
+	TLSServerName string // overrides the TLS host name, e.g. peer0.org1.example.com
+	MSPID         string
+	CertPath      string // signing certificate file, or a directory holding one
+	KeyPath       string // private key file, or a directory holding one
+	Channel       string // default channel
+	Timeout       time.Duration
+}
+
+// GatewayLedger talks to the Fabric Gateway service of a peer through the Fabric Gateway client SDK
+type GatewayLedger struct {
+	conn    *grpc.ClientConn
+	gateway *client.Gateway
+	id      *identity.X509Identity
+	channel string
+	timeout time.Duration
+}
+
+// NewGatewayLedger connects to the peer gateway described by cfg
+func NewGatewayLedger(cfg GatewayConfig) (*GatewayLedger, error) {
+	certPEM, err := readPEMFile(cfg.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+	cert, err := identity.CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	id, err := identity.NewX509Identity(cfg.MSPID, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := readPEMFile(cfg.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %v", err)
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsPEM, err := os.ReadFile(cfg.TLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(tlsPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCertPath)
+	}
+	transport := credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: cfg.TLSServerName})
+
+	conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to gateway %s: %v", cfg.Endpoint, err)
+	}
+
+	ledger, err := newGatewayLedger(conn, id, sign, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to gateway %s: %v", cfg.Endpoint, err)
+	}
+	return ledger, nil
+}
+
+// newGatewayLedger connects the gateway client over conn, signing as id with sign
+func newGatewayLedger(conn *grpc.ClientConn, id *identity.X509Identity, sign identity.Sign, cfg GatewayConfig) (*GatewayLedger, error) {
+	gw, err := client.Connect(id, client.WithSign(sign), client.WithClientConnection(conn))
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &GatewayLedger{
+		conn:    conn,
+		gateway: gw,
+		id:      id,
+		channel: cfg.Channel,
+		timeout: timeout,
+	}, nil
+}
+
+// Close closes the gateway and its gRPC connection
+func (g *GatewayLedger) Close() error {
+	g.gateway.Close()
+	return g.conn.Close()
+}
+
+// Evaluate runs the transaction function on a peer chosen by the gateway
+func (g *GatewayLedger) Evaluate(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	contract := g.gateway.GetNetwork(g.channelOrDefault(channel)).GetContract(chaincode)
+	result, err := contract.EvaluateWithContext(ctx, function, client.WithArguments(args...))
+	if err != nil {
+		return nil, gatewayError(chaincode, function, err)
+	}
+
+	return result, nil
+}
+
+// Submit endorses, submits and waits for the commit of a transaction, returning its result
+func (g *GatewayLedger) Submit(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error) {
+	commit, err := g.SubmitTransaction(ctx, &Transaction{Channel: channel, Chaincode: chaincode, Function: function, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return commit.Result, nil
+}
+
+// SubmitTransaction endorses the proposal with its transient data, submits the transaction and
+// waits for its commit status
+func (g *GatewayLedger) SubmitTransaction(ctx context.Context, tx *Transaction) (*Commit, error) {
+	return submitAndWait(ctx, g, tx)
+}
+
+// SubmitAsync endorses the proposal with its transient data and submits the endorsed transaction
+// to the orderer
+func (g *GatewayLedger) SubmitAsync(ctx context.Context, tx *Transaction) (string, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	contract := g.gateway.GetNetwork(g.channelOrDefault(tx.Channel)).GetContract(tx.Chaincode)
+	proposal, err := contract.NewProposal(tx.Function, client.WithArguments(tx.Args...), client.WithTransient(tx.Transient))
+	if err != nil {
+		return "", nil, err
+	}
+	transaction, err := proposal.EndorseWithContext(ctx)
+	if err != nil {
+		return "", nil, gatewayError(tx.Chaincode, tx.Function, err)
+	}
+	_, err = transaction.SubmitWithContext(ctx)
+	if err != nil {
+		return "", nil, gatewayError(tx.Chaincode, tx.Function, err)
+	}
+
+	return transaction.TransactionID(), transaction.Result(), nil
+}
+
+// CommitStatus asks the gateway's CommitStatus service, which waits for the transaction to commit.
+// The request is rebuilt from the transaction ID, so it works for transactions submitted before a restart.
+func (g *GatewayLedger) CommitStatus(ctx context.Context, channel string, txID string) (*Commit, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: g.id.MspID(), IdBytes: g.id.Credentials()})
+	if err != nil {
+		return nil, err
+	}
+	request, err := proto.Marshal(&gateway.CommitStatusRequest{TransactionId: txID, ChannelId: g.channelOrDefault(channel), Identity: creator})
+	if err != nil {
+		return nil, err
+	}
+	signedRequest, err := proto.Marshal(&gateway.SignedCommitStatusRequest{Request: request})
+	if err != nil {
+		return nil, err
+	}
+	commit, err := g.gateway.NewCommit(signedRequest)
+	if err != nil {
+		return nil, err
+	}
+	commitStatus, err := commit.StatusWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the commit status of %s: %v", txID, err)
+	}
+
+	return &Commit{TxID: txID, Status: commitStatus.Code.String(), BlockNumber: commitStatus.BlockNumber}, nil
+}
+
+func (g *GatewayLedger) channelOrDefault(channel string) string {
+	if channel == "" {
+		return g.channel
+	}
+	return channel
+}
+
+// gatewayError unwraps the chaincode message carried in the gateway's error details
+func gatewayError(chaincode string, function string, err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, detail := range st.Details() {
+		if errorDetail, ok := detail.(*gateway.ErrorDetail); ok && errorDetail.GetMessage() != "" {
+			return &ChaincodeError{Chaincode: chaincode, Function: function, Message: errorDetail.GetMessage()}
+		}
+	}
+	return fmt.Errorf("gateway %s %s failed: %s", chaincode, function, st.Message())
+}
+
+// readPEMFile reads path, or the first file in path when it is a directory (as in an MSP keystore)
+func readPEMFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				return os.ReadFile(filepath.Join(path, entry.Name()))
+			}
+		}
+		return nil, fmt.Errorf("no files found in %s", path)
+	}
+	return os.ReadFile(path)
+}
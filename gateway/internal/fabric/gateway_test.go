@@ -0,0 +1,300 @@
+package fabric
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/msp"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// invocation is a chaincode call decoded from a signed proposal
+type invocation struct {
+	TxID      string
+	Channel   string
+	Chaincode string
+	Args      []string
+	Transient map[string][]byte
+	Creator   *msp.SerializedIdentity
+}
+
+// fakeGateway is a peer's Gateway service that checks request signatures against key and answers
+// with result, or with a chaincode error when chaincodeErr is set
+type fakeGateway struct {
+	gateway.UnimplementedGatewayServer
+	t            *testing.T
+	key          *ecdsa.PublicKey
+	result       []byte
+	chaincodeErr string
+	commitCode   peer.TxValidationCode
+	invocations  []invocation
+	submitted    []string
+}
+
+func (f *fakeGateway) Evaluate(ctx context.Context, request *gateway.EvaluateRequest) (*gateway.EvaluateResponse, error) {
+	call := f.decodeProposal(request.GetProposedTransaction())
+	if call.TxID != request.GetTransactionId() || call.Channel != request.GetChannelId() {
+		f.t.Errorf("request for %s on %s carries proposal %s on %s", request.GetTransactionId(), request.GetChannelId(), call.TxID, call.Channel)
+	}
+	if f.chaincodeErr != "" {
+		return nil, f.endorsementError()
+	}
+	return &gateway.EvaluateResponse{Result: &peer.Response{Status: 200, Payload: f.result}}, nil
+}
+
+func (f *fakeGateway) Endorse(ctx context.Context, request *gateway.EndorseRequest) (*gateway.EndorseResponse, error) {
+	call := f.decodeProposal(request.GetProposedTransaction())
+	if f.chaincodeErr != "" {
+		return nil, f.endorsementError()
+	}
+
+	channelHeader, _ := proto.Marshal(&common.ChannelHeader{ChannelId: call.Channel, TxId: call.TxID})
+	chaincodeAction, _ := proto.Marshal(&peer.ChaincodeAction{Response: &peer.Response{Status: 200, Payload: f.result}})
+	responsePayload, _ := proto.Marshal(&peer.ProposalResponsePayload{Extension: chaincodeAction})
+	actionPayload, _ := proto.Marshal(&peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload}})
+	transaction, _ := proto.Marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}})
+	payload, _ := proto.Marshal(&common.Payload{Header: &common.Header{ChannelHeader: channelHeader}, Data: transaction})
+	return &gateway.EndorseResponse{PreparedTransaction: &common.Envelope{Payload: payload}}, nil
+}
+
+func (f *fakeGateway) Submit(ctx context.Context, request *gateway.SubmitRequest) (*gateway.SubmitResponse, error) {
+	envelope := request.GetPreparedTransaction()
+	f.verify("transaction", envelope.GetPayload(), envelope.GetSignature())
+	f.submitted = append(f.submitted, request.GetTransactionId())
+	return &gateway.SubmitResponse{}, nil
+}
+
+func (f *fakeGateway) CommitStatus(ctx context.Context, signed *gateway.SignedCommitStatusRequest) (*gateway.CommitStatusResponse, error) {
+	f.verify("commit status request", signed.GetRequest(), signed.GetSignature())
+	var request gateway.CommitStatusRequest
+	if err := proto.Unmarshal(signed.GetRequest(), &request); err != nil {
+		return nil, err
+	}
+	if len(f.submitted) == 0 || request.GetTransactionId() != f.submitted[len(f.submitted)-1] {
+		return nil, status.Errorf(codes.NotFound, "transaction %s was not submitted", request.GetTransactionId())
+	}
+	return &gateway.CommitStatusResponse{Result: f.commitCode, BlockNumber: 7}, nil
+}
+
+func (f *fakeGateway) endorsementError() error {
+	st, _ := status.New(codes.Aborted, "failed to endorse transaction").WithDetails(&gateway.ErrorDetail{
+		Address: "peer0.org1.example.com:7051",
+		MspId:   "Org1MSP",
+		Message: f.chaincodeErr,
+	})
+	return st.Err()
+}
+
+func (f *fakeGateway) decodeProposal(signed *peer.SignedProposal) invocation {
+	f.verify("proposal", signed.GetProposalBytes(), signed.GetSignature())
+
+	var proposal peer.Proposal
+	var header common.Header
+	var channelHeader common.ChannelHeader
+	var signatureHeader common.SignatureHeader
+	var payload peer.ChaincodeProposalPayload
+	var spec peer.ChaincodeInvocationSpec
+	var creator msp.SerializedIdentity
+	// each step decodes a field of the message decoded by the step before
+	for _, step := range []struct {
+		data func() []byte
+		into proto.Message
+	}{
+		{signed.GetProposalBytes, &proposal},
+		{proposal.GetHeader, &header},
+		{header.GetChannelHeader, &channelHeader},
+		{header.GetSignatureHeader, &signatureHeader},
+		{signatureHeader.GetCreator, &creator},
+		{proposal.GetPayload, &payload},
+		{payload.GetInput, &spec},
+	} {
+		if err := proto.Unmarshal(step.data(), step.into); err != nil {
+			f.t.Errorf("failed to decode proposal: %v", err)
+		}
+	}
+
+	call := invocation{
+		TxID:      channelHeader.GetTxId(),
+		Channel:   channelHeader.GetChannelId(),
+		Chaincode: spec.GetChaincodeSpec().GetChaincodeId().GetName(),
+		Transient: payload.GetTransientMap(),
+		Creator:   &creator,
+	}
+	for _, arg := range spec.GetChaincodeSpec().GetInput().GetArgs() {
+		call.Args = append(call.Args, string(arg))
+	}
+	f.invocations = append(f.invocations, call)
+	return call
+}
+
+func (f *fakeGateway) verify(what string, message []byte, signature []byte) {
+	digest := sha256.Sum256(message)
+	if !ecdsa.VerifyASN1(f.key, digest[:], signature) {
+		f.t.Errorf("the %s is not signed by the client identity", what)
+	}
+}
+
+// newTestGatewayLedger serves a fakeGateway in memory and connects a GatewayLedger to it as Org1MSP
+func newTestGatewayLedger(t *testing.T) (*GatewayLedger, *fakeGateway) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := identity.NewX509Identity("Org1MSP", cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeGateway{t: t, key: &key.PublicKey, result: []byte(`{"ID":"pc1"}`), commitCode: peer.TxValidationCode_VALID}
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	gateway.RegisterGatewayServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := newGatewayLedger(conn, id, sign, GatewayConfig{Channel: "mychannel", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ledger.Close() })
+	return ledger, fake
+}
+
+func TestGatewayLedgerEvaluate(t *testing.T) {
+	ledger, fake := newTestGatewayLedger(t)
+
+	result, err := ledger.Evaluate(context.Background(), "", "regionalCC1", "ReadAsset", "pc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"ID":"pc1"}` {
+		t.Fatalf("unexpected result %s", result)
+	}
+
+	call := fake.invocations[0]
+	if call.Channel != "mychannel" || call.Chaincode != "regionalCC1" || len(call.Args) != 2 || call.Args[0] != "ReadAsset" || call.Args[1] != "pc1" {
+		t.Fatalf("unexpected invocation %+v", call)
+	}
+	if call.Creator.GetMspid() != "Org1MSP" {
+		t.Fatalf("proposal created by %s, not Org1MSP", call.Creator.GetMspid())
+	}
+}
+
+func TestGatewayLedgerChaincodeError(t *testing.T) {
+	ledger, fake := newTestGatewayLedger(t)
+	fake.chaincodeErr = "chaincode response 500, the asset pc9 does not exist"
+
+	_, err := ledger.Evaluate(context.Background(), "region2channel", "regionalCC2", "ReadAsset", "pc9")
+	var chaincodeErr *ChaincodeError
+	if !errors.As(err, &chaincodeErr) {
+		t.Fatalf("expected a ChaincodeError, got %v", err)
+	}
+	if chaincodeErr.Chaincode != "regionalCC2" || chaincodeErr.Function != "ReadAsset" || chaincodeErr.Message != fake.chaincodeErr {
+		t.Fatalf("unexpected error %+v", chaincodeErr)
+	}
+	if fake.invocations[0].Channel != "region2channel" {
+		t.Fatalf("evaluated on %s, not region2channel", fake.invocations[0].Channel)
+	}
+
+	_, err = ledger.SubmitTransaction(context.Background(), &Transaction{Chaincode: "regionalCC2", Function: "DeleteAsset", Args: []string{"pc9"}})
+	if !errors.As(err, &chaincodeErr) || chaincodeErr.Function != "DeleteAsset" {
+		t.Fatalf("expected a ChaincodeError from DeleteAsset, got %v", err)
+	}
+	if len(fake.submitted) != 0 {
+		t.Fatalf("a transaction that failed to endorse was submitted")
+	}
+}
+
+func TestGatewayLedgerSubmitTransaction(t *testing.T) {
+	ledger, fake := newTestGatewayLedger(t)
+
+	transient := map[string][]byte{"asset_properties": []byte(`{"owner":"PATIENT 1"}`)}
+	commit, err := ledger.SubmitTransaction(context.Background(), &Transaction{
+		Chaincode: "regionalCC1",
+		Function:  "CreateAsset",
+		Args:      []string{"region1:HP1:pc1", `["Org1MSP.DoctorReg1"]`, "R"},
+		Transient: transient,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := fake.invocations[0]
+	if commit.TxID != call.TxID || commit.Status != StatusValid || commit.BlockNumber != 7 || string(commit.Result) != `{"ID":"pc1"}` {
+		t.Fatalf("unexpected commit %+v", commit)
+	}
+	if len(fake.submitted) != 1 || fake.submitted[0] != call.TxID {
+		t.Fatalf("submitted %v, expected %s", fake.submitted, call.TxID)
+	}
+	if string(call.Transient["asset_properties"]) != string(transient["asset_properties"]) {
+		t.Fatalf("transient data not passed: %v", call.Transient)
+	}
+}
+
+func TestGatewayLedgerCommitError(t *testing.T) {
+	ledger, fake := newTestGatewayLedger(t)
+	fake.commitCode = peer.TxValidationCode_MVCC_READ_CONFLICT
+
+	_, err := ledger.SubmitTransaction(context.Background(), &Transaction{Chaincode: "regionalCC1", Function: "DeleteAsset", Args: []string{"pc1"}})
+	var commitErr *CommitError
+	if !errors.As(err, &commitErr) {
+		t.Fatalf("expected a CommitError, got %v", err)
+	}
+	if commitErr.Status != "MVCC_READ_CONFLICT" || commitErr.TxID != fake.invocations[0].TxID {
+		t.Fatalf("unexpected error %+v", commitErr)
+	}
+
+	// the status of a transaction can be asked again from its ID alone, e.g. after a restart
+	commit, err := ledger.CommitStatus(context.Background(), "", commitErr.TxID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Status != "MVCC_READ_CONFLICT" || commit.BlockNumber != 7 {
+		t.Fatalf("unexpected commit %+v", commit)
+	}
+}
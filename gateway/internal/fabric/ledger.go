@@ -0,0 +1,91 @@
+package fabric
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ledger submits and evaluates chaincode transactions on the Fabric network.
+// An empty channel means the ledger's default channel.
+type Ledger interface {
+	// Evaluate runs a transaction function on a peer and returns its result without updating the ledger
+	Evaluate(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error)
+	// Submit endorses, orders and commits a transaction and returns its result
+	Submit(ctx context.Context, channel string, chaincode string, function string, args ...string) ([]byte, error)
+	// SubmitTransaction is Submit for a Transaction, which may carry transient data, and reports the
+	// transaction's ID and commit status. A transaction that was ordered but invalidated returns a CommitError.
+	SubmitTransaction(ctx context.Context, tx *Transaction) (*Commit, error)
+	// SubmitAsync endorses tx and sends it for ordering without waiting for it to commit, returning
+	// its ID and result. Backends that cannot return earlier wait for the commit.
+	SubmitAsync(ctx context.Context, tx *Transaction) (txID string, result []byte, err error)
+	// CommitStatus waits for a submitted transaction to commit and returns its status, whether or not
+	// it is valid
+	CommitStatus(ctx context.Context, channel string, txID string) (*Commit, error)
+}
+
+// submitAndWait implements SubmitTransaction with SubmitAsync and CommitStatus
+func submitAndWait(ctx context.Context, ledger Ledger, tx *Transaction) (*Commit, error) {
+	txID, result, err := ledger.SubmitAsync(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := ledger.CommitStatus(ctx, tx.Channel, txID)
+	if err != nil {
+		return nil, err
+	}
+	if commit.Status != StatusValid {
+		return nil, &CommitError{TxID: txID, Status: commit.Status}
+	}
+
+	commit.Result = result
+	return commit, nil
+}
+
+// Transaction is a chaincode call to submit. Transient data reaches the chaincode on the endorsing
+// peers but is not recorded in the block; the regional chaincode takes private fields that way.
+type Transaction struct {
+	Channel   string
+	Chaincode string
+	Function  string
+	Args      []string
+	Transient map[string][]byte
+}
+
+// StatusValid is the commit status of a transaction that updated the ledger
+const StatusValid = "VALID"
+
+// Commit is the outcome of a committed transaction. Status is the peer's validation code, e.g.
+// "VALID" or "MVCC_READ_CONFLICT"; BlockNumber is 0 when the backend does not report it. Result is
+// only set by SubmitTransaction.
+type Commit struct {
+	TxID        string
+	Status      string
+	BlockNumber uint64
+	Result      []byte
+}
+
+// ChaincodeError is returned when the chaincode itself rejected the transaction,
+// as opposed to a failure to reach the network.
+type ChaincodeError struct {
+	Chaincode string
+	Function  string
+	Message   string
+}
+
+func (e *ChaincodeError) Error() string {
+	return fmt.Sprintf("chaincode %s %s failed: %s", e.Chaincode, e.Function, e.Message)
+}
+
+// CommitError is returned when a transaction was endorsed and ordered but the peers invalidated it,
+// so it did not update the ledger. TxID is empty when the backend does not report it.
+type CommitError struct {
+	TxID   string
+	Status string
+}
+
+func (e *CommitError) Error() string {
+	if e.TxID == "" {
+		return fmt.Sprintf("transaction failed to commit with status %s", e.Status)
+	}
+	return fmt.Sprintf("transaction %s failed to commit with status %s", e.TxID, e.Status)
+}
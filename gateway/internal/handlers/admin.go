@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gateway/internal/index"
+)
+
+// indexWatchInterval is how often the hospital index file is checked for changes
+const indexWatchInterval = 2 * time.Second
+
+// Triggers of an IndexReport
+const (
+	triggerWatch  = "watch"  // the CSV changed on disk
+	triggerReload = "reload" // POST /admin/index/reload
+	triggerSync   = "sync"   // a sync from globalcc, periodic or POST /admin/index/sync
+)
+
+// IndexReport is the outcome of a reload or sync of the hospital index. Changes are what it changed
+// in the index. Drift is set when the index is compared with globalcc's registry: after every
+// reload and sync once periodic sync is configured, and on every sync.
+type IndexReport struct {
+	Trigger   string         `json:"trigger"`
+	At        string         `json:"at"`
+	Hospitals int            `json:"hospitals"`
+	Changes   []index.Change `json:"changes"`
+	Drift     *IndexDrift    `json:"drift,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// IndexDrift lists the hospitals on which the index and globalcc's registry disagree: From is the
+// index's entry and To the registry's, nil when a side does not route the hospital. Only active
+// hospitals of the registry count. For a sync it is the drift found before the registry was merged.
+type IndexDrift struct {
+	Source      string         `json:"source"`
+	Differences []index.Change `json:"differences"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// IndexState is the body of GET /admin/index
+type IndexState struct {
+	Path       string       `json:"path"`
+	LoadedAt   string       `json:"loadedAt"`
+	Hospitals  []Hospital   `json:"hospitals"`
+	LastReport *IndexReport `json:"lastReport,omitempty"`
+}
+
+// IndexEntry is the body of PUT /admin/index/hospitals/{hospitalID}
+type IndexEntry struct {
+	Chaincode string `json:"chaincode"`
+	Channel   string `json:"channel,omitempty"`
+}
+
+// IndexAdminConfig configures the hospital index administration. Token is the bearer token of the
+// admin endpoints; without one they only answer requests from localhost. SyncInterval, when not
+// zero, merges globalcc's registry into the index periodically and reports drift on every reload.
+type IndexAdminConfig struct {
+	Token        string
+	SyncInterval time.Duration
+}
+
+// IndexAdmin reloads, edits and syncs the hospital index and keeps the report of the last change
+type IndexAdmin struct {
+	hospitals *index.Index
+	registry  *globalRegistry
+	config    IndexAdminConfig
+	mu        sync.Mutex
+	last      *IndexReport
+}
+
+// NewIndexAdmin returns the administration of hospitals, syncing from the globalcc of routing
+func NewIndexAdmin(hospitals *index.Index, routing *Routing, config IndexAdminConfig) *IndexAdmin {
+	return &IndexAdmin{hospitals: hospitals, registry: routing.registry, config: config}
+}
+
+// Start watches the index file and, when configured, syncs from globalcc periodically, until ctx is done
+func (a *IndexAdmin) Start(ctx context.Context) {
+	go a.hospitals.Watch(ctx, indexWatchInterval, func(changes []index.Change, err error) {
+		a.record(ctx, triggerWatch, changes, err)
+	})
+
+	if a.config.SyncInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(a.config.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.sync(ctx)
+			}
+		}
+	}()
+}
+
+// reload reads the index file again and reports the outcome
+func (a *IndexAdmin) reload(ctx context.Context) *IndexReport {
+	changes, err := a.hospitals.Reload()
+	return a.record(ctx, triggerReload, changes, err)
+}
+
+// sync merges the active hospitals of globalcc's registry into the index, reporting the drift found
+// first. Hospitals only the index routes are kept.
+func (a *IndexAdmin) sync(ctx context.Context) (*IndexReport, error) {
+	registered, err := a.registered(ctx)
+	if err != nil {
+		fmt.Printf("Failed to sync the hospital index from globalcc: %v\n", err)
+		return nil, err
+	}
+	drift := &IndexDrift{Source: StrategyGlobalCC, Differences: index.Diff(a.hospitals.Entries(), registered)}
+
+	changes, err := a.hospitals.Merge(registered)
+	report := a.report(triggerSync, changes, err)
+	report.Drift = drift
+	a.keep(report)
+	return report, nil
+}
+
+// record reports a reload, comparing the index with globalcc when periodic sync is configured
+func (a *IndexAdmin) record(ctx context.Context, trigger string, changes []index.Change, err error) *IndexReport {
+	report := a.report(trigger, changes, err)
+	if err == nil && a.config.SyncInterval > 0 {
+		report.Drift = &IndexDrift{Source: StrategyGlobalCC, Differences: []index.Change{}}
+		registered, err := a.registered(ctx)
+		if err != nil {
+			report.Drift.Error = err.Error()
+		} else {
+			report.Drift.Differences = index.Diff(a.hospitals.Entries(), registered)
+		}
+	}
+	a.keep(report)
+	return report
+}
+
+func (a *IndexAdmin) report(trigger string, changes []index.Change, err error) *IndexReport {
+	if changes == nil {
+		changes = []index.Change{}
+	}
+	report := &IndexReport{
+		Trigger:   trigger,
+		At:        time.Now().UTC().Format(time.RFC3339),
+		Hospitals: len(a.hospitals.Entries()),
+		Changes:   changes,
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// keep stores report as the last one and logs it when something changed, failed or drifted
+func (a *IndexAdmin) keep(report *IndexReport) {
+	a.mu.Lock()
+	a.last = report
+	a.mu.Unlock()
+
+	if report.Error != "" {
+		fmt.Printf("Hospital index %s failed, keeping the loaded index: %s\n", report.Trigger, report.Error)
+		return
+	}
+	if len(report.Changes) > 0 {
+		fmt.Printf("Hospital index %s: %d hospitals changed, %d in the index\n", report.Trigger, len(report.Changes), report.Hospitals)
+	}
+	if report.Drift != nil && len(report.Drift.Differences) > 0 {
+		fmt.Printf("Hospital index drift from %s: %d hospitals differ\n", report.Drift.Source, len(report.Drift.Differences))
+		for _, change := range report.Drift.Differences {
+			fmt.Printf("  %s: index %s, %s %s\n", change.HospitalID, describeEntry(change.From), report.Drift.Source, describeEntry(change.To))
+		}
+	}
+}
+
+func (a *IndexAdmin) lastReport() *IndexReport {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// registered returns the active hospitals of globalcc's registry as index entries
+func (a *IndexAdmin) registered(ctx context.Context) ([]index.Entry, error) {
+	snapshot, err := a.registry.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var entries []index.Entry
+	for _, hospital := range snapshot.hospitals() {
+		entries = append(entries, index.Entry{HospitalID: hospital.HospitalID, Chaincode: hospital.Chaincode, Channel: hospital.Channel})
+	}
+	return entries, nil
+}
+
+func describeEntry(entry *index.Entry) string {
+	if entry == nil {
+		return "missing"
+	}
+	return entry.Chaincode + "@" + entry.Channel
+}
+
+// AdminRoutes returns the /admin endpoints of the hospital index
+func AdminRoutes(admin *IndexAdmin) []Route {
+	return []Route{
+		{
+			Method:   http.MethodGet,
+			Pattern:  "/admin/index",
+			Summary:  "Get the hospital index and the report of its last reload or sync",
+			Response: IndexState{},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				writeJSON(w, http.StatusOK, IndexState{
+					Path:       admin.hospitals.Path(),
+					LoadedAt:   admin.hospitals.LoadedAt().UTC().Format(time.RFC3339),
+					Hospitals:  hospitalList(admin.hospitals.Entries()),
+					LastReport: admin.lastReport(),
+				})
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/admin/index/reload",
+			Summary:  "Reload the hospital index from its CSV file",
+			Response: IndexReport{},
+			Errors:   []int{http.StatusUnprocessableEntity},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				report := admin.reload(r.Context())
+				if report.Error != "" {
+					writeAPIError(w, r, http.StatusUnprocessableEntity, codeInvalidIndex, "The hospital index was not reloaded: "+report.Error, report)
+					return
+				}
+				writeJSON(w, http.StatusOK, report)
+			},
+		},
+		{
+			Method:   http.MethodPost,
+			Pattern:  "/admin/index/sync",
+			Summary:  "Merge the active hospitals of globalcc's registry into the hospital index",
+			Response: IndexReport{},
+			Errors:   []int{http.StatusUnprocessableEntity, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				report, err := admin.sync(r.Context())
+				if err != nil {
+					writeRouteError(w, r, "", err)
+					return
+				}
+				if report.Error != "" {
+					writeAPIError(w, r, http.StatusInternalServerError, codeInvalidIndex, "The hospital index was not synced: "+report.Error, report)
+					return
+				}
+				writeJSON(w, http.StatusOK, report)
+			},
+		},
+		{
+			Method:   http.MethodGet,
+			Pattern:  "/admin/index/hospitals/{hospitalID}",
+			Summary:  "Get a hospital's entry in the index",
+			Response: Hospital{},
+			Errors:   []int{http.StatusNotFound},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				hospitalID := r.PathValue("hospitalID")
+				entry, ok := admin.hospitals.Lookup(hospitalID)
+				if !ok {
+					writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+					return
+				}
+				writeJSON(w, http.StatusOK, Hospital{HospitalID: entry.HospitalID, Chaincode: entry.Chaincode, Channel: entry.Channel})
+			},
+		},
+		{
+			Method:   http.MethodPut,
+			Pattern:  "/admin/index/hospitals/{hospitalID}",
+			Summary:  "Add or replace a hospital's entry and write the index back to its CSV file",
+			Request:  IndexEntry{},
+			Response: Hospital{},
+			Errors:   []int{http.StatusInternalServerError},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				var body IndexEntry
+				if !decodeBody(w, r, &body) {
+					return
+				}
+				entry := index.Entry{HospitalID: r.PathValue("hospitalID"), Chaincode: body.Chaincode, Channel: body.Channel}
+				if err := entry.Validate(); err != nil {
+					writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+					return
+				}
+				created, err := admin.hospitals.Put(entry)
+				if err != nil {
+					writeAPIError(w, r, http.StatusInternalServerError, codeInvalidIndex, err.Error(), nil)
+					return
+				}
+				status := http.StatusOK
+				if created {
+					status = http.StatusCreated
+				}
+				writeJSON(w, status, Hospital{HospitalID: entry.HospitalID, Chaincode: entry.Chaincode, Channel: entry.Channel})
+			},
+		},
+		{
+			Method:  http.MethodDelete,
+			Pattern: "/admin/index/hospitals/{hospitalID}",
+			Summary: "Remove a hospital from the index and write it back to its CSV file",
+			Status:  http.StatusNoContent,
+			Errors:  []int{http.StatusNotFound, http.StatusInternalServerError},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				hospitalID := r.PathValue("hospitalID")
+				deleted, err := admin.hospitals.Delete(hospitalID)
+				if err != nil {
+					writeAPIError(w, r, http.StatusInternalServerError, codeInvalidIndex, err.Error(), nil)
+					return
+				}
+				if !deleted {
+					writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+			},
+		},
+	}
+}
+
+// RegisterAdmin registers the /admin endpoints on mux, behind the admin token
+func RegisterAdmin(mux *http.ServeMux, admin *IndexAdmin) {
+	registerRoutes(mux, "/admin/", AdminRoutes(admin), func(next http.Handler) http.Handler {
+		return WithRequestID(requireAdmin(admin.config.Token, next))
+	})
+}
+
+// requireAdmin lets through requests bearing token, or from localhost when there is no token
+func requireAdmin(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+				writeAPIError(w, r, http.StatusForbidden, codeForbidden, "The admin API only answers localhost unless an admin token is configured", nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeAPIError(w, r, http.StatusUnauthorized, codeUnauthorized, "The admin API requires the admin bearer token", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+)
+
+// newTestAdmin returns a mux serving the /admin endpoints over the test index, with the admin
+// configured by config and globalcc served by ledger
+func newTestAdmin(t *testing.T, ledger *fabric.FakeLedger, config IndexAdminConfig) (*http.ServeMux, *index.Index) {
+	t.Helper()
+
+	_, hospitals := newTestAPI(t, ledger)
+	routing, err := NewRouting(ledger, hospitals, RoutingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterAdmin(mux, NewIndexAdmin(hospitals, routing, config))
+	return mux, hospitals
+}
+
+// serveAdmin sends an admin request from localhost with an optional bearer token
+func serveAdmin(mux http.Handler, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.RemoteAddr = "127.0.0.1:50000"
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, request)
+	return response
+}
+
+// reportOf decodes the IndexReport of a reload or sync
+func reportOf(t *testing.T, response *httptest.ResponseRecorder) IndexReport {
+	t.Helper()
+
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", response.Code, response.Body)
+	}
+	var report IndexReport
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// changedIDs returns the hospital IDs of changes
+func changedIDs(changes []index.Change) string {
+	var ids []string
+	for _, change := range changes {
+		ids = append(ids, change.HospitalID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestAdminToken(t *testing.T) {
+	mux, _ := newTestAdmin(t, fabric.NewFakeLedger(), IndexAdminConfig{Token: "s3cret"})
+	for _, test := range []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"a wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"another scheme", "Basic s3cret", http.StatusUnauthorized},
+		{"the token", "Bearer s3cret", http.StatusOK},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/admin/index", nil)
+		if test.header != "" {
+			request.Header.Set("Authorization", test.header)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		if response.Code != test.status {
+			t.Errorf("%s: expected %d, got %d %s", test.name, test.status, response.Code, response.Body)
+		}
+		if test.status == http.StatusUnauthorized {
+			if apiErr := apiErrorOf(t, response); apiErr.Code != codeUnauthorized || response.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("%s: expected %s with a challenge, got %+v", test.name, codeUnauthorized, apiErr)
+			}
+		}
+	}
+
+	// without a token only localhost is answered
+	mux, _ = newTestAdmin(t, fabric.NewFakeLedger(), IndexAdminConfig{})
+	response := serve(mux, http.MethodGet, "/admin/index", "")
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusForbidden || apiErr.Code != codeForbidden {
+		t.Errorf("expected 403 %s from another host, got %d %+v", codeForbidden, response.Code, apiErr)
+	}
+	if response := serveAdmin(mux, http.MethodGet, "/admin/index", "", ""); response.Code != http.StatusOK {
+		t.Errorf("expected 200 from localhost, got %d %s", response.Code, response.Body)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	mux, hospitals := newTestAdmin(t, fabric.NewFakeLedger(), IndexAdminConfig{})
+
+	err := os.WriteFile(hospitals.Path(), []byte("hospitalID,chaincodeName,channel\nHP1,regionalCC1,\nHP3,regionalCC3,\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := reportOf(t, serveAdmin(mux, http.MethodPost, "/admin/index/reload", "", ""))
+	if report.Trigger != triggerReload || report.Hospitals != 2 || changedIDs(report.Changes) != "HP2,HP3" || report.Drift != nil {
+		t.Fatalf("unexpected reload report %+v", report)
+	}
+
+	// an invalid file is refused and the loaded index kept
+	if err := os.WriteFile(hospitals.Path(), []byte("HP1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	response := serveAdmin(mux, http.MethodPost, "/admin/index/reload", "", "")
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusUnprocessableEntity || apiErr.Code != codeInvalidIndex {
+		t.Fatalf("expected 422 %s, got %d %+v", codeInvalidIndex, response.Code, apiErr)
+	}
+	if _, ok := hospitals.Lookup("HP3"); !ok {
+		t.Errorf("the failed reload dropped the loaded index")
+	}
+
+	var state IndexState
+	response = serveAdmin(mux, http.MethodGet, "/admin/index", "", "")
+	if err := json.Unmarshal(response.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Hospitals) != 2 || state.LastReport == nil || state.LastReport.Error == "" {
+		t.Errorf("expected the failed reload as the last report, got %+v", state)
+	}
+}
+
+func TestAdminDrift(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	registry := newFakeRegistry(ledger, "HP1", "HP7")
+	mux, hospitals := newTestAdmin(t, ledger, IndexAdminConfig{SyncInterval: time.Hour})
+
+	// with periodic sync configured a reload reports how the index differs from globalcc
+	report := reportOf(t, serveAdmin(mux, http.MethodPost, "/admin/index/reload", "", ""))
+	if report.Drift == nil || report.Drift.Source != StrategyGlobalCC || changedIDs(report.Drift.Differences) != "HP1,HP2,HP7" {
+		t.Fatalf("unexpected drift %+v", report.Drift)
+	}
+
+	// a sync merges globalcc's hospitals into the index, keeping the ones only the index routes
+	report = reportOf(t, serveAdmin(mux, http.MethodPost, "/admin/index/sync", "", ""))
+	if report.Trigger != triggerSync || changedIDs(report.Changes) != "HP1,HP7" || changedIDs(report.Drift.Differences) != "HP1,HP2,HP7" {
+		t.Fatalf("unexpected sync report %+v", report)
+	}
+	if entry, ok := hospitals.Lookup("HP7"); !ok || entry.Chaincode != "regionalCC1" {
+		t.Errorf("expected HP7 synced from globalcc, got %+v %v", entry, ok)
+	}
+	if _, ok := hospitals.Lookup("HP2"); !ok {
+		t.Errorf("the sync dropped HP2, which only the index routes")
+	}
+	report = reportOf(t, serveAdmin(mux, http.MethodPost, "/admin/index/reload", "", ""))
+	if changedIDs(report.Drift.Differences) != "HP2" {
+		t.Errorf("expected only HP2 to drift after the sync, got %+v", report.Drift)
+	}
+
+	// a globalcc that cannot be reached fails a sync, and a reload reports the drift as unknown
+	registry.set(true, nil)
+	if response := serveAdmin(mux, http.MethodPost, "/admin/index/sync", "", ""); response.Code < http.StatusInternalServerError {
+		t.Errorf("expected the sync to fail, got %d %s", response.Code, response.Body)
+	}
+	report = reportOf(t, serveAdmin(mux, http.MethodPost, "/admin/index/reload", "", ""))
+	if report.Drift == nil || report.Drift.Error == "" {
+		t.Errorf("expected the drift error reported, got %+v", report.Drift)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gateway/internal/fabric"
+)
+
+// Codes of the regional chaincode's JSON errors the gateway maps to their own status
+const (
+	codeErased           = "ERASED"            // AccessError for records of an erased patient
+	codeVersionConflict  = "VERSION_CONFLICT"  // VersionConflictError for an update naming a stale version
+	codeAccessDenied     = "ACCESS_DENIED"     // AccessError for a caller without the role or grant
+	codeMissingAttribute = "MISSING_ATTRIBUTE" // AccessError for a caller without the role attribute
+	codeInvalidIdentity  = "INVALID_IDENTITY"  // AccessError for an unreadable caller identity
+	codeNotOwner         = "NOT_OWNER"         // consent transactions called by someone else than the owner
+)
+
+// Codes of the /v1 error envelope besides the chaincode's own
+const (
+	codeBadRequest           = "BAD_REQUEST"
+	codeNotFound             = "NOT_FOUND"
+	codeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	codeNotAcceptable        = "NOT_ACCEPTABLE"
+	codeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	codeAlreadyExists        = "ALREADY_EXISTS"
+	codeLedgerRejected       = "LEDGER_REJECTED"         // the chaincode failed the transaction
+	codeTxInvalidated        = "TRANSACTION_INVALIDATED" // the peers invalidated an ordered transaction
+	codeLedgerUnavailable    = "LEDGER_UNAVAILABLE"      // the Fabric network could not be reached
+	codeInvalidLedgerReply   = "INVALID_LEDGER_REPLY"    // the chaincode returned something the gateway cannot decode
+	codeCommitUnknown        = "COMMIT_STATUS_UNKNOWN"   // a transaction was submitted but its commit was not seen
+	codeIdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"  // an Idempotency-Key sent again with a different request
+	codeIdempotencyKeyInUse  = "IDEMPOTENCY_KEY_IN_USE"  // an Idempotency-Key whose first request is still running
+	codeStoreFailure         = "STORE_FAILURE"           // the gateway's local store could not be read or written
+	codeHospitalUnavailable  = "HOSPITAL_UNAVAILABLE"    // globalcc's registry holds the hospital but it is not active
+	codeUnauthorized         = "UNAUTHORIZED"            // an admin request without the admin token
+	codeForbidden            = "FORBIDDEN"               // an admin request from elsewhere than localhost, with no token configured
+	codeInvalidIndex         = "INVALID_INDEX"           // the hospital index file cannot be loaded
+	codeNoDigest             = "NO_DIGEST"               // /verify/ of an attachment without a digest to verify against
+	codeDocumentUnavailable  = "DOCUMENT_UNAVAILABLE"    // /verify/ could not fetch the document
+)
+
+// APIError is the body of every /v1 failure, wrapped as {"error": ...}. Details carries
+// code-specific data, such as the current version of a VERSION_CONFLICT.
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestID"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// errorEnvelope wraps an APIError
+type errorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// versionConflict is the regional chaincode's VersionConflictError, returned as the body of a 409
+type versionConflict struct {
+	Code            string `json:"code"`
+	AssetID         string `json:"assetID"`
+	ExpectedVersion int    `json:"expectedVersion"`
+	CurrentVersion  int    `json:"currentVersion"`
+}
+
+// accessError is the regional chaincode's AccessError, returned as the details of a 403
+type accessError struct {
+	Code      string `json:"code"`
+	AssetID   string `json:"assetID"`
+	Operation string `json:"operation"`
+	MSPID     string `json:"mspID,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// decodeChaincodeError decodes the JSON error object, such as the regional chaincode's AccessError,
+// carried in a ledger error message into v. It reports whether there was one.
+func decodeChaincodeError(err error, v interface{}) bool {
+	message := err.Error()
+	start := strings.Index(message, "{\"code\"")
+	if start < 0 {
+		return false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(message[start:]))
+	return decoder.Decode(v) == nil
+}
+
+// chaincodeErrorCode returns the code of the JSON error object carried in a ledger error message,
+// or "" when there is none
+func chaincodeErrorCode(err error) string {
+	var chaincodeErr struct {
+		Code string `json:"code"`
+	}
+	if !decodeChaincodeError(err, &chaincodeErr) {
+		return ""
+	}
+	return chaincodeErr.Code
+}
+
+// writeAPIError answers a /v1 request with the JSON error envelope
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, code string, message string, details interface{}) {
+	w.Header().Set("Content-Type", mediaJSON)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope{APIError{Code: code, Message: message, RequestID: requestID(r), Details: details}})
+}
+
+// writeAPILedgerError answers a failed ledger call about policyID: 410 for a crypto-shredded record,
+// which is permanently unreadable, 409 with the conflict as details for a stale update, 403 when
+// access is refused, 404 for a missing asset, 409 for a create of an existing one, 422 when the
+// chaincode rejected the call for another reason and 503 when the network is unreachable. A transaction the peers invalidated is 409 when it lost a
+// read conflict, which a retry may win, and 502 otherwise, with its ID and status as details.
+func writeAPILedgerError(w http.ResponseWriter, r *http.Request, policyID string, err error) {
+	var commitErr *fabric.CommitError
+	if errors.As(err, &commitErr) {
+		details := map[string]string{"txID": commitErr.TxID, "status": commitErr.Status}
+		if commitErr.Status == "MVCC_READ_CONFLICT" || commitErr.Status == "PHANTOM_READ_CONFLICT" {
+			writeAPIError(w, r, http.StatusConflict, codeTxInvalidated, err.Error(), details)
+			return
+		}
+		writeAPIError(w, r, http.StatusBadGateway, codeTxInvalidated, err.Error(), details)
+		return
+	}
+
+	var chaincodeErr *fabric.ChaincodeError
+	if !errors.As(err, &chaincodeErr) {
+		writeAPIError(w, r, http.StatusServiceUnavailable, codeLedgerUnavailable, "Failed to reach the Fabric network: "+err.Error(), nil)
+		return
+	}
+
+	switch code := chaincodeErrorCode(err); code {
+	case codeErased:
+		writeAPIError(w, r, http.StatusGone, code, "Policy "+policyID+" was erased and is permanently unreadable", nil)
+	case codeVersionConflict:
+		var conflict versionConflict
+		decodeChaincodeError(err, &conflict)
+		writeAPIError(w, r, http.StatusConflict, code, "Policy "+policyID+" has changed since the expected version", conflict)
+	case codeAccessDenied, codeMissingAttribute, codeInvalidIdentity, codeNotOwner:
+		var denied accessError
+		decodeChaincodeError(err, &denied)
+		writeAPIError(w, r, http.StatusForbidden, code, denied.Reason, denied)
+	default:
+		switch {
+		case strings.Contains(chaincodeErr.Message, "the asset "+policyID+" does not exist"):
+			writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Policy "+policyID+" does not exist", nil)
+		case strings.Contains(chaincodeErr.Message, "the asset "+policyID+" already exists"):
+			writeAPIError(w, r, http.StatusConflict, codeAlreadyExists, "Policy "+policyID+" already exists", nil)
+		default:
+			writeAPIError(w, r, http.StatusUnprocessableEntity, codeLedgerRejected, chaincodeErr.Message, nil)
+		}
+	}
+}
@@ -1,109 +1,59 @@
 package handlers
 
 import (
-	"encoding/csv"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"sync"
 
-	"gateway/internal/fabric"
+	"gateway/internal/index"
 )
 
-// Define the path to the CSV file
-const csvFilePath = "../gateway/internal/index/hospital_index_table.csv"
+// hospitalRoute is where a hospital's policies are read from.
+// An empty Channel means the ledger's default channel.
+type hospitalRoute struct {
+	Chaincode string
+	Channel   string
+}
 
-var (
-	chaincodeMap     map[string]string
-	chaincodeMapOnce sync.Once
-)
+// ReadPPHandler returns the handler for the deprecated /readPP/ endpoint. It permanently redirects
+// to GET /v1/hospitals/{hospitalID}/policies/{policyID}, which serves the policy as JSON or HTML.
+func ReadPPHandler() http.HandlerFunc {
+	return readPP
+}
 
-// ReadPPHandler handles the /readPP/ endpoint
-func ReadPPHandler(w http.ResponseWriter, r *http.Request) {
+func readPP(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
 	hospitalID := r.URL.Query().Get("hospitalID")
 	policyID := r.URL.Query().Get("policyID")
-
-	// Load hospital chaincode mapping from CSV (if not already loaded)
-	chaincodeName, err := getChaincodeName(hospitalID)
-	if err != nil {
-		http.Error(w, "Failed to get chaincode name: "+err.Error(), http.StatusInternalServerError)
+	if hospitalID == "" || policyID == "" {
+		http.Error(w, "hospitalID and policyID are required", http.StatusBadRequest)
 		return
 	}
 
-	// Call the Fabric network to retrieve data
-	result, err := fabric.QueryAsset(chaincodeName, policyID)
-	if err != nil {
-		http.Error(w, "Failed to query asset from Fabric network: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Extract metadata from the result and redirect
-	// Assuming metadata is a URL to redirect to
-	var policy struct {
-		ID        string   `json:"ID"`
-		Owner     string   `json:"owner"`
-		AuthRoles []string `json:"authRoles"`
-		Grant     string   `json:"grant"`
-		Metadata  string   `json:"metadata"`
-	}
+	http.Redirect(w, r, policyPath(hospitalID, policyID), http.StatusMovedPermanently)
+}
 
-	if err := json.Unmarshal(result, &policy); err != nil {
-		http.Error(w, "Failed to parse metadata", http.StatusInternalServerError)
-		return
+// getChaincodeName returns the chaincode name and channel for the given hospital ID from the hospital index.
+func getChaincodeName(hospitals *index.Index, hospitalID string) (hospitalRoute, error) {
+	entry, ok := hospitals.Lookup(hospitalID)
+	if !ok {
+		return hospitalRoute{}, errors.New("\nchaincode name not found for hospital ID: " + hospitalID)
 	}
-
-	// Set the Content-Type header to indicate that the response is HTML
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	// Display metadata URL with label and clickable link
-	fmt.Fprintf(w, "HospitalID: %s<br>ChaincodeName: %s<br>\n", hospitalID, chaincodeName)
-	fmt.Fprintf(w, "Data URL: <a href=\"%s\">%s</a><br><br>\n", policy.Metadata, policy.Metadata)
-
-	// Return json
-	json.NewEncoder(w).Encode(policy)
+	return hospitalRoute{Chaincode: entry.Chaincode, Channel: entry.Channel}, nil
 }
 
-// getChaincodeName returns the chaincode name for the given hospital ID from the CSV file
-func getChaincodeName(hospitalID string) (string, error) {
-	// Load hospital chaincode mapping from CSV (if not already loaded)
-	chaincodeMapOnce.Do(func() {
-		chaincodeMap = make(map[string]string)
-		file, err := os.Open(csvFilePath)
-		if err != nil {
-			fmt.Println("Failed to open CSV file:", err)
-			return
-		}
-		defer file.Close()
-
-		reader := csv.NewReader(file)
-		lines, err := reader.ReadAll()
-		if err != nil {
-			fmt.Println("Failed to read CSV file:", err)
-			return
-		}
-
-		for _, line := range lines {
-			if len(line) >= 2 {
-				chaincodeMap[line[0]] = line[1]
-			}
-		}
-		fmt.Println("Chaincode map loaded successfully.")
-	})
-
-	// Retrieve chaincode name from the cached map
-	chaincodeName, ok := chaincodeMap[hospitalID]
-	if !ok {
-		return "", errors.New("\nchaincode name not found for hospital ID: " + hospitalID)
+// hospitalList returns the hospitals of index entries
+func hospitalList(entries []index.Entry) []Hospital {
+	hospitals := make([]Hospital, 0, len(entries))
+	for _, entry := range entries {
+		hospitals = append(hospitals, Hospital{HospitalID: entry.HospitalID, Chaincode: entry.Chaincode, Channel: entry.Channel})
 	}
-	return chaincodeName, nil
+	return hospitals
 }
 
-func PrintChaincodeMap() {
+func PrintChaincodeMap(hospitals *index.Index) {
 	fmt.Println("Chaincode Map:")
-	for hospitalID, chaincodeName := range chaincodeMap {
-		fmt.Printf("Hospital ID: %s, Chaincode Name: %s\n", hospitalID, chaincodeName)
+	for _, entry := range hospitals.Entries() {
+		fmt.Printf("Hospital ID: %s, Chaincode Name: %s, Channel: %s\n", entry.HospitalID, entry.Chaincode, entry.Channel)
 	}
 }
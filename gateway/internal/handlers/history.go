@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+)
+
+// FieldChange is one field that differs between two versions of a policy
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// VersionDiff lists the fields that changed from one version of a policy to another
+type VersionDiff struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// historyResponse is the body returned by /historyPP/
+type historyResponse struct {
+	HospitalID string                        `json:"hospitalID"`
+	PolicyID   string                        `json:"policyID"`
+	History    []*types.RegionalAssetVersion `json:"history"`
+	Diff       *VersionDiff                  `json:"diff,omitempty"`
+}
+
+// HistoryPPHandler returns the handler for the /historyPP/ endpoint. It returns every version of a
+// policy, newest first, and the field-level diff between the versions written by the "from" and "to"
+// transaction IDs, which default to the two newest versions.
+func HistoryPPHandler(ledger fabric.Ledger, hospitals *index.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		historyPP(ledger, hospitals, w, r)
+	}
+}
+
+func historyPP(ledger fabric.Ledger, hospitals *index.Index, w http.ResponseWriter, r *http.Request) {
+	// Parse query parameters
+	hospitalID := r.URL.Query().Get("hospitalID")
+	policyID := r.URL.Query().Get("policyID")
+	fromTxID := r.URL.Query().Get("from")
+	toTxID := r.URL.Query().Get("to")
+
+	route, err := getChaincodeName(hospitals, hospitalID)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+		return
+	}
+
+	// Call the Fabric network to retrieve the history
+	result, err := ledger.Evaluate(r.Context(), route.Channel, route.Chaincode, "GetAssetHistory", policyID)
+	if err != nil {
+		writeAPILedgerError(w, r, policyID, err)
+		return
+	}
+
+	history, err := types.DecodeRegionalAssetHistory(result)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadGateway, codeInvalidLedgerReply, "Failed to parse the history of policy "+policyID+": "+err.Error(), nil)
+		return
+	}
+	sortNewestFirst(history)
+
+	response := historyResponse{HospitalID: hospitalID, PolicyID: policyID, History: history}
+	if fromTxID != "" || toTxID != "" || len(history) >= 2 {
+		from, to := findVersion(history, fromTxID, 1), findVersion(history, toTxID, 0)
+		if from == nil || to == nil {
+			writeAPIError(w, r, http.StatusNotFound, codeNotFound, "No version of policy "+policyID+" was written by the given transaction", nil)
+			return
+		}
+		response.Diff = diffVersions(from, to)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// sortNewestFirst orders history by timestamp, newest first, whatever order the peer returned
+func sortNewestFirst(history []*types.RegionalAssetVersion) {
+	sort.SliceStable(history, func(i, j int) bool {
+		ti, _ := time.Parse(time.RFC3339Nano, history[i].Timestamp)
+		tj, _ := time.Parse(time.RFC3339Nano, history[j].Timestamp)
+		return ti.After(tj)
+	})
+}
+
+// findVersion returns the version written by txID, or history[fallback] when txID is empty
+func findVersion(history []*types.RegionalAssetVersion, txID string, fallback int) *types.RegionalAssetVersion {
+	if txID == "" {
+		if fallback < len(history) {
+			return history[fallback]
+		}
+		return nil
+	}
+	for _, version := range history {
+		if version.TxID == txID {
+			return version
+		}
+	}
+	return nil
+}
+
+// diffVersions compares the JSON fields of two versions; a delete has no fields
+func diffVersions(from *types.RegionalAssetVersion, to *types.RegionalAssetVersion) *VersionDiff {
+	fromFields, toFields := versionFields(from), versionFields(to)
+
+	names := make(map[string]bool)
+	for name := range fromFields {
+		names[name] = true
+	}
+	for name := range toFields {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	diff := &VersionDiff{From: from.TxID, To: to.TxID, Changes: []FieldChange{}}
+	for _, name := range sorted {
+		if !reflect.DeepEqual(fromFields[name], toFields[name]) {
+			diff.Changes = append(diff.Changes, FieldChange{Field: name, From: fromFields[name], To: toFields[name]})
+		}
+	}
+	return diff
+}
+
+func versionFields(version *types.RegionalAssetVersion) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if version.Asset == nil {
+		return fields
+	}
+
+	assetJSON, err := json.Marshal(version.Asset)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(assetJSON, &fields)
+	return fields
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"gateway/internal/fabric"
+)
+
+func TestHistoryPPDiff(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	// the peer's order is not trusted: the handler sorts newest first
+	ledger.Handle("regionalCC1", "GetAssetHistory", func(args []string) ([]byte, error) {
+		return []byte(`[
+			{"txID":"tx1","timestamp":"2026-01-01T09:00:00Z","isDelete":false,"asset":{"schemaVersion":2,"ID":"pc1","owner":"PATIENT 1","authRoles":["Org1MSP.DoctorReg1"],"grant":"R","metadata":"https://example.com"}},
+			{"txID":"tx3","timestamp":"2026-01-01T11:00:00Z","isDelete":true},
+			{"txID":"tx2","timestamp":"2026-01-01T10:00:00Z","isDelete":false,"asset":{"schemaVersion":2,"ID":"pc1","owner":"PATIENT 1","authRoles":["Org1MSP.DoctorReg1"],"grant":"W","metadata":"https://example.com"}}
+		]`), nil
+	})
+	mux, hospitals := newTestAPI(t, ledger)
+	mux.HandleFunc("/historyPP/", HistoryPPHandler(ledger, hospitals))
+
+	var history historyResponse
+	decode := func(path string) {
+		t.Helper()
+		response := serve(mux, http.MethodGet, path, "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d %s", path, response.Code, response.Body)
+		}
+		history = historyResponse{}
+		if err := json.Unmarshal(response.Body.Bytes(), &history); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decode("/historyPP/?hospitalID=HP1&policyID=pc1")
+	if len(history.History) != 3 || history.History[0].TxID != "tx3" || history.History[1].TxID != "tx2" || history.History[2].TxID != "tx1" {
+		t.Fatalf("expected the history newest first, got %+v", history.History)
+	}
+	// the two newest versions by default: the delete drops every field
+	if history.Diff == nil || history.Diff.From != "tx2" || history.Diff.To != "tx3" || len(history.Diff.Changes) == 0 {
+		t.Fatalf("unexpected default diff %+v", history.Diff)
+	}
+	for _, change := range history.Diff.Changes {
+		if change.To != nil {
+			t.Errorf("the delete kept field %s = %v", change.Field, change.To)
+		}
+	}
+
+	decode("/historyPP/?hospitalID=HP1&policyID=pc1&from=tx1&to=tx2")
+	if history.Diff == nil || len(history.Diff.Changes) != 1 {
+		t.Fatalf("expected one change from tx1 to tx2, got %+v", history.Diff)
+	}
+	if change := history.Diff.Changes[0]; change.Field != "grant" || change.From != "R" || change.To != "W" {
+		t.Errorf("unexpected change %+v", change)
+	}
+
+	if response := serve(mux, http.MethodGet, "/historyPP/?hospitalID=HP1&policyID=pc1&from=tx9", ""); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown transaction, got %d %s", response.Code, response.Body)
+	}
+	if response := serve(mux, http.MethodGet, "/historyPP/?hospitalID=HP9&policyID=pc1", ""); response.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown hospital, got %d %s", response.Code, response.Body)
+	}
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"gateway/internal/store"
+)
+
+// IdempotencyKeyHeader names a write so that retrying it replays the first response instead of
+// submitting the transaction again
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyReplayedHeader is set to "true" on a response replayed for a repeated Idempotency-Key
+const IdempotencyReplayedHeader = "Idempotency-Replayed"
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// idempotencyBucket holds an idempotencyRecord per Idempotency-Key
+const idempotencyBucket = "idempotency"
+
+// idempotencyTTL is how long a key's response is replayed; after that the key may be used afresh
+const idempotencyTTL = 24 * time.Hour
+
+// replayedHeaders are the response headers stored with the response of a key
+var replayedHeaders = []string{"Content-Type", "Location", "Preference-Applied", RoutingStrategyHeader}
+
+// idempotencyRecord is the stored state of an Idempotency-Key. Until Done, the first request with
+// the key is still running, and TxID is the transaction it submitted, if any, which answers
+// SuccessStatus once it commits; after it, Status, Header and Body are its response.
+type idempotencyRecord struct {
+	Fingerprint   string            `json:"fingerprint"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	TxID          string            `json:"txID,omitempty"`
+	SuccessStatus int               `json:"successStatus,omitempty"`
+	Done          bool              `json:"done"`
+	Status        int               `json:"status,omitempty"`
+	Header        map[string]string `json:"header,omitempty"`
+	Body          []byte            `json:"body,omitempty"`
+}
+
+// idempotencyKeys keeps the responses of writes sent with an Idempotency-Key. running holds the
+// keys whose first request this process is still serving.
+type idempotencyKeys struct {
+	store        *store.Store
+	transactions *transactionTracker
+	mu           sync.Mutex
+	running      map[string]bool
+}
+
+// idempotencyClaimKey is the request context key of the idempotencyClaim a request runs under
+type idempotencyClaimKey struct{}
+
+// idempotencyClaim is the Idempotency-Key a request is the first to run under
+type idempotencyClaim struct {
+	keys *idempotencyKeys
+	key  string
+}
+
+// newIdempotencyKeys returns the keys kept in db, dropping the expired ones. transactions tracks
+// the transactions their requests submitted.
+func newIdempotencyKeys(db *store.Store, transactions *transactionTracker) *idempotencyKeys {
+	keys := &idempotencyKeys{store: db, transactions: transactions, running: make(map[string]bool)}
+	for _, key := range db.Keys(idempotencyBucket) {
+		var record idempotencyRecord
+		found, err := db.Get(idempotencyBucket, key, &record)
+		if err == nil && found && !record.expired() {
+			continue
+		}
+		if err := db.Delete(idempotencyBucket, key); err != nil {
+			fmt.Printf("Failed to drop idempotency key %q: %v\n", key, err)
+		}
+	}
+	return keys
+}
+
+// wrap makes handle idempotent for requests with an Idempotency-Key. A key sent again with the
+// same method, path and body replays the stored response; with a different request it is refused.
+// While the first request runs, the key is refused with 409. Responses that left the ledger
+// unchanged and may differ on retry, 409 and the 5xx ones apart from 504, are not stored, so a
+// retry with the key runs the request again.
+func (k *idempotencyKeys) wrap(handle func(w http.ResponseWriter, r *http.Request, media string)) func(w http.ResponseWriter, r *http.Request, media string) {
+	return func(w http.ResponseWriter, r *http.Request, media string) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			handle(w, r, media)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			writeAPIError(w, r, http.StatusBadRequest, codeBadRequest,
+				fmt.Sprintf("%s must be 1 to %d printable ASCII characters", IdempotencyKeyHeader, maxIdempotencyKeyLength), nil)
+			return
+		}
+
+		// the body is read here to fingerprint it and restored for handle
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "Failed to read the request body: "+err.Error(), nil)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		replay, outcome, ok := k.claim(w, r, key, fingerprint)
+		if !ok {
+			return
+		}
+		if replay != nil {
+			for name, value := range replay.Header {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(IdempotencyReplayedHeader, "true")
+			w.WriteHeader(replay.Status)
+			w.Write(replay.Body)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		if outcome != nil {
+			// the first request was cut short after its transaction was submitted, which has since
+			// committed, so its response is made from the outcome rather than submitting again
+			w.Header().Set(IdempotencyReplayedHeader, "true")
+			writeTransactionOutcome(recorder, r, outcome.successStatus, outcome.record)
+		} else {
+			ctx := context.WithValue(r.Context(), idempotencyClaimKey{}, &idempotencyClaim{keys: k, key: key})
+			handle(recorder, r.WithContext(ctx), media)
+		}
+		k.finish(key, fingerprint, recorder)
+	}
+}
+
+// submittedOutcome is the committed transaction an unfinished request submitted, and the status
+// it answers with
+type submittedOutcome struct {
+	record        *TransactionRecord
+	successStatus int
+}
+
+// claim looks key up, answering the request itself when the key cannot be used. It returns the
+// response to replay, or the committed outcome of the transaction an unfinished first request
+// submitted, or neither after recording the key as running for this request. Either way but a
+// replay, the key is running until finish.
+func (k *idempotencyKeys) claim(w http.ResponseWriter, r *http.Request, key string, fingerprint string) (*idempotencyRecord, *submittedOutcome, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var record idempotencyRecord
+	found, err := k.store.Get(idempotencyBucket, key, &record)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, codeStoreFailure, "Failed to read idempotency key: "+err.Error(), nil)
+		return nil, nil, false
+	}
+	if found && !record.expired() {
+		if record.Fingerprint != fingerprint {
+			writeAPIError(w, r, http.StatusUnprocessableEntity, codeIdempotencyKeyReused,
+				"The "+IdempotencyKeyHeader+" was already used for a different request", nil)
+			return nil, nil, false
+		}
+		if record.Done {
+			return &record, nil, true
+		}
+		if k.running[key] {
+			writeKeyInUse(w, r)
+			return nil, nil, false
+		}
+		// the first request was cut short by a restart. Once it has submitted a transaction, the key
+		// waits for its outcome; before that, the request is run again.
+		if record.TxID != "" {
+			transaction, found, err := k.transactions.get(record.TxID)
+			if err != nil {
+				writeAPIError(w, r, http.StatusInternalServerError, codeStoreFailure, "Failed to read transaction "+record.TxID+": "+err.Error(), nil)
+				return nil, nil, false
+			}
+			if !found || transaction.State == TxPending {
+				writeKeyInUse(w, r)
+				return nil, nil, false
+			}
+			k.running[key] = true
+			return nil, &submittedOutcome{record: transaction, successStatus: record.SuccessStatus}, true
+		}
+	}
+
+	err = k.store.Put(idempotencyBucket, key, idempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, codeStoreFailure, "Failed to record idempotency key: "+err.Error(), nil)
+		return nil, nil, false
+	}
+	k.running[key] = true
+	return nil, nil, true
+}
+
+// writeKeyInUse refuses a request whose Idempotency-Key is held by a request still running
+func writeKeyInUse(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, r, http.StatusConflict, codeIdempotencyKeyInUse,
+		"The first request with this "+IdempotencyKeyHeader+" is still running; retry later", nil)
+}
+
+// noteSubmitted records, against the Idempotency-Key the request runs under, the transaction it
+// submitted and the status it answers with once the transaction commits, so that a request cut
+// short by a restart is not submitted again
+func noteSubmitted(ctx context.Context, txID string, successStatus int) {
+	claim, ok := ctx.Value(idempotencyClaimKey{}).(*idempotencyClaim)
+	if !ok {
+		return
+	}
+	k := claim.keys
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var record idempotencyRecord
+	found, err := k.store.Get(idempotencyBucket, claim.key, &record)
+	if err == nil && found {
+		record.TxID = txID
+		record.SuccessStatus = successStatus
+		err = k.store.Put(idempotencyBucket, claim.key, record)
+	}
+	if err != nil {
+		fmt.Printf("Failed to record transaction %s of idempotency key %q: %v\n", txID, claim.key, err)
+	}
+}
+
+// finish stores the recorded response of key, or releases the key when the response is not kept
+func (k *idempotencyKeys) finish(key string, fingerprint string, recorder *responseRecorder) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.running, key)
+
+	var err error
+	if recorder.status == http.StatusConflict || (recorder.status >= 500 && recorder.status != http.StatusGatewayTimeout) {
+		err = k.store.Delete(idempotencyBucket, key)
+	} else {
+		header := make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				header[name] = value
+			}
+		}
+		err = k.store.Put(idempotencyBucket, key, idempotencyRecord{
+			Fingerprint: fingerprint,
+			CreatedAt:   time.Now().UTC(),
+			Done:        true,
+			Status:      recorder.status,
+			Header:      header,
+			Body:        recorder.body.Bytes(),
+		})
+	}
+	if err != nil {
+		fmt.Printf("Failed to store the response of idempotency key %q: %v\n", key, err)
+	}
+}
+
+func (r *idempotencyRecord) expired() bool {
+	return time.Since(r.CreatedAt) > idempotencyTTL
+}
+
+// validIdempotencyKey accepts non-empty keys of printable ASCII, as validRequestID does
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestFingerprint identifies a request by its method, path and body
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.Path)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping its status and body
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/internal/fabric"
+	"gateway/internal/store"
+)
+
+// createBody is a valid POST /v1/hospitals/HP1/policies body
+const createBody = `{"policyID":"region1:HP1:pc1","authRoles":["Org1MSP.DoctorReg1"],"grant":"RW","owner":"PATIENT 1","metadata":"https://example.com","salt":"0123456789abcdef","patientKey":{"ownerRef":"patient-1","key":"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`
+
+// serveWithKey sends a JSON request with an Idempotency-Key to mux
+func serveWithKey(mux http.Handler, method string, path string, body string, key string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(IdempotencyKeyHeader, key)
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, request)
+	return response
+}
+
+// countCalls returns how many times function was called on ledger
+func countCalls(ledger *fabric.FakeLedger, function string) int {
+	count := 0
+	for _, call := range ledger.Calls() {
+		if call.Function == function {
+			count++
+		}
+	}
+	return count
+}
+
+func TestIdempotencyKeyReplays(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	mux, _ := newTestAPI(t, ledger)
+
+	first := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	if first.Code != http.StatusCreated || first.Header().Get(IdempotencyReplayedHeader) != "" {
+		t.Fatalf("expected 201, got %d %s", first.Code, first.Body)
+	}
+	replayed := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	if replayed.Code != http.StatusCreated || replayed.Header().Get(IdempotencyReplayedHeader) != "true" ||
+		replayed.Body.String() != first.Body.String() || replayed.Header().Get("Location") != first.Header().Get("Location") {
+		t.Fatalf("expected the first response replayed, got %d %v %s", replayed.Code, replayed.Header(), replayed.Body)
+	}
+	if calls := countCalls(ledger, "CreateAsset"); calls != 1 {
+		t.Fatalf("expected one CreateAsset, got %d", calls)
+	}
+
+	// the same key with another request is refused, another key runs afresh
+	response := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", strings.Replace(createBody, `"RW"`, `"R"`, 1), "key-1")
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusUnprocessableEntity || apiErr.Code != codeIdempotencyKeyReused {
+		t.Errorf("expected 422 %s, got %d %+v", codeIdempotencyKeyReused, response.Code, apiErr)
+	}
+	if response := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-2"); response.Code != http.StatusCreated || countCalls(ledger, "CreateAsset") != 2 {
+		t.Errorf("expected a new key to submit again, got %d", response.Code)
+	}
+	if response := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "bad\tkey"); response.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a key with a control character, got %d", response.Code)
+	}
+}
+
+func TestIdempotencyKeyInUse(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	entered, release := make(chan struct{}), make(chan struct{})
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) {
+		entered <- struct{}{}
+		<-release
+		return nil, nil
+	})
+	mux, _ := newTestAPI(t, ledger)
+
+	var wg sync.WaitGroup
+	var first *httptest.ResponseRecorder
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	}()
+	<-entered
+
+	response := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusConflict || apiErr.Code != codeIdempotencyKeyInUse {
+		t.Errorf("expected 409 %s while the first request runs, got %d %+v", codeIdempotencyKeyInUse, response.Code, apiErr)
+	}
+	close(release)
+	wg.Wait()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected the first request to succeed, got %d %s", first.Code, first.Body)
+	}
+	if response := serveWithKey(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1"); response.Code != http.StatusCreated || response.Header().Get(IdempotencyReplayedHeader) != "true" {
+		t.Errorf("expected the finished request to be replayed, got %d", response.Code)
+	}
+}
+
+// newTestKeys returns the idempotency keys kept in a new store with the transactions submitted to
+// ledger, and a handler wrapped by them that counts its calls and answers 201
+func newTestKeys(t *testing.T, ledger fabric.Ledger) (*idempotencyKeys, *store.Store, http.Handler, *int) {
+	t.Helper()
+
+	db, err := store.Open(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	keys := newIdempotencyKeys(db, newTransactionTracker(ledger, db))
+	calls := 0
+	handle := keys.wrap(func(w http.ResponseWriter, r *http.Request, media string) {
+		calls++
+		writeJSON(w, http.StatusCreated, map[string]int{"call": calls})
+	})
+	return keys, db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handle(w, r, mediaJSON) }), &calls
+}
+
+func TestIdempotencyKeyHeldPastCommitWait(t *testing.T) {
+	keys, db, handler, calls := newTestKeys(t, fabric.NewFakeLedger())
+	request := httptest.NewRequest(http.MethodPost, "/v1/hospitals/HP1/policies", strings.NewReader(createBody))
+	fingerprint := requestFingerprint(request, []byte(createBody))
+
+	// a first request still running in this process long after it started keeps the key
+	err := db.Put(idempotencyBucket, "key-1", idempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now().Add(-2 * commitWaitTimeout)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.running["key-1"] = true
+	if response := serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1"); response.Code != http.StatusConflict || *calls != 0 {
+		t.Fatalf("expected 409 while the first request runs, got %d after %d calls", response.Code, *calls)
+	}
+
+	// one cut short by a restart before submitting anything is run again
+	delete(keys.running, "key-1")
+	if response := serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1"); response.Code != http.StatusCreated || *calls != 1 {
+		t.Fatalf("expected the unfinished request to run again, got %d after %d calls", response.Code, *calls)
+	}
+}
+
+func TestIdempotencyKeyWaitsForSubmittedTransaction(t *testing.T) {
+	ledger := &pendingLedger{FakeLedger: fabric.NewFakeLedger(), pending: true}
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	_, db, handler, calls := newTestKeys(t, ledger)
+	request := httptest.NewRequest(http.MethodPost, "/v1/hospitals/HP1/policies", strings.NewReader(createBody))
+	fingerprint := requestFingerprint(request, []byte(createBody))
+
+	// the first request submitted fake-tx-1 and was then cut short by a restart
+	txID, _, err := ledger.SubmitAsync(context.Background(), &fabric.Transaction{Channel: "mychannel", Chaincode: "regionalCC1", Function: "CreateAsset"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Put(idempotencyBucket, "key-1", idempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now().Add(-time.Hour), TxID: txID, SuccessStatus: http.StatusCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transaction := TransactionRecord{TxID: txID, State: TxPending, HospitalID: "HP1", PolicyID: "region1:HP1:pc1", Channel: "mychannel", Chaincode: "regionalCC1", Function: "CreateAsset"}
+	if err := db.Put(transactionsBucket, transaction.TxID, transaction); err != nil {
+		t.Fatal(err)
+	}
+	if response := serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1"); response.Code != http.StatusConflict || *calls != 0 {
+		t.Fatalf("expected 409 while the transaction is pending, got %d after %d calls", response.Code, *calls)
+	}
+
+	// once the commit can be seen, the background wait records it and the key answers with it
+	ledger.setPending(false)
+	deadline := time.Now().Add(5 * time.Second)
+	response := serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	for response.Code == http.StatusConflict && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		response = serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	}
+	for i := 0; i < 2; i++ {
+		if response.Code != http.StatusCreated || response.Header().Get("Location") != policyPath("HP1", "region1:HP1:pc1") ||
+			response.Header().Get(IdempotencyReplayedHeader) != "true" || !strings.Contains(response.Body.String(), txID) {
+			t.Fatalf("expected the committed transaction as the response, got %d %v %s", response.Code, response.Header(), response.Body)
+		}
+		response = serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	}
+	if *calls != 0 {
+		t.Fatalf("the request was run again although its transaction committed")
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	_, db, handler, calls := newTestKeys(t, fabric.NewFakeLedger())
+	request := httptest.NewRequest(http.MethodPost, "/v1/hospitals/HP1/policies", strings.NewReader(createBody))
+	fingerprint := requestFingerprint(request, []byte(createBody))
+
+	expired := idempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now().Add(-idempotencyTTL - time.Minute), Done: true, Status: http.StatusCreated, Body: []byte(`{"call":0}`)}
+	if err := db.Put(idempotencyBucket, "key-1", expired); err != nil {
+		t.Fatal(err)
+	}
+	response := serveWithKey(handler, http.MethodPost, "/v1/hospitals/HP1/policies", createBody, "key-1")
+	if response.Code != http.StatusCreated || response.Header().Get(IdempotencyReplayedHeader) != "" || *calls != 1 {
+		t.Fatalf("expected an expired key to run the request again, got %d %s after %d calls", response.Code, response.Body, *calls)
+	}
+
+	// expired keys are dropped when the keys are loaded
+	if err := db.Put(idempotencyBucket, "key-2", expired); err != nil {
+		t.Fatal(err)
+	}
+	newIdempotencyKeys(db, newTransactionTracker(fabric.NewFakeLedger(), db))
+	if found, _ := db.Get(idempotencyBucket, "key-2", &idempotencyRecord{}); found {
+		t.Errorf("an expired key survived loading")
+	}
+	if found, _ := db.Get(idempotencyBucket, "key-1", &idempotencyRecord{}); !found {
+		t.Errorf("a live key was dropped on loading")
+	}
+}
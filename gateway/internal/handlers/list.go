@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+)
+
+// defaultPageSize is used when a list request has no pageSize
+const defaultPageSize = 100
+
+// ListPPHandler returns the handler for the /listPP/ endpoint, which pages through the
+// policies held by a hospital's regional chaincode
+func ListPPHandler(ledger fabric.Ledger, hospitals *index.Index) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		listPP(ledger, hospitals, w, r)
+	}
+}
+
+func listPP(ledger fabric.Ledger, hospitals *index.Index, w http.ResponseWriter, r *http.Request) {
+	// Parse query parameters
+	hospitalID := r.URL.Query().Get("hospitalID")
+	bookmark := r.URL.Query().Get("bookmark")
+	pageSize := defaultPageSize
+	if value := r.URL.Query().Get("pageSize"); value != "" {
+		var err error
+		pageSize, err = strconv.Atoi(value)
+		if err != nil || pageSize < 1 || pageSize > types.MaxPageSize {
+			writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "pageSize must be a number between 1 and "+strconv.Itoa(types.MaxPageSize), nil)
+			return
+		}
+	}
+
+	route, err := getChaincodeName(hospitals, hospitalID)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+		return
+	}
+
+	// Call the Fabric network to retrieve one page
+	result, err := ledger.Evaluate(r.Context(), route.Channel, route.Chaincode, "GetAllAssetsWithPagination", strconv.Itoa(pageSize), bookmark)
+	if err != nil {
+		writeAPILedgerError(w, r, "", err)
+		return
+	}
+
+	page, err := types.DecodeRegionalAssetPage(result)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadGateway, codeInvalidLedgerReply, "Failed to parse assets: "+err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Media types the /v1 endpoints can produce
+const (
+	mediaJSON = "application/json"
+	mediaHTML = "text/html"
+)
+
+// negotiate picks the offer the request's Accept header prefers, following RFC 9110: the highest
+// quality wins, then the most specific matching range, then the earlier offer. A request without
+// Accept gets the first offer; "" means none is acceptable.
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		quality := offerQuality(accept, offer)
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// offerQuality returns the quality the most specific media range matching offer gives it
+func offerQuality(accept []string, offer string) float64 {
+	offerType, offerSubtype, _ := strings.Cut(offer, "/")
+	quality, specificity := 0.0, -1
+	for _, header := range accept {
+		for _, mediaRange := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+			rangeType, rangeSubtype, _ := strings.Cut(mediaType, "/")
+
+			rangeSpecificity := 0
+			switch {
+			case rangeType == offerType && rangeSubtype == offerSubtype:
+				rangeSpecificity = 2
+			case rangeType == offerType && rangeSubtype == "*":
+				rangeSpecificity = 1
+			case rangeType == "*" && rangeSubtype == "*":
+			default:
+				continue
+			}
+			if rangeSpecificity <= specificity {
+				continue
+			}
+
+			specificity, quality = rangeSpecificity, 1
+			if q, ok := params["q"]; ok {
+				if value, err := strconv.ParseFloat(q, 64); err == nil && value >= 0 && value <= 1 {
+					quality = value
+				}
+			}
+		}
+	}
+	return quality
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// openAPIVersion is the version of the /v1 API in its OpenAPI document
+const openAPIVersion = "1.0.0"
+
+// pathParameter matches the net/http wildcards of a route pattern
+var pathParameter = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// openAPIDocument generates the OpenAPI 3.0 document of routes. Request and response schemas are
+// derived from the Go types the handlers decode and encode, following their json tags: fields
+// without omitempty are required.
+func openAPIDocument(routes []Route) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	errorSchema := schemaOf(reflect.TypeOf(errorEnvelope{}), schemas)
+
+	paths := make(map[string]interface{})
+	for _, route := range routes {
+		parameters := []interface{}{}
+		for _, match := range pathParameter.FindAllStringSubmatch(route.Pattern, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		if route.Routed {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        RoutingStrategyHeader,
+				"in":          "header",
+				"description": "How the hospital's regional chaincode is found, instead of the gateway's default; the response reports the one used",
+				"schema":      map[string]interface{}{"type": "string", "enum": []string{StrategyCSV, StrategyGlobalCC, StrategyHybrid}},
+			})
+		}
+		if route.Submits {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        IdempotencyKeyHeader,
+				"in":          "header",
+				"description": "Retrying with the same key replays the first response instead of submitting again, for 24 hours",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			}, map[string]interface{}{
+				"name":        "Prefer",
+				"in":          "header",
+				"description": "respond-async answers 202 once the transaction is submitted; its state is at the Location",
+				"schema":      map[string]interface{}{"type": "string"},
+			})
+		}
+
+		content := map[string]interface{}{
+			mediaJSON: map[string]interface{}{"schema": schemaOf(reflect.TypeOf(route.Response), schemas)},
+		}
+		if route.HTML {
+			content[mediaHTML] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		responses := map[string]interface{}{
+			strconv.Itoa(status): map[string]interface{}{"description": http.StatusText(status), "content": content},
+		}
+		if route.Submits {
+			responses[strconv.Itoa(http.StatusAccepted)] = map[string]interface{}{"description": http.StatusText(http.StatusAccepted), "content": content}
+		}
+		errorStatuses := []int{http.StatusMethodNotAllowed, http.StatusNotAcceptable}
+		if route.Routed {
+			// an unknown strategy
+			errorStatuses = append(errorStatuses, http.StatusBadRequest)
+		}
+		if route.Request != nil {
+			errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusUnsupportedMediaType)
+		}
+		if route.Submits {
+			// an unusable Idempotency-Key, or the local store failing
+			errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusInternalServerError)
+		}
+		for _, status := range append(errorStatuses, route.Errors...) {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     map[string]interface{}{mediaJSON: map[string]interface{}{"schema": errorSchema}},
+			}
+		}
+
+		operations, ok := paths[route.Pattern].(map[string]interface{})
+		if !ok {
+			operations = make(map[string]interface{})
+			paths[route.Pattern] = operations
+		}
+		operation := map[string]interface{}{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"parameters":  parameters,
+			"responses":   responses,
+		}
+		if route.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					mediaJSON: map[string]interface{}{"schema": schemaOf(reflect.TypeOf(route.Request), schemas)},
+				},
+			}
+		}
+		operations[strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Cross-region policy gateway",
+			"version":     openAPIVersion,
+			"description": "Every response carries the " + RequestIDHeader + " header, which a client may set itself.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// schemaOf returns the schema of t, adding named structs to schemas and referring to them
+func schemaOf(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return objectSchema(t, schemas)
+		}
+		name = strings.ToUpper(name[:1]) + name[1:]
+		if _, ok := schemas[name]; !ok {
+			// placeholder, so a recursive type refers to itself instead of looping
+			schemas[name] = nil
+			schemas[name] = objectSchema(t, schemas)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		// interface{} holds any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+// objectSchema returns the schema of a struct's exported, JSON-encoded fields
+func objectSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,435 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+)
+
+// Routing strategies: how the gateway finds the regional chaincode serving a hospital
+const (
+	StrategyCSV      = "csv"      // the local hospital_index_table.csv
+	StrategyGlobalCC = "globalcc" // globalcc's registry, on every request
+	StrategyHybrid   = "hybrid"   // a local cache of globalcc's registry
+)
+
+// RoutingStrategyHeader picks the strategy of one request and reports, on the response, the one used
+const RoutingStrategyHeader = "X-Routing-Strategy"
+
+// defaultGlobalChaincode is the name globalcc is deployed under, as in the regional chaincode's config
+const defaultGlobalChaincode = "globalCC"
+
+// The hybrid router reloads the registry when its copy is older than hybridTTL, and on a miss
+// when it is older than hybridMissReload
+const (
+	hybridTTL        = time.Minute
+	hybridMissReload = 5 * time.Second
+)
+
+// Router resolves the regional chaincode serving a hospital. Errors for hospitals the router does
+// not know wrap errUnknownHospital.
+type Router interface {
+	// Strategy is the router's name, one of the Strategy constants
+	Strategy() string
+	// Hospitals returns the hospitals the router can route to, ordered by ID
+	Hospitals(ctx context.Context) ([]Hospital, error)
+	// Resolve returns the regional chaincode and channel serving hospitalID
+	Resolve(ctx context.Context, hospitalID string) (hospitalRoute, error)
+	// ReadPolicy reads a policy of hospitalID, returning the chaincode and channel it was read through
+	ReadPolicy(ctx context.Context, hospitalID string, policyID string) (hospitalRoute, []byte, error)
+}
+
+var (
+	errUnknownHospital = errors.New("hospital is not in the index")
+	errInvalidRegistry = errors.New("globalcc returned an unreadable registry")
+	errInvalidPolicyID = errors.New("globalcc returned an unusable policy ID")
+)
+
+// hospitalUnavailableError is returned for a hospital the registry holds but does not route to,
+// as globalcc's HospitalUnavailableError
+type hospitalUnavailableError struct {
+	HospitalID string
+	Status     string
+	Reason     string
+}
+
+func (e *hospitalUnavailableError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("the hospital %s is %s", e.HospitalID, e.Status)
+	}
+	return fmt.Sprintf("the hospital %s is %s: %s", e.HospitalID, e.Status, e.Reason)
+}
+
+// RoutingConfig selects the routers of the gateway. Strategy is the default, csv when empty;
+// GlobalChaincode and GlobalChannel locate globalcc, "globalCC" on the ledger's default channel
+// when empty.
+type RoutingConfig struct {
+	Strategy        string
+	GlobalChaincode string
+	GlobalChannel   string
+}
+
+// Routing holds a router per strategy and picks the one of each request
+type Routing struct {
+	routers  map[string]Router
+	fallback Router
+	registry *globalRegistry
+}
+
+// NewRouting returns the routers, reading the local index from hospitals and reaching globalcc
+// through ledger
+func NewRouting(ledger fabric.Ledger, hospitals *index.Index, config RoutingConfig) (*Routing, error) {
+	if config.GlobalChaincode == "" {
+		config.GlobalChaincode = defaultGlobalChaincode
+	}
+	registry := &globalRegistry{ledger: ledger, chaincode: config.GlobalChaincode, channel: config.GlobalChannel}
+	routing := &Routing{registry: registry, routers: map[string]Router{
+		StrategyCSV:      &csvRouter{ledger: ledger, hospitals: hospitals},
+		StrategyGlobalCC: &globalRouter{registry: registry},
+		StrategyHybrid:   &hybridRouter{registry: registry},
+	}}
+
+	strategy := config.Strategy
+	if strategy == "" {
+		strategy = StrategyCSV
+	}
+	routing.fallback = routing.routers[strategy]
+	if routing.fallback == nil {
+		return nil, fmt.Errorf("unknown routing strategy %q, must be one of %s", strategy, strings.Join(routing.strategies(), ", "))
+	}
+	return routing, nil
+}
+
+// choose returns the router named by the request's RoutingStrategyHeader, or the default one, and
+// reports it on the response. It answers 400 itself for an unknown strategy.
+func (r *Routing) choose(w http.ResponseWriter, req *http.Request) (Router, bool) {
+	w.Header().Add("Vary", RoutingStrategyHeader)
+	router := r.fallback
+	if strategy := req.Header.Get(RoutingStrategyHeader); strategy != "" {
+		router = r.routers[strings.ToLower(strategy)]
+		if router == nil {
+			writeAPIError(w, req, http.StatusBadRequest, codeBadRequest,
+				fmt.Sprintf("Unknown %s %q, must be one of %s", RoutingStrategyHeader, strategy, strings.Join(r.strategies(), ", ")), nil)
+			return nil, false
+		}
+	}
+
+	w.Header().Set(RoutingStrategyHeader, router.Strategy())
+	return router, true
+}
+
+func (r *Routing) strategies() []string {
+	strategies := make([]string, 0, len(r.routers))
+	for strategy := range r.routers {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	return strategies
+}
+
+// writeRouteError answers a failed resolution of hospitalID: 404 for an unknown hospital, 422 for
+// one that is not active, 502 for a registry or policy ID the gateway cannot use, and the ledger error
+// mapping when globalcc could not be asked
+func writeRouteError(w http.ResponseWriter, r *http.Request, hospitalID string, err error) {
+	var unavailable *hospitalUnavailableError
+	switch {
+	case errors.Is(err, errUnknownHospital):
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+	case errors.As(err, &unavailable):
+		writeAPIError(w, r, http.StatusUnprocessableEntity, codeHospitalUnavailable, err.Error(), nil)
+	case errors.Is(err, errInvalidRegistry), errors.Is(err, errInvalidPolicyID):
+		writeAPIError(w, r, http.StatusBadGateway, codeInvalidLedgerReply, err.Error(), nil)
+	default:
+		writeAPILedgerError(w, r, hospitalID, err)
+	}
+}
+
+// csvRouter routes with the local hospital index
+type csvRouter struct {
+	ledger    fabric.Ledger
+	hospitals *index.Index
+}
+
+func (c *csvRouter) Strategy() string {
+	return StrategyCSV
+}
+
+func (c *csvRouter) Hospitals(ctx context.Context) ([]Hospital, error) {
+	return hospitalList(c.hospitals.Entries()), nil
+}
+
+func (c *csvRouter) Resolve(ctx context.Context, hospitalID string) (hospitalRoute, error) {
+	route, err := getChaincodeName(c.hospitals, hospitalID)
+	if err != nil {
+		return hospitalRoute{}, fmt.Errorf("%w: %s", errUnknownHospital, hospitalID)
+	}
+	return route, nil
+}
+
+func (c *csvRouter) ReadPolicy(ctx context.Context, hospitalID string, policyID string) (hospitalRoute, []byte, error) {
+	return readRegionalPolicy(ctx, c, c.ledger, hospitalID, policyID)
+}
+
+// globalRouter asks globalcc on every request. A policy read is a single ReadRegionalAsset call,
+// in which globalcc resolves the hospital and reads the regional chaincode itself.
+type globalRouter struct {
+	registry *globalRegistry
+}
+
+func (g *globalRouter) Strategy() string {
+	return StrategyGlobalCC
+}
+
+func (g *globalRouter) Hospitals(ctx context.Context) ([]Hospital, error) {
+	snapshot, err := g.registry.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.hospitals(), nil
+}
+
+func (g *globalRouter) Resolve(ctx context.Context, hospitalID string) (hospitalRoute, error) {
+	return g.registry.resolve(ctx, hospitalID)
+}
+
+func (g *globalRouter) ReadPolicy(ctx context.Context, hospitalID string, policyID string) (hospitalRoute, []byte, error) {
+	route := hospitalRoute{Chaincode: g.registry.chaincode, Channel: g.registry.channel}
+	result, err := g.registry.ledger.Evaluate(ctx, route.Channel, route.Chaincode, "ReadRegionalAsset", policyID, hospitalID)
+	if err != nil && isMissing(err, "the asset hospitalID ("+hospitalID+") does not exist") {
+		return route, nil, fmt.Errorf("%w: %s", errUnknownHospital, hospitalID)
+	}
+	return route, result, err
+}
+
+// hybridRouter routes with a copy of globalcc's registry, reloaded when it is stale or misses
+type hybridRouter struct {
+	registry *globalRegistry
+	mu       sync.Mutex
+	snapshot *registrySnapshot
+}
+
+func (h *hybridRouter) Strategy() string {
+	return StrategyHybrid
+}
+
+func (h *hybridRouter) Hospitals(ctx context.Context) ([]Hospital, error) {
+	snapshot, err := h.current(ctx, hybridTTL)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.hospitals(), nil
+}
+
+func (h *hybridRouter) Resolve(ctx context.Context, hospitalID string) (hospitalRoute, error) {
+	snapshot, err := h.current(ctx, hybridTTL)
+	if err != nil {
+		return hospitalRoute{}, err
+	}
+	if _, ok := snapshot.assets[hospitalID]; !ok {
+		// the hospital may have been registered since the copy was taken
+		snapshot, err = h.current(ctx, hybridMissReload)
+		if err != nil {
+			return hospitalRoute{}, err
+		}
+	}
+	return snapshot.resolve(hospitalID)
+}
+
+func (h *hybridRouter) ReadPolicy(ctx context.Context, hospitalID string, policyID string) (hospitalRoute, []byte, error) {
+	return readRegionalPolicy(ctx, h, h.registry.ledger, hospitalID, policyID)
+}
+
+// current returns the copy of the registry, reloading it first when it is older than maxAge. While
+// globalcc cannot be reached the old copy keeps being used. The registry is loaded without holding
+// the lock, so a slow globalcc does not hold up requests that the current copy can answer.
+func (h *hybridRouter) current(ctx context.Context, maxAge time.Duration) (*registrySnapshot, error) {
+	h.mu.Lock()
+	previous := h.snapshot
+	h.mu.Unlock()
+	if previous != nil && time.Since(previous.loadedAt) < maxAge {
+		return previous, nil
+	}
+
+	snapshot, err := h.registry.load(ctx)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		if h.snapshot != nil {
+			fmt.Printf("Failed to reload the hospital registry, routing with the copy from %s: %v\n", h.snapshot.loadedAt.Format(time.RFC3339), err)
+			return h.snapshot, nil
+		}
+		return nil, err
+	}
+	// a concurrent reload may have swapped in a later copy meanwhile
+	if h.snapshot == nil || !h.snapshot.loadedAt.After(snapshot.loadedAt) {
+		h.snapshot = snapshot
+	}
+	return h.snapshot, nil
+}
+
+// readRegionalPolicy resolves hospitalID with router and reads the policy from its regional chaincode
+func readRegionalPolicy(ctx context.Context, router Router, ledger fabric.Ledger, hospitalID string, policyID string) (hospitalRoute, []byte, error) {
+	route, err := router.Resolve(ctx, hospitalID)
+	if err != nil {
+		return route, nil, err
+	}
+	result, err := ledger.Evaluate(ctx, route.Channel, route.Chaincode, "ReadAsset", policyID)
+	return route, result, err
+}
+
+// globalRegistry reads the hospital registry of globalcc
+type globalRegistry struct {
+	ledger    fabric.Ledger
+	chaincode string
+	channel   string
+}
+
+// registrySnapshot is globalcc's registry at loadedAt: the hospitals and the channel of each region
+type registrySnapshot struct {
+	assets   map[string]*types.GlobalAsset
+	channels map[string]string
+	loadedAt time.Time
+}
+
+// region is globalcc's Region
+type region struct {
+	RegionID string `json:"regionID"`
+	Channel  string `json:"channel"`
+}
+
+// load reads the whole registry with GetAllAssets and GetAllRegions
+func (g *globalRegistry) load(ctx context.Context) (*registrySnapshot, error) {
+	result, err := g.ledger.Evaluate(ctx, g.channel, g.chaincode, "GetAllAssets")
+	if err != nil {
+		return nil, err
+	}
+	var rawAssets []json.RawMessage
+	if err := json.Unmarshal(result, &rawAssets); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRegistry, err)
+	}
+	snapshot := &registrySnapshot{
+		assets:   make(map[string]*types.GlobalAsset, len(rawAssets)),
+		channels: make(map[string]string),
+		loadedAt: time.Now(),
+	}
+	for _, raw := range rawAssets {
+		asset, err := types.DecodeGlobalAsset(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidRegistry, err)
+		}
+		snapshot.assets[asset.HospitalID] = asset
+	}
+
+	result, err = g.ledger.Evaluate(ctx, g.channel, g.chaincode, "GetAllRegions")
+	if err != nil {
+		return nil, err
+	}
+	var regions []region
+	if err := json.Unmarshal(result, &regions); err != nil {
+		return nil, fmt.Errorf("%w: regions: %v", errInvalidRegistry, err)
+	}
+	for _, region := range regions {
+		snapshot.channels[region.RegionID] = region.Channel
+	}
+	return snapshot, nil
+}
+
+// resolve reads one hospital, and its region's channel, from globalcc
+func (g *globalRegistry) resolve(ctx context.Context, hospitalID string) (hospitalRoute, error) {
+	result, err := g.ledger.Evaluate(ctx, g.channel, g.chaincode, "ReadAsset", hospitalID)
+	if err != nil {
+		if isMissing(err, "the asset "+hospitalID+" does not exist") {
+			return hospitalRoute{}, fmt.Errorf("%w: %s", errUnknownHospital, hospitalID)
+		}
+		return hospitalRoute{}, err
+	}
+	asset, err := types.DecodeGlobalAsset(result)
+	if err != nil {
+		return hospitalRoute{}, fmt.Errorf("%w: hospital %s: %v", errInvalidRegistry, hospitalID, err)
+	}
+
+	snapshot := &registrySnapshot{assets: map[string]*types.GlobalAsset{hospitalID: asset}, channels: make(map[string]string)}
+	if asset.Region != "" {
+		result, err := g.ledger.Evaluate(ctx, g.channel, g.chaincode, "ReadRegion", asset.Region)
+		switch {
+		case err == nil:
+			var region region
+			if err := json.Unmarshal(result, &region); err != nil {
+				return hospitalRoute{}, fmt.Errorf("%w: region %s: %v", errInvalidRegistry, asset.Region, err)
+			}
+			snapshot.channels[region.RegionID] = region.Channel
+		case !isMissing(err, "the region "+asset.Region+" does not exist"):
+			return hospitalRoute{}, err
+		}
+	}
+	return snapshot.resolve(hospitalID)
+}
+
+// allocatePolicyID submits AllocatePolicyID, which issues the next policy ID of hospitalID, and
+// waits for it to commit, since regional CreateAsset only accepts IDs globalcc has committed
+func (g *globalRegistry) allocatePolicyID(ctx context.Context, hospitalID string) (string, error) {
+	result, err := g.ledger.Submit(ctx, g.channel, g.chaincode, "AllocatePolicyID", hospitalID)
+	if err != nil {
+		if isMissing(err, "the asset "+hospitalID+" does not exist") {
+			return "", fmt.Errorf("%w: %s", errUnknownHospital, hospitalID)
+		}
+		return "", err
+	}
+	policyID := string(result)
+	if _, allocatedTo, ok := types.ParsePolicyID(policyID); !ok || allocatedTo != hospitalID {
+		return "", fmt.Errorf("%w: %q for hospital %s", errInvalidPolicyID, policyID, hospitalID)
+	}
+	return policyID, nil
+}
+
+// resolve routes hospitalID as globalcc's routeFor does: only active hospitals are routed, and the
+// region's channel wins over the hospital's own
+func (s *registrySnapshot) resolve(hospitalID string) (hospitalRoute, error) {
+	asset, ok := s.assets[hospitalID]
+	if !ok {
+		return hospitalRoute{}, fmt.Errorf("%w: %s", errUnknownHospital, hospitalID)
+	}
+	if asset.Status != types.HospitalActive {
+		return hospitalRoute{}, &hospitalUnavailableError{HospitalID: hospitalID, Status: asset.Status, Reason: asset.Reason}
+	}
+	return s.route(asset), nil
+}
+
+func (s *registrySnapshot) route(asset *types.GlobalAsset) hospitalRoute {
+	route := hospitalRoute{Chaincode: asset.RegionalCCName, Channel: asset.Channel}
+	if channel, ok := s.channels[asset.Region]; ok && asset.Region != "" {
+		route.Channel = channel
+	}
+	return route
+}
+
+// hospitals returns the active hospitals, ordered by ID
+func (s *registrySnapshot) hospitals() []Hospital {
+	hospitals := make([]Hospital, 0, len(s.assets))
+	for hospitalID, asset := range s.assets {
+		if asset.Status != types.HospitalActive {
+			continue
+		}
+		route := s.route(asset)
+		hospitals = append(hospitals, Hospital{HospitalID: hospitalID, Chaincode: route.Chaincode, Channel: route.Channel})
+	}
+	sort.Slice(hospitals, func(i, j int) bool { return hospitals[i].HospitalID < hospitals[j].HospitalID })
+	return hospitals
+}
+
+// isMissing reports whether the chaincode rejected a call with message
+func isMissing(err error, message string) bool {
+	var chaincodeErr *fabric.ChaincodeError
+	return errors.As(err, &chaincodeErr) && strings.Contains(chaincodeErr.Message, message)
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+)
+
+// fakeRegistry serves globalcc's GetAllAssets and GetAllRegions on a FakeLedger. While failing is
+// set GetAllAssets fails, and while block is set it waits for block to be closed.
+type fakeRegistry struct {
+	mu        sync.Mutex
+	hospitals []types.GlobalAsset
+	failing   bool
+	block     chan struct{}
+	loading   chan struct{}
+}
+
+func newFakeRegistry(ledger *fabric.FakeLedger, hospitalIDs ...string) *fakeRegistry {
+	registry := &fakeRegistry{loading: make(chan struct{}, 1)}
+	for _, hospitalID := range hospitalIDs {
+		registry.register(hospitalID)
+	}
+	ledger.Handle("globalCC", "GetAllAssets", func(args []string) ([]byte, error) {
+		registry.mu.Lock()
+		failing, block := registry.failing, registry.block
+		hospitalsJSON, err := json.Marshal(registry.hospitals)
+		registry.mu.Unlock()
+		if block != nil {
+			registry.loading <- struct{}{}
+			<-block
+		}
+		if failing {
+			return nil, errors.New("dial tcp 127.0.0.1:7051: connection refused")
+		}
+		return hospitalsJSON, err
+	})
+	ledger.Handle("globalCC", "GetAllRegions", func(args []string) ([]byte, error) { return []byte(`[]`), nil })
+	return registry
+}
+
+// register adds an active hospital on regionalCC1 to the registry
+func (f *fakeRegistry) register(hospitalID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hospitals = append(f.hospitals, types.GlobalAsset{
+		SchemaVersion:  types.GlobalAssetSchemaVersion,
+		HospitalID:     hospitalID,
+		RegionalCCName: "regionalCC1",
+		Channel:        "mychannel",
+		Status:         types.HospitalActive,
+	})
+}
+
+func (f *fakeRegistry) set(failing bool, block chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing, f.block = failing, block
+}
+
+// age makes the hybrid router's copy of the registry as old as age
+func age(h *hybridRouter, age time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshot.loadedAt = time.Now().Add(-age)
+}
+
+func TestNewRoutingStrategy(t *testing.T) {
+	for _, test := range []struct {
+		strategy string
+		expected string
+	}{
+		{"", StrategyCSV},
+		{StrategyGlobalCC, StrategyGlobalCC},
+		{StrategyHybrid, StrategyHybrid},
+	} {
+		routing, err := NewRouting(fabric.NewFakeLedger(), nil, RoutingConfig{Strategy: test.strategy})
+		if err != nil || routing.fallback.Strategy() != test.expected {
+			t.Errorf("strategy %q: expected the %s router, got %v", test.strategy, test.expected, err)
+		}
+	}
+	if _, err := NewRouting(fabric.NewFakeLedger(), nil, RoutingConfig{Strategy: "dns"}); err == nil || !strings.Contains(err.Error(), "unknown routing strategy") {
+		t.Errorf("expected an unknown strategy to be refused, got %v", err)
+	}
+}
+
+func TestRoutingStrategyHeader(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	newFakeRegistry(ledger, "HP1", "HP7")
+	mux, _ := newTestAPI(t, ledger)
+
+	for _, test := range []struct {
+		header   string
+		status   int
+		strategy string
+	}{
+		{"", http.StatusOK, StrategyCSV},
+		{"GlobalCC", http.StatusOK, StrategyGlobalCC},
+		{StrategyHybrid, http.StatusOK, StrategyHybrid},
+		{"dns", http.StatusBadRequest, ""},
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/hospitals", nil)
+		if test.header != "" {
+			request.Header.Set(RoutingStrategyHeader, test.header)
+		}
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		if response.Code != test.status || response.Header().Get(RoutingStrategyHeader) != test.strategy {
+			t.Errorf("strategy %q: expected %d from %q, got %d from %q %s", test.header, test.status, test.strategy,
+				response.Code, response.Header().Get(RoutingStrategyHeader), response.Body)
+		}
+	}
+
+	// the index knows HP2 and globalcc HP7, so only the registry strategies route to HP7
+	calls := len(ledger.Calls())
+	for _, strategy := range []string{StrategyGlobalCC, StrategyHybrid} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/hospitals/HP7", nil)
+		request.Header.Set(RoutingStrategyHeader, strategy)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		if response.Code == http.StatusNotFound {
+			t.Errorf("%s: expected HP7 to be found in globalcc's registry, got %d", strategy, response.Code)
+		}
+	}
+	if len(ledger.Calls()) == calls {
+		t.Errorf("the registry strategies did not ask globalcc")
+	}
+}
+
+func TestHybridRouterReloads(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	registry := newFakeRegistry(ledger, "HP1")
+	h := &hybridRouter{registry: &globalRegistry{ledger: ledger, chaincode: "globalCC"}}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if route, err := h.Resolve(ctx, "HP1"); err != nil || route.Chaincode != "regionalCC1" {
+			t.Fatalf("expected HP1 on regionalCC1, got %+v %v", route, err)
+		}
+	}
+	if loads := countCalls(ledger, "GetAllAssets"); loads != 1 {
+		t.Fatalf("expected the registry loaded once while fresh, got %d loads", loads)
+	}
+
+	// a hospital registered since the copy was taken is only looked for once the copy is old enough
+	registry.register("HP2")
+	if _, err := h.Resolve(ctx, "HP2"); !errors.Is(err, errUnknownHospital) || countCalls(ledger, "GetAllAssets") != 1 {
+		t.Fatalf("expected a miss on a fresh copy to be answered from it, got %v", err)
+	}
+	age(h, hybridMissReload+time.Second)
+	if _, err := h.Resolve(ctx, "HP2"); err != nil || countCalls(ledger, "GetAllAssets") != 2 {
+		t.Fatalf("expected a miss to reload the registry, got %v", err)
+	}
+	age(h, hybridTTL+time.Second)
+	if _, err := h.Resolve(ctx, "HP1"); err != nil || countCalls(ledger, "GetAllAssets") != 3 {
+		t.Fatalf("expected a stale copy to be reloaded, got %v", err)
+	}
+}
+
+func TestHybridRouterFallsBackToItsCopy(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	registry := newFakeRegistry(ledger, "HP1")
+	h := &hybridRouter{registry: &globalRegistry{ledger: ledger, chaincode: "globalCC"}}
+	ctx := context.Background()
+
+	registry.set(true, nil)
+	if _, err := h.Resolve(ctx, "HP1"); err == nil {
+		t.Fatalf("expected an error with no copy while globalcc cannot be reached")
+	}
+	registry.set(false, nil)
+	if _, err := h.Resolve(ctx, "HP1"); err != nil {
+		t.Fatal(err)
+	}
+
+	registry.set(true, nil)
+	age(h, hybridTTL+time.Second)
+	if route, err := h.Resolve(ctx, "HP1"); err != nil || route.Chaincode != "regionalCC1" {
+		t.Fatalf("expected the old copy to keep routing while globalcc cannot be reached, got %+v %v", route, err)
+	}
+	if hospitals, err := h.Hospitals(ctx); err != nil || len(hospitals) != 1 {
+		t.Fatalf("expected the old copy's hospitals, got %v %v", hospitals, err)
+	}
+}
+
+func TestHybridRouterLoadsWithoutTheLock(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	registry := newFakeRegistry(ledger, "HP1")
+	h := &hybridRouter{registry: &globalRegistry{ledger: ledger, chaincode: "globalCC"}}
+	ctx := context.Background()
+	if _, err := h.Resolve(ctx, "HP1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// one request reloads a stale copy from a globalcc that does not answer
+	block := make(chan struct{})
+	registry.set(false, block)
+	age(h, hybridTTL+time.Second)
+	done := make(chan error)
+	go func() {
+		_, err := h.Resolve(ctx, "HP1")
+		done <- err
+	}()
+	<-registry.loading
+
+	// another that accepts the copy's age is answered meanwhile
+	answered := make(chan error)
+	go func() {
+		_, err := h.current(ctx, time.Hour)
+		answered <- err
+	}()
+	select {
+	case err := <-answered:
+		if err != nil {
+			t.Errorf("expected the copy, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a request the copy can answer waited for the reload")
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("the reload failed: %v", err)
+	}
+	h.mu.Lock()
+	loadedAt := h.snapshot.loadedAt
+	h.mu.Unlock()
+	if time.Since(loadedAt) > hybridTTL {
+		t.Errorf("the reloaded copy was not swapped in")
+	}
+}
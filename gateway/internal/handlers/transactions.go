@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gateway/internal/fabric"
+	"gateway/internal/store"
+)
+
+// States of a transaction the gateway submitted
+const (
+	TxPending   = "pending"   // submitted, commit not seen yet
+	TxCommitted = "committed" // committed as VALID, so it updated the ledger
+	TxFailed    = "failed"    // committed with another validation code, so it had no effect
+)
+
+// transactionsBucket holds a TransactionRecord per txID
+const transactionsBucket = "transactions"
+
+// commitWaitTimeout bounds the wait for a transaction submitted with Prefer: respond-async to commit
+const commitWaitTimeout = 5 * time.Minute
+
+// TransactionRecord is the gateway's record of a transaction it submitted, returned by
+// GET /v1/transactions/{txID}. ValidationCode is set once the transaction committed; Error is the
+// last failure to learn its status, after which the next read asks the ledger again.
+type TransactionRecord struct {
+	TxID           string `json:"txID"`
+	State          string `json:"state"`
+	ValidationCode string `json:"validationCode,omitempty"`
+	BlockNumber    uint64 `json:"blockNumber,omitempty"`
+	Error          string `json:"error,omitempty"`
+	HospitalID     string `json:"hospitalID"`
+	PolicyID       string `json:"policyID"`
+	Chaincode      string `json:"chaincode"`
+	Channel        string `json:"channel,omitempty"`
+	Function       string `json:"function"`
+	SubmittedAt    string `json:"submittedAt"`
+	CompletedAt    string `json:"completedAt,omitempty"`
+}
+
+// transactionTracker submits transactions and keeps their records in the store, waiting for their
+// commit in the background when the client did not
+type transactionTracker struct {
+	ledger  fabric.Ledger
+	store   *store.Store
+	mu      sync.Mutex
+	waiting map[string]bool
+}
+
+func newTransactionTracker(ledger fabric.Ledger, db *store.Store) *transactionTracker {
+	return &transactionTracker{ledger: ledger, store: db, waiting: make(map[string]bool)}
+}
+
+// submit submits tx without waiting for it to commit and records it as pending.
+// record describes the policy the transaction is about.
+func (t *transactionTracker) submit(ctx context.Context, tx *fabric.Transaction, record TransactionRecord) (*TransactionRecord, error) {
+	txID, _, err := t.ledger.SubmitAsync(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	record.TxID = txID
+	record.State = TxPending
+	record.Chaincode = tx.Chaincode
+	record.Channel = tx.Channel
+	record.Function = tx.Function
+	record.SubmittedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	t.save(&record)
+	return &record, nil
+}
+
+// await waits for a pending transaction to commit and records the outcome. The error is a failure
+// to learn the status, not an invalid transaction.
+func (t *transactionTracker) await(ctx context.Context, record *TransactionRecord) (*TransactionRecord, error) {
+	commit, err := t.ledger.CommitStatus(ctx, record.Channel, record.TxID)
+	if err != nil {
+		record.Error = err.Error()
+		t.save(record)
+		return record, err
+	}
+
+	record.State = TxFailed
+	if commit.Status == fabric.StatusValid {
+		record.State = TxCommitted
+	}
+	record.ValidationCode = commit.Status
+	record.BlockNumber = commit.BlockNumber
+	record.Error = ""
+	record.CompletedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	t.save(record)
+	return record, nil
+}
+
+// awaitInBackground waits for a pending transaction to commit unless something already does
+func (t *transactionTracker) awaitInBackground(record *TransactionRecord) {
+	t.mu.Lock()
+	if t.waiting[record.TxID] {
+		t.mu.Unlock()
+		return
+	}
+	t.waiting[record.TxID] = true
+	t.mu.Unlock()
+
+	waiting := *record
+	go func() {
+		defer func() {
+			t.mu.Lock()
+			delete(t.waiting, waiting.TxID)
+			t.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), commitWaitTimeout)
+		defer cancel()
+		t.await(ctx, &waiting)
+	}()
+}
+
+// get returns the record of txID. A pending one is waited for again in the background, which
+// picks up transactions whose wait failed or was cut short by a restart.
+func (t *transactionTracker) get(txID string) (*TransactionRecord, bool, error) {
+	var record TransactionRecord
+	found, err := t.store.Get(transactionsBucket, txID, &record)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	if record.State == TxPending {
+		t.awaitInBackground(&record)
+	}
+	return &record, true, nil
+}
+
+// save stores a record. The transaction is on its way whether or not this works, so a failure is
+// logged rather than returned.
+func (t *transactionTracker) save(record *TransactionRecord) {
+	err := t.store.Put(transactionsBucket, record.TxID, record)
+	if err != nil {
+		fmt.Printf("Failed to record transaction %s: %v\n", record.TxID, err)
+	}
+}
+
+func getTransaction(transactions *transactionTracker, w http.ResponseWriter, r *http.Request) {
+	txID := r.PathValue("txID")
+	record, found, err := transactions.get(txID)
+	if err != nil {
+		writeAPIError(w, r, http.StatusInternalServerError, codeStoreFailure, "Failed to read transaction "+txID+": "+err.Error(), nil)
+		return
+	}
+	if !found {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Transaction "+txID+" was not submitted through this gateway", nil)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, record)
+}
+
+// response is the TransactionResponse of a write that submitted the transaction
+func (t *TransactionRecord) response() TransactionResponse {
+	return TransactionResponse{
+		HospitalID:  t.HospitalID,
+		PolicyID:    t.PolicyID,
+		Chaincode:   t.Chaincode,
+		Channel:     t.Channel,
+		TxID:        t.TxID,
+		State:       t.State,
+		Status:      t.ValidationCode,
+		BlockNumber: t.BlockNumber,
+	}
+}
+
+// transactionPath is the /v1 path of a transaction
+func transactionPath(txID string) string {
+	return "/v1/transactions/" + txID
+}
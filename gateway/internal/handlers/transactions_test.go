@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gateway/internal/fabric"
+)
+
+// pendingLedger is a FakeLedger whose commits are not seen while pending is set
+type pendingLedger struct {
+	*fabric.FakeLedger
+	mu      sync.Mutex
+	pending bool
+}
+
+func (l *pendingLedger) CommitStatus(ctx context.Context, channel string, txID string) (*fabric.Commit, error) {
+	l.mu.Lock()
+	pending := l.pending
+	l.mu.Unlock()
+	if pending {
+		return nil, errors.New("commit status not available yet")
+	}
+	return l.FakeLedger.CommitStatus(ctx, channel, txID)
+}
+
+func (l *pendingLedger) setPending(pending bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = pending
+}
+
+// transactionOf reads GET /v1/transactions/{txID}
+func transactionOf(t *testing.T, mux http.Handler, txID string) TransactionRecord {
+	t.Helper()
+
+	response := serve(mux, http.MethodGet, transactionPath(txID), "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("GET transaction %s: %d %s", txID, response.Code, response.Body)
+	}
+	var record TransactionRecord
+	if err := json.Unmarshal(response.Body.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// awaitState polls the transaction until its background wait sets state
+func awaitState(t *testing.T, mux http.Handler, txID string, state string) TransactionRecord {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		record := transactionOf(t, mux, txID)
+		if record.State == state {
+			return record
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("transaction %s stayed %s, expected %s", txID, record.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTransactionCommitNotSeen(t *testing.T) {
+	ledger := &pendingLedger{FakeLedger: fabric.NewFakeLedger(), pending: true}
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	mux, _ := newTestAPI(t, ledger)
+
+	response := serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody)
+	apiErr := apiErrorOf(t, response)
+	if response.Code != http.StatusGatewayTimeout || apiErr.Code != codeCommitUnknown || response.Header().Get("Location") != transactionPath("fake-tx-1") {
+		t.Fatalf("expected 504 %s pointing at the transaction, got %d %+v %v", codeCommitUnknown, response.Code, apiErr, response.Header())
+	}
+	record := transactionOf(t, mux, "fake-tx-1")
+	if record.State != TxPending || record.Error == "" || record.PolicyID != "region1:HP1:pc1" || record.Function != "CreateAsset" || record.SubmittedAt == "" {
+		t.Fatalf("unexpected pending record %+v", record)
+	}
+
+	// once the commit can be seen, reading the record waits for it again
+	ledger.setPending(false)
+	record = awaitState(t, mux, "fake-tx-1", TxCommitted)
+	if record.ValidationCode != fabric.StatusValid || record.BlockNumber != 1 || record.Error != "" || record.CompletedAt == "" {
+		t.Errorf("unexpected committed record %+v", record)
+	}
+}
+
+func TestTransactionRespondAsync(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	mux, _ := newTestAPI(t, ledger)
+
+	request := httptest.NewRequest(http.MethodPost, "/v1/hospitals/HP1/policies", strings.NewReader(createBody))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Prefer", "wait=10, respond-async")
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, request)
+	if response.Code != http.StatusAccepted || response.Header().Get("Preference-Applied") != "respond-async" || response.Header().Get("Location") != transactionPath("fake-tx-1") {
+		t.Fatalf("expected 202 pointing at the transaction, got %d %v", response.Code, response.Header())
+	}
+	var accepted TransactionResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &accepted); err != nil || accepted.State != TxPending || accepted.TxID != "fake-tx-1" {
+		t.Fatalf("expected the pending transaction, got %s", response.Body)
+	}
+	awaitState(t, mux, "fake-tx-1", TxCommitted)
+}
+
+func TestTransactionFailedValidation(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) {
+		return nil, &fabric.CommitError{Status: "MVCC_READ_CONFLICT"}
+	})
+	mux, _ := newTestAPI(t, ledger)
+
+	response := serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies", createBody)
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusConflict || apiErr.Code != codeTxInvalidated {
+		t.Fatalf("expected 409 %s, got %d %+v", codeTxInvalidated, response.Code, apiErr)
+	}
+	if record := transactionOf(t, mux, "fake-tx-1"); record.State != TxFailed || record.ValidationCode != "MVCC_READ_CONFLICT" {
+		t.Errorf("unexpected failed record %+v", record)
+	}
+
+	response = serve(mux, http.MethodGet, transactionPath("unknown"), "")
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusNotFound || apiErr.Code != codeNotFound {
+		t.Errorf("expected 404 for a transaction not submitted here, got %d %+v", response.Code, apiErr)
+	}
+}
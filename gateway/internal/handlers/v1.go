@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+	"gateway/internal/store"
+)
+
+// Route is one endpoint of the /v1 REST API. Pattern uses net/http wildcards, e.g.
+// "/v1/hospitals/{hospitalID}". The server registers the routes and the OpenAPI document is generated
+// from the same table, so the two cannot drift apart.
+type Route struct {
+	Method      string
+	Pattern     string
+	OperationID string
+	Summary     string
+	// Request is a value of the JSON request body's type, nil when the route takes no body
+	Request interface{}
+	// Status is the status of a successful response, 200 when zero
+	Status int
+	// Response is a value of the success body's type, from which its schema is generated
+	Response interface{}
+	// HTML reports whether the route also renders text/html for browsers
+	HTML bool
+	// Errors are the statuses the route answers with the error envelope, besides 405 and 406 and,
+	// for routes with a Request, 400 and 415
+	Errors []int
+	// Submits reports whether the route submits a transaction, which makes it take an
+	// Idempotency-Key and Prefer: respond-async
+	Submits bool
+	// Routed reports whether the route finds a hospital's chaincode with a Router, which makes it
+	// take and report the X-Routing-Strategy header
+	Routed bool
+
+	handle func(w http.ResponseWriter, r *http.Request, media string)
+}
+
+// Hospital is an entry of the hospital index: the regional chaincode and channel serving a hospital.
+// An empty Channel means the ledger's default channel.
+type Hospital struct {
+	HospitalID string `json:"hospitalID"`
+	Chaincode  string `json:"chaincode"`
+	Channel    string `json:"channel,omitempty"`
+}
+
+// HospitalList is the body of GET /v1/hospitals
+type HospitalList struct {
+	Hospitals []Hospital `json:"hospitals"`
+}
+
+// PolicyResponse is the body of GET /v1/hospitals/{hospitalID}/policies/{policyID}. Chaincode and
+// Channel are those the policy was read through: the regional chaincode, or globalcc under the
+// globalcc routing strategy.
+type PolicyResponse struct {
+	HospitalID string               `json:"hospitalID"`
+	Chaincode  string               `json:"chaincode"`
+	Channel    string               `json:"channel,omitempty"`
+	Policy     *types.RegionalAsset `json:"policy"`
+}
+
+// writeErrors are the error statuses of the routes that submit transactions
+var writeErrors = []int{http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusGone,
+	http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// V1Routes returns the /v1 endpoints, reading policies through ledger and keeping submitted
+// transactions and idempotency keys in db. routing finds the regional chaincode serving a hospital.
+func V1Routes(ledger fabric.Ledger, db *store.Store, routing *Routing) []Route {
+	transactions := newTransactionTracker(ledger, db)
+	routes := []Route{
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/v1/hospitals",
+			OperationID: "listHospitals",
+			Routed:      true,
+			Summary:     "List the hospitals of the index and the regional chaincode serving each",
+			Response:    HospitalList{},
+			HTML:        true,
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				listHospitals(routing, w, r, media)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/v1/hospitals/{hospitalID}",
+			OperationID: "getHospital",
+			Routed:      true,
+			Summary:     "Get the regional chaincode and channel serving a hospital",
+			Response:    Hospital{},
+			HTML:        true,
+			Errors:      []int{http.StatusNotFound},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				getHospital(routing, w, r, media)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/v1/hospitals/{hospitalID}/policies/{policyID}",
+			OperationID: "getPolicy",
+			Routed:      true,
+			Summary:     "Read a policy from the regional chaincode serving the hospital",
+			Response:    PolicyResponse{},
+			HTML:        true,
+			Errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusGone,
+				http.StatusUnprocessableEntity, http.StatusBadGateway, http.StatusServiceUnavailable},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				getPolicy(routing, w, r, media)
+			},
+		},
+		{
+			Method:      http.MethodPost,
+			Pattern:     "/v1/hospitals/{hospitalID}/policies",
+			OperationID: "createPolicy",
+			Routed:      true,
+			Summary:     "Create a policy under an ID allocated by globalcc, allocating one when none is given, and wait for the transaction to commit",
+			Request:     PolicyWrite{},
+			Status:      http.StatusCreated,
+			Response:    TransactionResponse{},
+			Errors:      writeErrors,
+			Submits:     true,
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				createPolicy(routing, transactions, w, r)
+			},
+		},
+		{
+			Method:      http.MethodPut,
+			Pattern:     "/v1/hospitals/{hospitalID}/policies/{policyID}",
+			OperationID: "updatePolicy",
+			Routed:      true,
+			Summary:     "Replace a policy's roles, grant and private fields, expecting its current version",
+			Request:     PolicyWrite{},
+			Response:    TransactionResponse{},
+			Errors:      writeErrors,
+			Submits:     true,
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				updatePolicy(routing, transactions, w, r)
+			},
+		},
+		{
+			Method:      http.MethodPatch,
+			Pattern:     "/v1/hospitals/{hospitalID}/policies/{policyID}",
+			OperationID: "transferPolicy",
+			Routed:      true,
+			Summary:     "Transfer a policy to a new owner, expecting its current version",
+			Request:     PolicyTransfer{},
+			Response:    TransactionResponse{},
+			Errors:      writeErrors,
+			Submits:     true,
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				transferPolicy(routing, transactions, w, r)
+			},
+		},
+		{
+			Method:      http.MethodDelete,
+			Pattern:     "/v1/hospitals/{hospitalID}/policies/{policyID}",
+			OperationID: "deletePolicy",
+			Routed:      true,
+			Summary:     "Delete a policy",
+			Response:    TransactionResponse{},
+			Errors:      writeErrors,
+			Submits:     true,
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				deletePolicy(routing, transactions, w, r)
+			},
+		},
+		{
+			Method:      http.MethodGet,
+			Pattern:     "/v1/transactions/{txID}",
+			OperationID: "getTransaction",
+			Summary:     "Get the state of a transaction submitted through this gateway: pending, committed or failed",
+			Response:    TransactionRecord{},
+			Errors:      []int{http.StatusNotFound, http.StatusInternalServerError},
+			handle: func(w http.ResponseWriter, r *http.Request, media string) {
+				getTransaction(transactions, w, r)
+			},
+		},
+	}
+	keys := newIdempotencyKeys(db, transactions)
+	for i := range routes {
+		if routes[i].Submits {
+			routes[i].handle = keys.wrap(routes[i].handle)
+		}
+	}
+
+	var document []byte
+	routes = append(routes, Route{
+		Method:      http.MethodGet,
+		Pattern:     "/v1/openapi.json",
+		OperationID: "getOpenAPI",
+		Summary:     "Get this OpenAPI document",
+		Response:    map[string]interface{}{},
+		handle: func(w http.ResponseWriter, r *http.Request, media string) {
+			w.Header().Set("Content-Type", mediaJSON)
+			w.Write(document)
+		},
+	})
+	// the document describes its own route too
+	var err error
+	document, err = json.MarshalIndent(openAPIDocument(routes), "", "  ")
+	if err != nil {
+		panic("failed to generate the OpenAPI document: " + err.Error())
+	}
+	return routes
+}
+
+// RegisterV1 registers the /v1 endpoints on mux. Every response carries a request ID, and every
+// failure, including unknown paths, uses the JSON error envelope.
+func RegisterV1(mux *http.ServeMux, ledger fabric.Ledger, db *store.Store, routing *Routing) {
+	registerRoutes(mux, "/v1/", V1Routes(ledger, db, routing), WithRequestID)
+}
+
+// registerRoutes registers routes on mux, wrapped in wrap, and answers every other path under
+// prefix with a 404 envelope
+func registerRoutes(mux *http.ServeMux, prefix string, routes []Route, wrap func(http.Handler) http.Handler) {
+	byPattern := make(map[string]map[string]Route)
+	var patterns []string
+	for _, route := range routes {
+		if byPattern[route.Pattern] == nil {
+			byPattern[route.Pattern] = make(map[string]Route)
+			patterns = append(patterns, route.Pattern)
+		}
+		byPattern[route.Pattern][route.Method] = route
+	}
+
+	for _, pattern := range patterns {
+		mux.Handle(pattern, wrap(serveRoutes(byPattern[pattern])))
+	}
+	mux.Handle(prefix, wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "No endpoint at "+r.URL.Path, nil)
+	})))
+}
+
+// serveRoutes dispatches the requests for one pattern by method and negotiates the response's media type
+func serveRoutes(methods map[string]Route) http.Handler {
+	allowed := make([]string, 0, len(methods)+1)
+	for method := range methods {
+		allowed = append(allowed, method)
+		if method == http.MethodGet {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	sort.Strings(allowed)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if method == http.MethodHead {
+			method = http.MethodGet
+		}
+		route, ok := methods[method]
+		if !ok {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path, nil)
+			return
+		}
+
+		offers := []string{mediaJSON}
+		if route.HTML {
+			offers = append(offers, mediaHTML)
+		}
+		w.Header().Add("Vary", "Accept")
+		media := negotiate(r, offers...)
+		if media == "" {
+			writeAPIError(w, r, http.StatusNotAcceptable, codeNotAcceptable, "This endpoint produces "+strings.Join(offers, " or "), nil)
+			return
+		}
+		route.handle(w, r, media)
+	})
+}
+
+func listHospitals(routing *Routing, w http.ResponseWriter, r *http.Request, media string) {
+	router, ok := routing.choose(w, r)
+	if !ok {
+		return
+	}
+	hospitals, err := router.Hospitals(r.Context())
+	if err != nil {
+		writeRouteError(w, r, "", err)
+		return
+	}
+
+	writeResource(w, media, HospitalList{Hospitals: hospitals}, hospitalListView)
+}
+
+func getHospital(routing *Routing, w http.ResponseWriter, r *http.Request, media string) {
+	hospitalID := r.PathValue("hospitalID")
+	router, ok := routing.choose(w, r)
+	if !ok {
+		return
+	}
+	route, err := router.Resolve(r.Context(), hospitalID)
+	if err != nil {
+		writeRouteError(w, r, hospitalID, err)
+		return
+	}
+
+	writeResource(w, media, Hospital{HospitalID: hospitalID, Chaincode: route.Chaincode, Channel: route.Channel}, hospitalView)
+}
+
+func getPolicy(routing *Routing, w http.ResponseWriter, r *http.Request, media string) {
+	hospitalID := r.PathValue("hospitalID")
+	policyID := r.PathValue("policyID")
+	router, ok := routing.choose(w, r)
+	if !ok {
+		return
+	}
+
+	route, result, err := router.ReadPolicy(r.Context(), hospitalID, policyID)
+	var unavailable *hospitalUnavailableError
+	if errors.Is(err, errUnknownHospital) || errors.As(err, &unavailable) {
+		writeRouteError(w, r, hospitalID, err)
+		return
+	}
+	if err != nil {
+		writeAPILedgerError(w, r, policyID, err)
+		return
+	}
+	policy, err := types.DecodeRegionalAsset(result)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadGateway, codeInvalidLedgerReply, "Failed to parse policy "+policyID+": "+err.Error(), nil)
+		return
+	}
+
+	writeResource(w, media, PolicyResponse{HospitalID: hospitalID, Chaincode: route.Chaincode, Channel: route.Channel, Policy: policy}, policyView)
+}
+
+// writeResource writes body as JSON, or rendered with view for text/html
+func writeResource(w http.ResponseWriter, media string, body interface{}, view *template.Template) {
+	if media == mediaHTML {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		view.Execute(w, body)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// writeJSON writes body as JSON with status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", mediaJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// policyPath is the /v1 path of a policy
+func policyPath(hospitalID string, policyID string) string {
+	return "/v1/hospitals/" + url.PathEscape(hospitalID) + "/policies/" + url.PathEscape(policyID)
+}
+
+// HTML views of the /v1 resources for browsers
+var (
+	hospitalListView = template.Must(template.New("hospitals").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Hospitals</title></head><body>
+<h1>Hospitals</h1>
+<table>
+<tr><th>HospitalID</th><th>ChaincodeName</th><th>Channel</th></tr>
+{{range .Hospitals}}<tr><td><a href="/v1/hospitals/{{.HospitalID}}">{{.HospitalID}}</a></td><td>{{.Chaincode}}</td><td>{{.Channel}}</td></tr>
+{{end}}</table>
+</body></html>
+`))
+
+	hospitalView = template.Must(template.New("hospital").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Hospital {{.HospitalID}}</title></head><body>
+<h1>Hospital {{.HospitalID}}</h1>
+ChaincodeName: {{.Chaincode}}<br>
+Channel: {{.Channel}}<br>
+</body></html>
+`))
+
+	policyView = template.Must(template.New("policy").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Policy {{.Policy.ID}}</title></head><body>
+<h1>Policy {{.Policy.ID}}</h1>
+HospitalID: {{.HospitalID}}<br>ChaincodeName: {{.Chaincode}}<br>
+Version: {{.Policy.Version}}<br>
+Data URL: <a href="{{.Policy.Metadata}}">{{.Policy.Metadata}}</a><br>
+{{range .Policy.Attachments}}Attachment {{.ID}}: <a href="{{.URI}}">{{.URI}}</a>{{if .SHA256}} ({{.MediaType}}, {{.Size}} bytes, SHA-256 {{.SHA256}}){{end}}<br>
+{{end}}</body></html>
+`))
+)
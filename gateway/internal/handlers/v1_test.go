@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+	"gateway/internal/store"
+)
+
+// newTestAPI serves the /v1 API over ledger with HP1 on regionalCC1 and HP2 on regionalCC2 of
+// region2channel in the hospital index
+func newTestAPI(t *testing.T, ledger fabric.Ledger) (*http.ServeMux, *index.Index) {
+	t.Helper()
+
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "hospitals.csv")
+	err := os.WriteFile(indexPath, []byte("hospitalID,chaincodeName,channel\nHP1,regionalCC1,\nHP2,regionalCC2,region2channel\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hospitals, err := index.Open(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := store.Open(filepath.Join(dir, "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	routing, err := NewRouting(ledger, hospitals, RoutingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterV1(mux, ledger, db, routing)
+	mux.HandleFunc("/readPP/", ReadPPHandler())
+	return mux, hospitals
+}
+
+// serve sends a request with an optional JSON body to mux
+func serve(mux http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response := httptest.NewRecorder()
+	mux.ServeHTTP(response, request)
+	return response
+}
+
+// apiErrorOf decodes the error envelope of a failed /v1 response
+func apiErrorOf(t *testing.T, response *httptest.ResponseRecorder) APIError {
+	t.Helper()
+
+	var envelope errorEnvelope
+	if err := json.Unmarshal(response.Body.Bytes(), &envelope); err != nil || envelope.Error.Code == "" {
+		t.Fatalf("expected the error envelope, got %d %s", response.Code, response.Body)
+	}
+	return envelope.Error
+}
+
+// fakeReadAsset answers regional ReadAsset calls: pc1 exists, denied is refused and net fails to
+// reach the network
+func fakeReadAsset(args []string) ([]byte, error) {
+	switch args[0] {
+	case "region1:HP1:pc1":
+		return []byte(`{"schemaVersion":2,"ID":"region1:HP1:pc1","owner":"PATIENT 1","authRoles":["Org1MSP.DoctorReg1"],"grant":"R","metadata":"https://example.com","version":3}`), nil
+	case "denied":
+		return nil, &fabric.ChaincodeError{Message: `{"code":"ACCESS_DENIED","assetID":"denied","operation":"read","mspID":"Org1MSP","role":"DoctorReg2","reason":"role DoctorReg2 may not read"}`}
+	case "net":
+		return nil, errors.New("dial tcp 127.0.0.1:7051: connection refused")
+	}
+	return nil, &fabric.ChaincodeError{Message: "the asset " + args[0] + " does not exist"}
+}
+
+func TestGetPolicy(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC2", "ReadAsset", fakeReadAsset)
+	mux, _ := newTestAPI(t, ledger)
+
+	response := serve(mux, http.MethodGet, "/v1/hospitals/HP2/policies/region1:HP1:pc1", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", response.Code, response.Body)
+	}
+	var policy PolicyResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Chaincode != "regionalCC2" || policy.Channel != "region2channel" || policy.Policy.Version != 3 {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	calls := ledger.Calls()
+	if len(calls) != 1 || calls[0].Submit || calls[0].Channel != "region2channel" || calls[0].Function != "ReadAsset" {
+		t.Fatalf("unexpected ledger calls %+v", calls)
+	}
+}
+
+func TestGetPolicyErrors(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "ReadAsset", fakeReadAsset)
+	mux, _ := newTestAPI(t, ledger)
+
+	for _, test := range []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/v1/hospitals/HP1/policies/denied", http.StatusForbidden, codeAccessDenied},
+		{"/v1/hospitals/HP1/policies/missing", http.StatusNotFound, codeNotFound},
+		{"/v1/hospitals/HP1/policies/net", http.StatusServiceUnavailable, codeLedgerUnavailable},
+		{"/v1/hospitals/HP9/policies/region1:HP9:pc1", http.StatusNotFound, codeNotFound},
+	} {
+		response := serve(mux, http.MethodGet, test.path, "")
+		apiErr := apiErrorOf(t, response)
+		if response.Code != test.status || apiErr.Code != test.code || apiErr.RequestID == "" {
+			t.Errorf("GET %s: expected %d %s, got %d %+v", test.path, test.status, test.code, response.Code, apiErr)
+		}
+	}
+}
+
+func TestGetPolicyThroughGlobalCC(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("globalCC", "ReadRegionalAsset", func(args []string) ([]byte, error) {
+		if args[1] == "HP9" {
+			return nil, &fabric.ChaincodeError{Message: "the asset hospitalID (HP9) does not exist"}
+		}
+		return fakeReadAsset(args)
+	})
+	mux, _ := newTestAPI(t, ledger)
+
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/v1/hospitals/HP1/policies/region1:HP1:pc1", http.StatusOK},
+		{"/v1/hospitals/HP1/policies/denied", http.StatusForbidden},
+		{"/v1/hospitals/HP9/policies/region1:HP9:pc1", http.StatusNotFound},
+	} {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		request.Header.Set(RoutingStrategyHeader, StrategyGlobalCC)
+		response := httptest.NewRecorder()
+		mux.ServeHTTP(response, request)
+		if response.Code != test.status {
+			t.Errorf("GET %s: expected %d, got %d %s", test.path, test.status, response.Code, response.Body)
+		}
+	}
+	for _, call := range ledger.Calls() {
+		if call.Submit || call.Function != "ReadRegionalAsset" {
+			t.Fatalf("a GET must be a single evaluated ReadRegionalAsset, got %+v", call)
+		}
+	}
+	if len(ledger.Calls()) != 3 {
+		t.Fatalf("expected one ledger call per GET, got %+v", ledger.Calls())
+	}
+}
+
+func TestReadPPRedirect(t *testing.T) {
+	mux, _ := newTestAPI(t, fabric.NewFakeLedger())
+
+	response := serve(mux, http.MethodGet, "/readPP/?hospitalID=HP1&policyID=region1:HP1:pc1", "")
+	if response.Code != http.StatusMovedPermanently || response.Header().Get("Location") != policyPath("HP1", "region1:HP1:pc1") {
+		t.Fatalf("expected a redirect to the /v1 policy, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+
+	response = serve(mux, http.MethodGet, "/readPP/?hospitalID=HP1", "")
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a policyID, got %d", response.Code)
+	}
+}
+
+func TestCreatePolicySubmits(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	mux, _ := newTestAPI(t, ledger)
+
+	response := serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies",
+		`{"policyID":"region1:HP1:pc1","authRoles":["Org1MSP.DoctorReg1"],"grant":"RW","owner":"PATIENT 1","metadata":"https://example.com","salt":"0123456789abcdef","patientKey":{"ownerRef":"patient-1","key":"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", response.Code, response.Body)
+	}
+	var transaction TransactionResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &transaction); err != nil {
+		t.Fatal(err)
+	}
+	if transaction.State != TxCommitted || transaction.Status != fabric.StatusValid || transaction.TxID == "" {
+		t.Fatalf("unexpected transaction %+v", transaction)
+	}
+
+	call := ledger.Calls()[0]
+	if !call.Submit || call.Function != "CreateAsset" || call.Args[0] != "region1:HP1:pc1" || call.Args[1] != `["Org1MSP.DoctorReg1"]` || call.Args[2] != "RW" {
+		t.Fatalf("unexpected ledger call %+v", call)
+	}
+	if strings.Contains(strings.Join(call.Args, " "), "PATIENT 1") || !strings.Contains(string(call.Transient[transientAssetKey]), "PATIENT 1") {
+		t.Fatalf("the owner must travel in the transient map only: %+v", call)
+	}
+}
+
+func TestWritesRequireValidKeys(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	mux, _ := newTestAPI(t, ledger)
+	create := `{"authRoles":["Org1MSP.DoctorReg1"],"grant":"RW","owner":"PATIENT 1","metadata":"https://example.com","salt":"0123456789abcdef"`
+	transfer := `{"owner":"PATIENT 2","salt":"fedcba9876543210","expectedVersion":3`
+	key := `"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="`
+
+	for _, test := range []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		message string
+	}{
+		{"a create without a patient key", http.MethodPost, "/v1/hospitals/HP1/policies", create + `}`, "patientKey is required"},
+		{"a patient key without an ownerRef", http.MethodPost, "/v1/hospitals/HP1/policies", create + `,"patientKey":{"key":` + key + `}}`, "ownerRef is required"},
+		{"an unknown custody", http.MethodPost, "/v1/hospitals/HP1/policies", create + `,"patientKey":{"ownerRef":"patient-1","key":` + key + `,"custody":"vault"}}`, "invalid patientKey.custody"},
+		{"an off-chain key left out", http.MethodPost, "/v1/hospitals/HP1/policies", create + `,"patientKey":{"ownerRef":"patient-1","custody":"offchain"}}`, "patientKey.key is required"},
+		{"a short patient key", http.MethodPost, "/v1/hospitals/HP1/policies", create + `,"patientKey":{"ownerRef":"patient-1","key":"c2hvcnQ="}}`, "AES key"},
+		{"a metadata key without an ID", http.MethodPost, "/v1/hospitals/HP1/policies", create + `,"patientKey":{"ownerRef":"patient-1"},"metadataKey":{"key":` + key + `}}`, "metadataKey.keyID is required"},
+		{"an update without a patient key", http.MethodPut, "/v1/hospitals/HP1/policies/region1:HP1:pc1", create + `,"expectedVersion":3}`, "patientKey is required"},
+		{"a transfer without a patient key", http.MethodPatch, "/v1/hospitals/HP1/policies/region1:HP1:pc1", transfer + `}`, "patientKey is required"},
+		{"a transfer key that is not base64", http.MethodPatch, "/v1/hospitals/HP1/policies/region1:HP1:pc1", transfer + `,"patientKey":{"ownerRef":"patient-2","key":"not base64!"}}`, "must be base64"},
+	} {
+		response := serve(mux, test.method, test.path, test.body)
+		if apiErr := apiErrorOf(t, response); response.Code != http.StatusBadRequest || !strings.Contains(apiErr.Message, test.message) {
+			t.Errorf("%s: expected 400 %q, got %d %s", test.name, test.message, response.Code, apiErr.Message)
+		}
+	}
+	// nothing, not even a policy ID allocation, was submitted
+	if calls := ledger.Calls(); len(calls) != 0 {
+		t.Fatalf("invalid writes reached the ledger: %+v", calls)
+	}
+}
+
+func TestCreatePolicyAllocatesID(t *testing.T) {
+	ledger := fabric.NewFakeLedger()
+	allocated := "region1:HP1:pc4"
+	ledger.Handle("globalCC", "AllocatePolicyID", func(args []string) ([]byte, error) { return []byte(allocated), nil })
+	ledger.Handle("regionalCC1", "CreateAsset", func(args []string) ([]byte, error) { return nil, nil })
+	mux, _ := newTestAPI(t, ledger)
+	body := `{"authRoles":["Org1MSP.DoctorReg1"],"grant":"R","owner":"PATIENT 1","metadata":"https://example.com","salt":"0123456789abcdef","patientKey":{"ownerRef":"patient-1","key":"MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`
+
+	response := serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies", body)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d %s", response.Code, response.Body)
+	}
+	var transaction TransactionResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &transaction); err != nil || transaction.PolicyID != allocated {
+		t.Fatalf("expected the allocated policy %s, got %s", allocated, response.Body)
+	}
+	calls := ledger.Calls()
+	if len(calls) != 2 || !calls[0].Submit || calls[0].Chaincode != "globalCC" || calls[0].Function != "AllocatePolicyID" || calls[0].Args[0] != "HP1" ||
+		calls[1].Function != "CreateAsset" || calls[1].Args[0] != allocated {
+		t.Fatalf("expected an allocation then CreateAsset of its ID, got %+v", calls)
+	}
+
+	response = serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies", strings.Replace(body, `"R"`, `"X"`, 1))
+	if response.Code != http.StatusBadRequest || len(ledger.Calls()) != 2 {
+		t.Fatalf("an invalid request must be refused before an ID is allocated, got %d %+v", response.Code, ledger.Calls())
+	}
+
+	allocated = "region1:HP2:pc1"
+	response = serve(mux, http.MethodPost, "/v1/hospitals/HP1/policies", body)
+	if apiErr := apiErrorOf(t, response); response.Code != http.StatusBadGateway || apiErr.Code != codeInvalidLedgerReply {
+		t.Fatalf("expected 502 %s for an ID allocated to another hospital, got %d %+v", codeInvalidLedgerReply, response.Code, apiErr)
+	}
+	if calls := ledger.Calls(); calls[len(calls)-1].Function != "AllocatePolicyID" {
+		t.Fatalf("CreateAsset was submitted with an unusable ID: %+v", calls)
+	}
+}
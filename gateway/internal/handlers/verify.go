@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+	"gateway/internal/index"
+)
+
+// verifyResponse is the body returned by /verify/
+type verifyResponse struct {
+	HospitalID   string               `json:"hospitalID"`
+	PolicyID     string               `json:"policyID"`
+	AttachmentID string               `json:"attachmentID"`
+	URL          string               `json:"url"`
+	Expected     *types.ContentDigest `json:"expected"`
+	Actual       *types.ContentDigest `json:"actual"`
+	Verified     bool                 `json:"verified"`
+	Mismatches   []string             `json:"mismatches"`
+}
+
+// VerifyHandler returns the handler for the /verify/ endpoint. It fetches one attachment of a policy
+// with client and compares its SHA-256, size and media type with the digest stored on chain. The
+// attachmentID parameter may be left out when the policy has a single attachment. A mismatch is
+// reported in the body with 200; the fetch failing is 502.
+func VerifyHandler(ledger fabric.Ledger, hospitals *index.Index, client *http.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verify(ledger, hospitals, client, w, r)
+	}
+}
+
+func verify(ledger fabric.Ledger, hospitals *index.Index, client *http.Client, w http.ResponseWriter, r *http.Request) {
+	// Parse query parameters
+	hospitalID := r.URL.Query().Get("hospitalID")
+	policyID := r.URL.Query().Get("policyID")
+	attachmentID := r.URL.Query().Get("attachmentID")
+
+	route, err := getChaincodeName(hospitals, hospitalID)
+	if err != nil {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Hospital "+hospitalID+" is not in the hospital index", nil)
+		return
+	}
+
+	result, err := ledger.Evaluate(r.Context(), route.Channel, route.Chaincode, "ReadAsset", policyID)
+	if err != nil {
+		writeAPILedgerError(w, r, policyID, err)
+		return
+	}
+	policy, err := types.DecodeRegionalAsset(result)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadGateway, codeInvalidLedgerReply, "Failed to parse policy "+policyID+": "+err.Error(), nil)
+		return
+	}
+	if attachmentID == "" {
+		if len(policy.Attachments) != 1 {
+			writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("attachmentID is required: policy %s has %d attachments", policyID, len(policy.Attachments)), nil)
+			return
+		}
+		attachmentID = policy.Attachments[0].ID
+	}
+	index := policy.FindAttachment(attachmentID)
+	if index < 0 {
+		writeAPIError(w, r, http.StatusNotFound, codeNotFound, "Policy "+policyID+" has no attachment "+attachmentID, nil)
+		return
+	}
+	attachment := policy.Attachments[index]
+	expected := attachment.Digest()
+	if expected == nil {
+		writeAPIError(w, r, http.StatusUnprocessableEntity, codeNoDigest, "Attachment "+attachmentID+" of policy "+policyID+" has no digest to verify against", nil)
+		return
+	}
+
+	actual, err := fetchDigest(r, client, attachment.URI, expected.Size)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadGateway, codeDocumentUnavailable, "Failed to fetch document: "+err.Error(), nil)
+		return
+	}
+
+	response := verifyResponse{
+		HospitalID:   hospitalID,
+		PolicyID:     policyID,
+		AttachmentID: attachmentID,
+		URL:          attachment.URI,
+		Expected:     expected,
+		Actual:       actual,
+		Mismatches:   compareDigests(expected, actual),
+	}
+	response.Verified = len(response.Mismatches) == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// fetchDigest downloads documentURL and returns its digest. It reads at most one byte more than
+// expectedSize, which is enough to tell that a larger document does not match.
+func fetchDigest(r *http.Request, client *http.Client, documentURL string, expectedSize int64) (*types.ContentDigest, error) {
+	parsed, err := url.Parse(documentURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, fmt.Errorf("%q is not an http or https URL", documentURL)
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), http.MethodGet, documentURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", documentURL, response.Status)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.LimitReader(response.Body, expectedSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+	return &types.ContentDigest{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size, MediaType: mediaType}, nil
+}
+
+// compareDigests lists the fields of actual that differ from expected. Media types are compared
+// without parameters such as charset.
+func compareDigests(expected *types.ContentDigest, actual *types.ContentDigest) []string {
+	mismatches := []string{}
+	if actual.Size != expected.Size {
+		mismatches = append(mismatches, "size")
+	}
+	if actual.SHA256 != expected.SHA256 {
+		mismatches = append(mismatches, "sha256")
+	}
+	expectedType, _, _ := mime.ParseMediaType(expected.MediaType)
+	if actual.MediaType != expectedType {
+		mismatches = append(mismatches, "mediaType")
+	}
+	return mismatches
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gateway/internal/fabric"
+)
+
+func TestVerify(t *testing.T) {
+	document := []byte("%PDF-1.7 policy document")
+	sum := sha256.Sum256(document)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/policy.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(document)
+		case "/tampered.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.7 tampered document"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer storage.Close()
+
+	attachment := func(id string, path string) string {
+		return `{"id":"` + id + `","uri":"` + storage.URL + path + `","sha256":"` + hex.EncodeToString(sum[:]) + `","mediaType":"application/pdf","size":` + strconv.Itoa(len(document)) + `,"createdAt":"2024-01-01T00:00:00Z"}`
+	}
+	ledger := fabric.NewFakeLedger()
+	ledger.Handle("regionalCC1", "ReadAsset", func(args []string) ([]byte, error) {
+		if args[0] != "pc1" {
+			return fakeReadAsset(args)
+		}
+		return []byte(`{"schemaVersion":2,"ID":"pc1","owner":"PATIENT 1","authRoles":[],"grant":"R","metadata":"` + storage.URL + `/policy.pdf","attachments":[` +
+			attachment("policy", "/policy.pdf") + `,` + attachment("tampered", "/tampered.pdf") + `,` + attachment("gone", "/gone.pdf") + `],"version":1}`), nil
+	})
+	_, hospitals := newTestAPI(t, ledger)
+	handler := VerifyHandler(ledger, hospitals, storage.Client())
+
+	for _, test := range []struct {
+		attachmentID string
+		verified     bool
+		mismatches   string
+	}{
+		{"policy", true, ""},
+		{"tampered", false, "size,sha256"},
+	} {
+		response := serve(handler, http.MethodGet, "/verify/?hospitalID=HP1&policyID=pc1&attachmentID="+test.attachmentID, "")
+		if response.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", test.attachmentID, response.Code, response.Body)
+		}
+		var result verifyResponse
+		if err := json.Unmarshal(response.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Verified != test.verified || strings.Join(result.Mismatches, ",") != test.mismatches || result.Actual.MediaType != "application/pdf" {
+			t.Errorf("%s: unexpected result %s", test.attachmentID, response.Body)
+		}
+	}
+
+	for _, test := range []struct {
+		query  string
+		status int
+		code   string
+	}{
+		{"hospitalID=HP1&policyID=pc1&attachmentID=gone", http.StatusBadGateway, codeDocumentUnavailable},
+		{"hospitalID=HP1&policyID=pc1&attachmentID=missing", http.StatusNotFound, codeNotFound},
+		{"hospitalID=HP1&policyID=pc1", http.StatusBadRequest, codeBadRequest},
+		{"hospitalID=HP1&policyID=denied", http.StatusForbidden, codeAccessDenied},
+		{"hospitalID=HP9&policyID=pc1", http.StatusNotFound, codeNotFound},
+	} {
+		response := serve(handler, http.MethodGet, "/verify/?"+test.query, "")
+		apiErr := apiErrorOf(t, response)
+		if response.Code != test.status || apiErr.Code != test.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", test.query, test.status, test.code, response.Code, apiErr)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"crosschain/types"
+	"gateway/internal/fabric"
+)
+
+// Transient map keys of the regional chaincode's write transactions
+const (
+	transientAssetKey    = "asset_properties" // JSON assetProperties
+	transientPatientKey  = "patient_key"      // JSON PatientKey
+	transientMetadataKey = "metadata_key"     // JSON DataKey
+)
+
+// Custodies of a patient key, as in the regional chaincode
+const (
+	custodyCollection = "collection" // held in the region's private data collection
+	custodyOffChain   = "offchain"   // held by the client only
+)
+
+// minSaltLength is the regional chaincode's MinSaltLength
+const minSaltLength = 16
+
+// maxBodySize bounds the JSON body of a /v1 write
+const maxBodySize = 1 << 20
+
+// Grants of a policy, as in the regional chaincode
+var grants = map[string]bool{"R": true, "W": true, "RW": true}
+
+// PatientKey is the per-patient key the regional chaincode seals Owner, Metadata and the attachment
+// URIs under. OwnerRef is a pseudonymous reference to the patient; Key is a base64 AES key, which
+// may be left out when the collection already holds the patient's key.
+type PatientKey struct {
+	OwnerRef string `json:"ownerRef"`
+	Key      string `json:"key,omitempty"`
+	Custody  string `json:"custody,omitempty"`
+}
+
+// DataKey is a named base64 AES key the regional chaincode encrypts Metadata under
+type DataKey struct {
+	KeyID string `json:"keyID"`
+	Key   string `json:"key"`
+}
+
+// PolicyWrite is the body of POST /v1/hospitals/{hospitalID}/policies and of PUT on a policy.
+// On POST, PolicyID is one the hospital allocated with globalcc's AllocatePolicyID, or empty for the
+// gateway to allocate one; on PUT it is taken from the path. Attachments are only
+// taken on POST and ExpectedVersion, the version the caller read, only on PUT, where it is required.
+// Owner, Metadata, Salt, Attachments and the keys travel in the transient map, never in the block.
+type PolicyWrite struct {
+	PolicyID        string               `json:"policyID,omitempty"`
+	AuthRoles       []string             `json:"authRoles"`
+	Grant           string               `json:"grant"`
+	Owner           string               `json:"owner"`
+	Metadata        string               `json:"metadata"`
+	Salt            string               `json:"salt"`
+	OwnerRef        string               `json:"ownerRef,omitempty"`
+	Document        *types.ContentDigest `json:"document,omitempty"`
+	Attachments     []types.Attachment   `json:"attachments,omitempty"`
+	ExpectedVersion *int                 `json:"expectedVersion,omitempty"`
+	PatientKey      *PatientKey          `json:"patientKey,omitempty"`
+	MetadataKey     *DataKey             `json:"metadataKey,omitempty"`
+}
+
+// PolicyTransfer is the body of PATCH on a policy, which transfers it to a new owner with a fresh
+// salt, keeping its metadata and attachments. ExpectedVersion is required.
+type PolicyTransfer struct {
+	Owner           string      `json:"owner"`
+	Salt            string      `json:"salt"`
+	OwnerRef        string      `json:"ownerRef,omitempty"`
+	ExpectedVersion *int        `json:"expectedVersion"`
+	PatientKey      *PatientKey `json:"patientKey,omitempty"`
+}
+
+// TransactionResponse is the body of a successful /v1 write: the policy and the transaction that
+// changed it. State is "committed", or "pending" for a 202; Status is the commit status, "VALID",
+// once committed. BlockNumber is left out when the ledger backend does not report it.
+type TransactionResponse struct {
+	HospitalID  string `json:"hospitalID"`
+	PolicyID    string `json:"policyID"`
+	Chaincode   string `json:"chaincode"`
+	Channel     string `json:"channel,omitempty"`
+	TxID        string `json:"txID"`
+	State       string `json:"state"`
+	Status      string `json:"status,omitempty"`
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+}
+
+// assetProperties is the regional chaincode's TransientAssetKey object
+type assetProperties struct {
+	Owner       string               `json:"owner"`
+	Metadata    string               `json:"metadata"`
+	Salt        string               `json:"salt"`
+	OwnerRef    string               `json:"ownerRef,omitempty"`
+	Document    *types.ContentDigest `json:"document,omitempty"`
+	Attachments []types.Attachment   `json:"attachments,omitempty"`
+}
+
+func createPolicy(routing *Routing, transactions *transactionTracker, w http.ResponseWriter, r *http.Request) {
+	hospitalID := r.PathValue("hospitalID")
+	var body PolicyWrite
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	err := body.validate(hospitalID, body.PolicyID, true)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+
+	transient, err := body.transient()
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+	if body.PolicyID == "" {
+		body.PolicyID, err = routing.registry.allocatePolicyID(r.Context(), hospitalID)
+		if err != nil {
+			writeRouteError(w, r, hospitalID, err)
+			return
+		}
+	}
+	authRoles, _ := json.Marshal(nonNil(body.AuthRoles))
+	submitPolicyTransaction(routing, transactions, w, r, http.StatusCreated, body.PolicyID, "CreateAsset", transient,
+		body.PolicyID, string(authRoles), body.Grant)
+}
+
+func updatePolicy(routing *Routing, transactions *transactionTracker, w http.ResponseWriter, r *http.Request) {
+	hospitalID := r.PathValue("hospitalID")
+	policyID := r.PathValue("policyID")
+	var body PolicyWrite
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if body.PolicyID != "" && body.PolicyID != policyID {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "policyID "+body.PolicyID+" does not match the path", nil)
+		return
+	}
+	err := body.validate(hospitalID, policyID, false)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+
+	transient, err := body.transient()
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+	authRoles, _ := json.Marshal(nonNil(body.AuthRoles))
+	submitPolicyTransaction(routing, transactions, w, r, http.StatusOK, policyID, "UpdateAsset", transient,
+		policyID, string(authRoles), body.Grant, strconv.Itoa(*body.ExpectedVersion))
+}
+
+func transferPolicy(routing *Routing, transactions *transactionTracker, w http.ResponseWriter, r *http.Request) {
+	policyID := r.PathValue("policyID")
+	var body PolicyTransfer
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if body.ExpectedVersion == nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "expectedVersion is required", nil)
+		return
+	}
+	if len(body.Salt) < minSaltLength {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("salt must be at least %d characters", minSaltLength), nil)
+		return
+	}
+	if err := body.PatientKey.validate(); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+	asset := types.RegionalAsset{ID: policyID, Owner: body.Owner, Version: *body.ExpectedVersion}
+	if err := asset.Validate(); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+
+	transient, err := transientMap(assetProperties{Owner: body.Owner, Salt: body.Salt, OwnerRef: body.OwnerRef}, body.PatientKey, nil)
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, err.Error(), nil)
+		return
+	}
+	submitPolicyTransaction(routing, transactions, w, r, http.StatusOK, policyID, "TransferAsset", transient,
+		policyID, strconv.Itoa(*body.ExpectedVersion))
+}
+
+func deletePolicy(routing *Routing, transactions *transactionTracker, w http.ResponseWriter, r *http.Request) {
+	policyID := r.PathValue("policyID")
+	submitPolicyTransaction(routing, transactions, w, r, http.StatusOK, policyID, "DeleteAsset", nil, policyID)
+}
+
+// submitPolicyTransaction submits function to the regional chaincode of the path's hospital and
+// answers with status and the committed transaction; a 201 points at the created policy. With
+// Prefer: respond-async it answers 202 as soon as the transaction is submitted, pointing at its
+// /v1/transactions status instead.
+func submitPolicyTransaction(routing *Routing, transactions *transactionTracker, w http.ResponseWriter, r *http.Request, status int, policyID string, function string, transient map[string][]byte, args ...string) {
+	hospitalID := r.PathValue("hospitalID")
+	router, ok := routing.choose(w, r)
+	if !ok {
+		return
+	}
+	route, err := router.Resolve(r.Context(), hospitalID)
+	if err != nil {
+		writeRouteError(w, r, hospitalID, err)
+		return
+	}
+
+	record, err := transactions.submit(r.Context(), &fabric.Transaction{
+		Channel:   route.Channel,
+		Chaincode: route.Chaincode,
+		Function:  function,
+		Args:      args,
+		Transient: transient,
+	}, TransactionRecord{HospitalID: hospitalID, PolicyID: policyID})
+	if err != nil {
+		writeAPILedgerError(w, r, policyID, err)
+		return
+	}
+	noteSubmitted(r.Context(), record.TxID, status)
+
+	w.Header().Add("Vary", "Prefer")
+	if preferAsync(r) {
+		transactions.awaitInBackground(record)
+		w.Header().Set("Preference-Applied", "respond-async")
+		w.Header().Set("Location", transactionPath(record.TxID))
+		writeJSON(w, http.StatusAccepted, record.response())
+		return
+	}
+
+	record, err = transactions.await(r.Context(), record)
+	if err != nil {
+		// the transaction may still commit, so it is left for GET /v1/transactions to report
+		transactions.awaitInBackground(record)
+		w.Header().Set("Location", transactionPath(record.TxID))
+		writeAPIError(w, r, http.StatusGatewayTimeout, codeCommitUnknown,
+			"Transaction "+record.TxID+" was submitted but its commit was not seen: "+err.Error(), record.response())
+		return
+	}
+	writeTransactionOutcome(w, r, status, record)
+}
+
+// writeTransactionOutcome answers a write with its committed transaction: status, pointing at the
+// created policy for a 201, or the ledger error of a transaction that failed validation
+func writeTransactionOutcome(w http.ResponseWriter, r *http.Request, status int, record *TransactionRecord) {
+	if record.State == TxFailed {
+		writeAPILedgerError(w, r, record.PolicyID, &fabric.CommitError{TxID: record.TxID, Status: record.ValidationCode})
+		return
+	}
+
+	if status == http.StatusCreated {
+		w.Header().Set("Location", policyPath(record.HospitalID, record.PolicyID))
+	}
+	writeJSON(w, status, record.response())
+}
+
+// preferAsync reports whether the request asks for Prefer: respond-async
+func preferAsync(r *http.Request) bool {
+	for _, header := range r.Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			token, _, _ := strings.Cut(preference, ";")
+			if strings.EqualFold(strings.TrimSpace(token), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate checks a create (or update) of policyID held by hospitalID against the RegionalAsset
+// schema, so that malformed requests are refused before anything is submitted. A create may leave
+// policyID empty for globalcc to allocate.
+func (p *PolicyWrite) validate(hospitalID string, policyID string, create bool) error {
+	if policyID == "" && !create {
+		return errors.New("policyID is required")
+	}
+	if create {
+		if policyID != "" {
+			_, allocatedTo, ok := types.ParsePolicyID(policyID)
+			if !ok {
+				return fmt.Errorf("policyID %s was not issued by globalcc's AllocatePolicyID", policyID)
+			}
+			if allocatedTo != hospitalID {
+				return fmt.Errorf("policyID %s was allocated to hospital %s, not %s", policyID, allocatedTo, hospitalID)
+			}
+		}
+		if p.ExpectedVersion != nil {
+			return errors.New("expectedVersion is only taken by updates")
+		}
+	} else {
+		if p.ExpectedVersion == nil {
+			return errors.New("expectedVersion is required")
+		}
+		if len(p.Attachments) > 0 {
+			return errors.New("attachments are only taken on create; updates keep the current ones")
+		}
+	}
+	if !grants[p.Grant] {
+		return fmt.Errorf("invalid grant %q, must be one of R, W, RW", p.Grant)
+	}
+	for _, authRole := range p.AuthRoles {
+		// as the regional chaincode, which only matches a role attribute issued by the MSP named
+		if mspID, role, found := strings.Cut(authRole, "."); !found || mspID == "" || role == "" {
+			return fmt.Errorf("invalid auth role %q, must be <MSPID>.<role>", authRole)
+		}
+	}
+	if len(p.Salt) < minSaltLength {
+		return fmt.Errorf("salt must be at least %d characters", minSaltLength)
+	}
+	if err := p.PatientKey.validate(); err != nil {
+		return err
+	}
+	if p.MetadataKey != nil {
+		if err := p.MetadataKey.validate(); err != nil {
+			return err
+		}
+	}
+	for _, attachment := range p.Attachments {
+		if attachment.ID == types.LegacyAttachmentID {
+			return fmt.Errorf("the attachment ID %s is reserved for the metadata URL", types.LegacyAttachmentID)
+		}
+		if attachment.SHA256 == "" {
+			return fmt.Errorf("attachment %s: sha256, mediaType and size are required", attachment.ID)
+		}
+	}
+
+	asset := types.RegionalAsset{
+		SchemaVersion: types.RegionalAssetSchemaVersion,
+		ID:            policyID,
+		Owner:         p.Owner,
+		AuthRoles:     p.AuthRoles,
+		Grant:         p.Grant,
+		Metadata:      p.Metadata,
+		Document:      p.Document,
+		Attachments:   p.Attachments,
+	}
+	if asset.ID == "" {
+		// stands in for the ID globalcc allocates once the rest of the request is known to be valid
+		asset.ID = types.NewPolicyID("", hospitalID, 1)
+	}
+	if p.ExpectedVersion != nil {
+		asset.Version = *p.ExpectedVersion
+	}
+	return asset.Validate()
+}
+
+// validate checks a patient key as the regional chaincode's writes do, which refuse to write
+// without one
+func (k *PatientKey) validate() error {
+	if k == nil {
+		return errors.New("patientKey is required")
+	}
+	if k.OwnerRef == "" {
+		return errors.New("patientKey.ownerRef is required")
+	}
+	switch k.Custody {
+	case "", custodyCollection:
+	case custodyOffChain:
+		if k.Key == "" {
+			return fmt.Errorf("patientKey.key is required with custody %s", custodyOffChain)
+		}
+	default:
+		return fmt.Errorf("invalid patientKey.custody %q, must be %s or %s", k.Custody, custodyCollection, custodyOffChain)
+	}
+	if k.Key == "" {
+		return nil
+	}
+	return validateAESKey("patientKey.key", k.Key)
+}
+
+// validate checks that a metadata key is named and is a base64 AES key
+func (k *DataKey) validate() error {
+	if k.KeyID == "" {
+		return errors.New("metadataKey.keyID is required")
+	}
+	return validateAESKey("metadataKey.key", k.Key)
+}
+
+// validateAESKey checks that the field is a base64 AES-128, AES-192 or AES-256 key
+func validateAESKey(field string, key string) error {
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("%s must be base64: %v", field, err)
+	}
+	switch len(rawKey) {
+	case 16, 24, 32:
+		return nil
+	}
+	return fmt.Errorf("%s must be a 16, 24 or 32 byte AES key, got %d bytes", field, len(rawKey))
+}
+
+// transient returns the transient map carrying the private fields and keys of a create or update
+func (p *PolicyWrite) transient() (map[string][]byte, error) {
+	properties := assetProperties{
+		Owner:       p.Owner,
+		Metadata:    p.Metadata,
+		Salt:        p.Salt,
+		OwnerRef:    p.OwnerRef,
+		Document:    p.Document,
+		Attachments: p.Attachments,
+	}
+	return transientMap(properties, p.PatientKey, p.MetadataKey)
+}
+
+// transientMap encodes the asset properties and the optional keys under the regional chaincode's keys
+func transientMap(properties assetProperties, patientKey *PatientKey, metadataKey *DataKey) (map[string][]byte, error) {
+	transient := make(map[string][]byte)
+	entries := map[string]interface{}{transientAssetKey: properties}
+	if patientKey != nil {
+		entries[transientPatientKey] = patientKey
+	}
+	if metadataKey != nil {
+		entries[transientMetadataKey] = metadataKey
+	}
+	for key, value := range entries {
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %v", key, err)
+		}
+		transient[key] = valueJSON
+	}
+	return transient, nil
+}
+
+// decodeBody strictly decodes the JSON request body into v, answering 415 or 400 when it cannot
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != mediaJSON {
+		writeAPIError(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "The request body must be "+mediaJSON, nil)
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "Failed to read the request body: "+err.Error(), nil)
+		return false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	if err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, codeBadRequest, "Invalid request body: "+err.Error(), nil)
+		return false
+	}
+	return true
+}
+
+// nonNil returns values, or an empty slice for nil so that it encodes as []
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
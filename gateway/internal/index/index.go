@@ -0,0 +1,350 @@
+// Package index is the gateway's hospital index: the regional chaincode and channel serving each
+// hospital, kept in hospital_index_table.csv. The table is loaded into memory and swapped as a
+// whole on reload, so a lookup never sees a half-read file, and edits are written back to the CSV.
+package index
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPath is the hospital index of the repository, relative to the test-network directory
+// the gateway runs from
+const DefaultPath = "../gateway/internal/index/hospital_index_table.csv"
+
+// header is the first row of the CSV
+var header = []string{"hospitalID", "chaincodeName", "channel"}
+
+// Entry routes a hospital to its regional chaincode. An empty Channel means the ledger's default channel.
+type Entry struct {
+	HospitalID string `json:"hospitalID"`
+	Chaincode  string `json:"chaincode"`
+	Channel    string `json:"channel,omitempty"`
+}
+
+// Validate checks the fields an entry must carry
+func (e *Entry) Validate() error {
+	if e.HospitalID == "" {
+		return errors.New("hospitalID is required")
+	}
+	if e.Chaincode == "" {
+		return fmt.Errorf("hospital %s: chaincode is required", e.HospitalID)
+	}
+	return nil
+}
+
+// Change is a hospital whose entry differs between two versions of the index, or between the
+// index and another source. From is nil for an added hospital and To for a removed one.
+type Change struct {
+	HospitalID string `json:"hospitalID"`
+	From       *Entry `json:"from,omitempty"`
+	To         *Entry `json:"to,omitempty"`
+}
+
+// Diff returns the changes from one set of entries to another, ordered by hospital ID
+func Diff(from []Entry, to []Entry) []Change {
+	before := byID(from)
+	after := byID(to)
+	changes := []Change{}
+	for id, entry := range before {
+		entry := entry
+		if other, ok := after[id]; !ok {
+			changes = append(changes, Change{HospitalID: id, From: &entry})
+		} else if other != entry {
+			changes = append(changes, Change{HospitalID: id, From: &entry, To: &other})
+		}
+	}
+	for id, entry := range after {
+		entry := entry
+		if _, ok := before[id]; !ok {
+			changes = append(changes, Change{HospitalID: id, To: &entry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].HospitalID < changes[j].HospitalID })
+	return changes
+}
+
+// table is one loaded version of the index
+type table struct {
+	entries  map[string]Entry
+	loadedAt time.Time
+	// modTime and size identify the version of the file the table was read from or written to
+	modTime time.Time
+	size    int64
+}
+
+// Index is the hospital index kept at a CSV path. It is safe for concurrent use.
+type Index struct {
+	path string
+	// mu guards current; writeMu serializes reloads and edits, which read and replace the file
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+	current *table
+}
+
+// Open loads the index kept at path. Unlike a reload, a file that cannot be read is an error here,
+// since there is no previous version to fall back to.
+func Open(path string) (*Index, error) {
+	current, err := readTable(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Index{path: path, current: current}, nil
+}
+
+// Path is the CSV file of the index
+func (i *Index) Path() string {
+	return i.path
+}
+
+// Lookup returns the entry of hospitalID
+func (i *Index) Lookup(hospitalID string) (Entry, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	entry, ok := i.current.entries[hospitalID]
+	return entry, ok
+}
+
+// Entries returns every entry, ordered by hospital ID
+func (i *Index) Entries() []Entry {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return sorted(i.current.entries)
+}
+
+// LoadedAt is when the current version of the index was loaded or last edited
+func (i *Index) LoadedAt() time.Time {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.current.loadedAt
+}
+
+// Reload reads the CSV again and swaps it in, returning what changed. When the file cannot be
+// read or is invalid, the current index is kept and the error returned.
+func (i *Index) Reload() ([]Change, error) {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	next, err := readTable(i.path)
+	if err != nil {
+		return nil, err
+	}
+	return i.swap(next), nil
+}
+
+// Modified reports whether the CSV changed since the index last read or wrote it
+func (i *Index) Modified() (bool, error) {
+	info, err := os.Stat(i.path)
+	if err != nil {
+		return false, err
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return !info.ModTime().Equal(i.current.modTime) || info.Size() != i.current.size, nil
+}
+
+// Watch reloads the index whenever the CSV changes, checking every interval until ctx is done.
+// onReload is called with the outcome of each reload.
+func (i *Index) Watch(ctx context.Context, interval time.Duration, onReload func(changes []Change, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modified, err := i.Modified()
+		if err != nil || !modified {
+			// a file being replaced may be missing for a moment; the next tick looks again
+			continue
+		}
+		onReload(i.Reload())
+	}
+}
+
+// Put adds or replaces the entry of a hospital and writes the index back to the CSV. It reports
+// whether the hospital is new.
+func (i *Index) Put(entry Entry) (bool, error) {
+	if err := entry.Validate(); err != nil {
+		return false, err
+	}
+	created := false
+	_, err := i.edit(func(entries map[string]Entry) {
+		_, exists := entries[entry.HospitalID]
+		created = !exists
+		entries[entry.HospitalID] = entry
+	})
+	return created, err
+}
+
+// Delete removes the entry of hospitalID and writes the index back to the CSV. It reports whether
+// there was one.
+func (i *Index) Delete(hospitalID string) (bool, error) {
+	changes, err := i.edit(func(entries map[string]Entry) {
+		delete(entries, hospitalID)
+	})
+	return len(changes) > 0, err
+}
+
+// Merge adds and replaces the given entries, keeping the hospitals they do not mention, and
+// writes the index back to the CSV when that changed anything
+func (i *Index) Merge(entries []Entry) ([]Change, error) {
+	for _, entry := range entries {
+		if err := entry.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return i.edit(func(current map[string]Entry) {
+		for _, entry := range entries {
+			current[entry.HospitalID] = entry
+		}
+	})
+}
+
+// edit applies change to a copy of the entries, writes the result to the CSV and swaps it in
+func (i *Index) edit(change func(entries map[string]Entry)) ([]Change, error) {
+	i.writeMu.Lock()
+	defer i.writeMu.Unlock()
+
+	i.mu.RLock()
+	entries := make(map[string]Entry, len(i.current.entries))
+	for id, entry := range i.current.entries {
+		entries[id] = entry
+	}
+	i.mu.RUnlock()
+
+	change(entries)
+	next := &table{entries: entries}
+	if len(Diff(i.Entries(), sorted(entries))) == 0 {
+		return nil, nil
+	}
+	err := writeTable(i.path, next)
+	if err != nil {
+		return nil, err
+	}
+	return i.swap(next), nil
+}
+
+// swap makes next the current table and returns how it differs from the previous one
+func (i *Index) swap(next *table) []Change {
+	next.loadedAt = time.Now()
+	i.mu.Lock()
+	previous := i.current
+	i.current = next
+	i.mu.Unlock()
+	return Diff(sorted(previous.entries), sorted(next.entries))
+}
+
+// readTable parses the CSV at path. The header row and the channel column are optional; a row
+// without a chaincode or a hospital listed twice makes the whole file invalid.
+func readTable(path string) (*table, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hospital index: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hospital index: %v", err)
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	current := &table{entries: make(map[string]Entry), modTime: info.ModTime(), size: info.Size(), loadedAt: time.Now()}
+	for row := 1; ; row++ {
+		line, err := reader.Read()
+		if err == io.EOF {
+			return current, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hospital index %s: %v", path, err)
+		}
+		if row == 1 && len(line) > 0 && line[0] == header[0] {
+			continue
+		}
+
+		entry := Entry{HospitalID: line[0]}
+		if len(line) >= 2 {
+			entry.Chaincode = line[1]
+		}
+		if len(line) >= 3 {
+			entry.Channel = line[2]
+		}
+		if err := entry.Validate(); err != nil {
+			return nil, fmt.Errorf("hospital index %s, row %d: %v", path, row, err)
+		}
+		if _, ok := current.entries[entry.HospitalID]; ok {
+			return nil, fmt.Errorf("hospital index %s, row %d: hospital %s is listed twice", path, row, entry.HospitalID)
+		}
+		current.entries[entry.HospitalID] = entry
+	}
+}
+
+// writeTable writes the entries of next to the CSV at path, ordered by hospital ID, replacing the
+// old file atomically, and records the new file's version on next
+func writeTable(path string, next *table) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write hospital index: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	writer := csv.NewWriter(temp)
+	writer.Write(header)
+	for _, entry := range sorted(next.entries) {
+		writer.Write([]string{entry.HospitalID, entry.Chaincode, entry.Channel})
+	}
+	writer.Flush()
+	err = writer.Error()
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write hospital index: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to write hospital index: %v", err)
+	}
+	next.modTime = info.ModTime()
+	next.size = info.Size()
+	return nil
+}
+
+func byID(entries []Entry) map[string]Entry {
+	ids := make(map[string]Entry, len(entries))
+	for _, entry := range entries {
+		ids[entry.HospitalID] = entry
+	}
+	return ids
+}
+
+func sorted(entries map[string]Entry) []Entry {
+	list := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].HospitalID < list[j].HospitalID })
+	return list
+}
@@ -0,0 +1,183 @@
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeIndex writes csv to a hospital index in a new directory and returns its path
+func writeIndex(t *testing.T, csv string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "hospitals.csv")
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// replace rewrites the index at path as an editor would, moving its modification time on so the
+// change is seen on file systems with a coarse clock
+func replace(t *testing.T, path string, csv string) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	index, err := Open(writeIndex(t, "hospitalID,chaincodeName,channel\nHP2,regionalCC2,region2channel\nHP1,regionalCC1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := index.Lookup("HP1"); !ok || entry != (Entry{HospitalID: "HP1", Chaincode: "regionalCC1"}) {
+		t.Errorf("expected HP1 without a channel, got %+v %v", entry, ok)
+	}
+	if entries := index.Entries(); len(entries) != 2 || entries[0].HospitalID != "HP1" || entries[1].Channel != "region2channel" {
+		t.Errorf("expected the entries ordered by hospital ID, got %+v", entries)
+	}
+
+	for name, csv := range map[string]string{
+		"a row without a chaincode": "HP1\n",
+		"a hospital listed twice":   "HP1,regionalCC1\nHP1,regionalCC2\n",
+		"an empty hospital ID":      ",regionalCC1\n",
+	} {
+		if _, err := Open(writeIndex(t, csv)); err == nil {
+			t.Errorf("%s: expected the index to be refused", name)
+		}
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Errorf("expected a missing index to be refused")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeIndex(t, "HP1,regionalCC1\nHP2,regionalCC2\n")
+	index, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modified, err := index.Modified(); err != nil || modified {
+		t.Fatalf("expected the index unmodified after opening, got %v %v", modified, err)
+	}
+
+	replace(t, path, "HP1,regionalCC1,region1channel\nHP3,regionalCC3\n")
+	if modified, err := index.Modified(); err != nil || !modified {
+		t.Fatalf("expected the rewritten index to be modified, got %v %v", modified, err)
+	}
+	changes, err := index.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changed []string
+	for _, change := range changes {
+		switch {
+		case change.From == nil:
+			changed = append(changed, "+"+change.HospitalID)
+		case change.To == nil:
+			changed = append(changed, "-"+change.HospitalID)
+		default:
+			changed = append(changed, "~"+change.HospitalID)
+		}
+	}
+	if strings.Join(changed, ",") != "~HP1,-HP2,+HP3" {
+		t.Errorf("unexpected changes %v", changed)
+	}
+	if _, ok := index.Lookup("HP2"); ok {
+		t.Errorf("the removed HP2 is still routed")
+	}
+
+	// an invalid file leaves the loaded index in place
+	replace(t, path, "HP1,regionalCC1\nHP4\n")
+	if _, err := index.Reload(); err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Fatalf("expected the invalid row to be reported, got %v", err)
+	}
+	if entry, ok := index.Lookup("HP1"); !ok || entry.Channel != "region1channel" {
+		t.Errorf("expected the last valid index kept, got %+v %v", entry, ok)
+	}
+}
+
+func TestEditsWriteTheIndexBack(t *testing.T) {
+	path := writeIndex(t, "HP1,regionalCC1\n")
+	index, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if created, err := index.Put(Entry{HospitalID: "HP2", Chaincode: "regionalCC2", Channel: "region2channel"}); err != nil || !created {
+		t.Fatalf("expected HP2 to be added, got %v %v", created, err)
+	}
+	if created, err := index.Put(Entry{HospitalID: "HP1", Chaincode: "regionalCC3"}); err != nil || created {
+		t.Fatalf("expected HP1 to be replaced, got %v %v", created, err)
+	}
+	if _, err := index.Put(Entry{HospitalID: "HP5"}); err == nil {
+		t.Errorf("expected an entry without a chaincode to be refused")
+	}
+	if deleted, err := index.Delete("HP9"); err != nil || deleted {
+		t.Errorf("expected nothing to delete for HP9, got %v %v", deleted, err)
+	}
+	changes, err := index.Merge([]Entry{{HospitalID: "HP2", Chaincode: "regionalCC2", Channel: "region2channel"}, {HospitalID: "HP4", Chaincode: "regionalCC1"}})
+	if err != nil || len(changes) != 1 || changes[0].HospitalID != "HP4" {
+		t.Fatalf("expected the merge to add HP4 only, got %+v %v", changes, err)
+	}
+
+	// the edits are in the file and do not count as a change to reload
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "hospitalID,chaincodeName,channel\nHP1,regionalCC3,\nHP2,regionalCC2,region2channel\nHP4,regionalCC1,\n"
+	if string(data) != expected {
+		t.Errorf("unexpected index file:\n%s", data)
+	}
+	if modified, err := index.Modified(); err != nil || modified {
+		t.Errorf("expected the index's own write not to count as a modification, got %v %v", modified, err)
+	}
+	reopened, err := Open(path)
+	if err != nil || len(Diff(index.Entries(), reopened.Entries())) != 0 {
+		t.Errorf("the written index reads back differently: %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := writeIndex(t, "HP1,regionalCC1\n")
+	index, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan []Change, 1)
+	go index.Watch(ctx, 10*time.Millisecond, func(changes []Change, err error) {
+		if err != nil {
+			t.Errorf("unexpected reload error: %v", err)
+		}
+		reloads <- changes
+	})
+
+	replace(t, path, "HP1,regionalCC1\nHP2,regionalCC2\n")
+	select {
+	case changes := <-reloads:
+		if len(changes) != 1 || changes[0].HospitalID != "HP2" || changes[0].From != nil {
+			t.Errorf("expected HP2 added, got %+v", changes)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the changed index was not reloaded")
+	}
+	if _, ok := index.Lookup("HP2"); !ok {
+		t.Errorf("HP2 is not routed after the reload")
+	}
+}
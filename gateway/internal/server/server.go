@@ -3,14 +3,25 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"gateway/internal/fabric"
 	"gateway/internal/handlers"
+	"gateway/internal/index"
+	"gateway/internal/store"
 )
 
-// Start initializes and starts the HTTP server
-func Start() error {
+// Start initializes and starts the HTTP server using ledger to reach the Fabric network, db to
+// keep the transactions it submits, hospitals and routing to find the chaincode serving a hospital
+// and admin to manage the hospital index
+func Start(ledger fabric.Ledger, db *store.Store, hospitals *index.Index, routing *handlers.Routing, admin *handlers.IndexAdmin) error {
 	// Register HTTP handlers
-	http.HandleFunc("/readPP/", handlers.ReadPPHandler)
+	handlers.RegisterV1(http.DefaultServeMux, ledger, db, routing)
+	handlers.RegisterAdmin(http.DefaultServeMux, admin)
+	http.HandleFunc("/readPP/", handlers.ReadPPHandler())
+	http.HandleFunc("/listPP/", handlers.ListPPHandler(ledger, hospitals))
+	http.HandleFunc("/historyPP/", handlers.HistoryPPHandler(ledger, hospitals))
+	http.HandleFunc("/verify/", handlers.VerifyHandler(ledger, hospitals, &http.Client{Timeout: 30 * time.Second}))
 
 	// Define the port number
 	port := ":8080"
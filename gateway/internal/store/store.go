@@ -0,0 +1,216 @@
+// Package store is the gateway's embedded key-value store. Values are JSON documents kept in named
+// buckets in memory and persisted to an append-only log file, one JSON line per write, which is
+// replayed and compacted when the store is opened.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// compactThreshold is how many superseded lines the log may hold before Open rewrites it
+const compactThreshold = 1000
+
+// logEntry is one line of the log. A nil Value deletes the key.
+type logEntry struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
+
+// Store is a set of buckets of JSON values backed by a log file. It is safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Open loads the store kept at path, creating the file if needed. A line left incomplete by a crash
+// is dropped.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+	lines, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if lines-s.size() > compactThreshold {
+		err = s.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the log file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Get decodes the value of key in bucket into v and reports whether there was one
+func (s *Store) Get(bucket string, key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	value, ok := s.buckets[bucket][key]
+	s.mu.Unlock()
+
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// Put stores v as the value of key in bucket and syncs it to disk
+func (s *Store) Put(bucket string, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = s.append(logEntry{Bucket: bucket, Key: key, Value: value})
+	if err != nil {
+		return err
+	}
+	s.set(bucket, key, value)
+	return nil
+}
+
+// Delete removes key from bucket
+func (s *Store) Delete(bucket string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return nil
+	}
+	err := s.append(logEntry{Bucket: bucket, Key: key})
+	if err != nil {
+		return err
+	}
+	delete(s.buckets[bucket], key)
+	return nil
+}
+
+// Keys returns the keys of bucket, in no particular order
+func (s *Store) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *Store) append(entry logEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write to store %s: %v", s.path, err)
+	}
+	return s.file.Sync()
+}
+
+func (s *Store) set(bucket string, key string, value json.RawMessage) {
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	s.buckets[bucket][key] = value
+}
+
+func (s *Store) size() int {
+	size := 0
+	for _, bucket := range s.buckets {
+		size += len(bucket)
+	}
+	return size
+}
+
+// load replays the log and returns how many lines it holds, truncating an incomplete last line
+func (s *Store) load() (int, error) {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open store %s: %v", s.path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	lines, offset := 0, int64(0)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return lines, file.Truncate(offset)
+			}
+			return lines, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read store %s: %v", s.path, err)
+		}
+
+		var entry logEntry
+		err = json.Unmarshal(bytes.TrimSpace(line), &entry)
+		if err != nil {
+			return 0, fmt.Errorf("store %s is corrupt at offset %d: %v", s.path, offset, err)
+		}
+		if entry.Value == nil {
+			delete(s.buckets[entry.Bucket], entry.Key)
+		} else {
+			s.set(entry.Bucket, entry.Key, entry.Value)
+		}
+		lines++
+		offset += int64(len(line))
+	}
+}
+
+// compact rewrites the log with one line per live key, replacing the old file atomically
+func (s *Store) compact() error {
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".compact-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact store %s: %v", s.path, err)
+	}
+	defer os.Remove(temp.Name())
+
+	writer := bufio.NewWriter(temp)
+	for bucket, values := range s.buckets {
+		for key, value := range values {
+			line, err := json.Marshal(logEntry{Bucket: bucket, Key: key, Value: value})
+			if err != nil {
+				temp.Close()
+				return err
+			}
+			writer.Write(append(line, '\n'))
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to compact store %s: %v", s.path, err)
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to compact store %s: %v", s.path, err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to compact store %s: %v", s.path, err)
+	}
+	return os.Rename(temp.Name(), s.path)
+}
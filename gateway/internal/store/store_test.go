@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// record is a value kept in the tests' stores
+type record struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// reopen closes s and opens the store at its path again
+func reopen(t *testing.T, s *Store) *Store {
+	t.Helper()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(s.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reopened.Close() })
+	return reopened
+}
+
+// lineCount returns the number of lines in the log at path
+func lineCount(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestStoreReplaysLog(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "gateway.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, put := range []struct {
+		bucket string
+		key    string
+		value  record
+	}{
+		{"a", "k1", record{Name: "first", Count: 1}},
+		{"a", "k2", record{Name: "second", Count: 2}},
+		{"a", "k1", record{Name: "first", Count: 3}},
+		{"b", "k1", record{Name: "other bucket"}},
+	} {
+		if err := s.Put(put.bucket, put.key, put.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete("a", "k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("a", "missing"); err != nil {
+		t.Fatalf("deleting a missing key failed: %v", err)
+	}
+
+	s = reopen(t, s)
+	var value record
+	if found, err := s.Get("a", "k1", &value); !found || err != nil || value.Count != 3 {
+		t.Errorf("expected the last write of a/k1, got %+v %v %v", value, found, err)
+	}
+	if found, _ := s.Get("a", "k2", &value); found {
+		t.Errorf("the deleted a/k2 came back")
+	}
+	if found, _ := s.Get("b", "k1", &value); !found || value.Name != "other bucket" {
+		t.Errorf("expected b/k1 apart from a/k1, got %+v", value)
+	}
+	keys := s.Keys("a")
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "k1" || len(s.Keys("none")) != 0 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
+
+func TestStoreDropsTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("a", "k1", record{Name: "kept"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// a crash cut the next write short
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"bucket":"a","key":"k2","val`)
+	file.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("a truncated last line must not stop the store from opening: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	var value record
+	if found, _ := s.Get("a", "k1", &value); !found || value.Name != "kept" {
+		t.Errorf("expected a/k1 kept, got %+v", value)
+	}
+	if found, _ := s.Get("a", "k2", &value); found {
+		t.Errorf("the truncated write was applied")
+	}
+
+	// writes after the truncated line are read back on the next open
+	if err := s.Put("a", "k3", record{Name: "after"}); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, s)
+	if found, err := s.Get("a", "k3", &value); !found || err != nil || value.Name != "after" {
+		t.Errorf("expected the write after the truncated line, got %+v %v %v", value, found, err)
+	}
+}
+
+func TestStoreRefusesCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	err := os.WriteFile(path, []byte("{\"bucket\":\"a\",\"key\":\"k1\",\"value\":{}}\nnot json\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("expected a corrupt line in the middle of the log to be refused, got %v", err)
+	}
+}
+
+func TestStoreCompacts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= compactThreshold; i++ {
+		if err := s.Put("a", "counter", record{Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := s.Put("b", strconv.Itoa(i), record{Count: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete("b", "0"); err != nil {
+		t.Fatal(err)
+	}
+	if lines := lineCount(t, path); lines != compactThreshold+5 {
+		t.Fatalf("expected every write logged, got %d lines", lines)
+	}
+
+	s = reopen(t, s)
+	if lines := lineCount(t, path); lines != 3 {
+		t.Errorf("expected the log compacted to its 3 live keys, got %d lines", lines)
+	}
+	var value record
+	if found, _ := s.Get("a", "counter", &value); !found || value.Count != compactThreshold {
+		t.Errorf("expected the last counter after compaction, got %+v", value)
+	}
+	if found, _ := s.Get("b", "0", &value); found {
+		t.Errorf("a deleted key came back after compaction")
+	}
+	matches, _ := filepath.Glob(path + ".compact-*")
+	if len(matches) != 0 {
+		t.Errorf("compaction left %v behind", matches)
+	}
+
+	// the compacted log takes writes and replays them
+	if err := s.Put("b", "3", record{Count: 3}); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, s)
+	if keys := s.Keys("b"); len(keys) != 3 {
+		t.Errorf("expected 3 keys in b after reopening the compacted log, got %v", keys)
+	}
+}
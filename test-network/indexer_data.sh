@@ -2,6 +2,9 @@
 
 echo "[ INDEXER INITIATE DATA ]"
 
+. ./region_channels.sh
+. ./seed_transient.sh
+
 # Check if the correct number of arguments is provided
 if [ "$#" -ne 1 ]; then
     echo "Usage: $0 <total_num>"
@@ -21,24 +24,27 @@ peer chaincode invoke \
     --ordererTLSHostnameOverride orderer.example.com \
     --tls \
     --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" \
-    -C mychannel \
+    -C $(region_channel 1) \
     -n regionalCC1 \
-    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC1\"]}"
+    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC1\"]}" \
+    --transient "$(seed_transient 1)"
 
 peer chaincode invoke \
     -o localhost:7050 \
     --ordererTLSHostnameOverride orderer.example.com \
     --tls \
     --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" \
-    -C mychannel \
+    -C $(region_channel 2) \
     -n regionalCC2 \
-    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC2\"]}"
+    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC2\"]}" \
+    --transient "$(seed_transient 2)"
 
 peer chaincode invoke \
     -o localhost:7050 \
     --ordererTLSHostnameOverride orderer.example.com \
     --tls \
     --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" \
-    -C mychannel \
+    -C $(region_channel 3) \
     -n regionalCC3 \
-    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC3\"]}"
\ No newline at end of file
+    -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC3\"]}" \
+    --transient "$(seed_transient 3)"
\ No newline at end of file
@@ -12,8 +12,10 @@ fi
 hospitalID=$1
 policyID=$2
 
-# Look up the chaincode name based on the hospitalID
+# Look up the chaincode name and channel based on the hospitalID
 chaincodeName=$(awk -F',' -v id="$hospitalID" '$1==id {print $2}' hospital_chaincode_mapping.csv)
+channel=$(awk -F',' -v id="$hospitalID" '$1==id {print $3}' hospital_chaincode_mapping.csv)
+channel=${channel:-mychannel}
 
 # Check if the hospitalID is valid
 if [ -z "$chaincodeName" ]; then
@@ -31,8 +33,15 @@ if [ -z "$chaincodeName" ]; then
     exit 1
 fi
 
+# Read as the region's doctor, whose role attribute the region's records list in authRoles
+region=${chaincodeName#regionalCC}
+export CORE_PEER_MSPCONFIGPATH=${PWD}/organizations/peerOrganizations/org1.example.com/users/DoctorReg${region}@org1.example.com/msp
+
+# InitLedger seeds the region's records under the reserved "seed" hospital, e.g. region1:seed:pc7
+assetID="region${region}:seed:pc$policyID"
+
 # Construct the query command
-queryCommand="peer chaincode query -C mychannel -n $chaincodeName -c '{\"Args\":[\"ReadAsset\", \"pc$policyID\"]}'"
+queryCommand="peer chaincode query -C $channel -n $chaincodeName -c '{\"Args\":[\"ReadAsset\", \"$assetID\"]}'"
 
 # Execute the query command
 response=$(eval $queryCommand 2>&1)  # Capture both stdout and stderr
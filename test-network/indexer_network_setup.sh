@@ -6,9 +6,20 @@ cd ../test-network
 ./network.sh down
 ./network.sh up createChannel -c mychannel -ca
 
-./network.sh deployCC -ccn regionalCC1 -ccp ../crosschain/regional1 -ccl go -ccep "OR('Org1MSP.peer','Org2MSP.peer')"
-./network.sh deployCC -ccn regionalCC2 -ccp ../crosschain/regional2 -ccl go -ccep "OR('Org1MSP.peer','Org2MSP.peer')"
-./network.sh deployCC -ccn regionalCC3 -ccp ../crosschain/regional3 -ccl go -ccep "OR('Org1MSP.peer','Org2MSP.peer')"
+# Regions configured onto their own channel get it created here
+. ./region_channels.sh
+for region in 1 2 3; do
+    channel=$(region_channel ${region})
+    if [ "${channel}" != "mychannel" ]; then
+        ./network.sh createChannel -c ${channel}
+    fi
+done
+
+# Every region runs the same chaincode, configured per region from ../crosschain/regional/config
+# and keeping patient-identifying fields in the collection from ../crosschain/regional/collections
+for region in 1 2 3; do
+    ./network.sh deployCC -c $(region_channel ${region}) -ccn regionalCC${region} -ccp ../crosschain/regional -ccl go -ccep "OR('Org1MSP.peer','Org2MSP.peer')" -cccg ../crosschain/regional/collections/region${region}.json
+done
 
 export CORE_PEER_TLS_ENABLED=true
 export CORE_PEER_LOCALMSPID="Org1MSP"
@@ -16,4 +27,17 @@ export CORE_PEER_TLS_ROOTCERT_FILE=${PWD}/organizations/peerOrganizations/org1.e
 export CORE_PEER_MSPCONFIGPATH=${PWD}/organizations/peerOrganizations/org1.example.com/users/Admin@org1.example.com/msp
 export CORE_PEER_ADDRESS=localhost:7051
 
+# Give each deployed regional chaincode its region identity and seed profile
+for region in 1 2 3; do
+    peer chaincode invoke \
+        -o localhost:7050 \
+        --ordererTLSHostnameOverride orderer.example.com \
+        --tls \
+        --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" \
+        -C $(region_channel ${region}) \
+        -n regionalCC${region} \
+        --waitForEvent \
+        -c "$(jq -cn --arg config "$(cat ../crosschain/regional/config/region${region}.json)" '{Args: ["InitRegion", $config]}')"
+done
+
 peer lifecycle chaincode queryinstalled
\ No newline at end of file
@@ -2,6 +2,9 @@
 
 echo "[ INDEXER INITIATE DATA ]"
 
+. ./region_channels.sh
+. ./seed_transient.sh
+
 # Check if the correct number of arguments is provided
 if [ "$#" -ne 1 ]; then
     echo "Usage: $0 <num_assets_regionalCC1>"
@@ -12,4 +15,4 @@ fi
 num_assets_regionalCC1=$1
 
 # Invoke chaincode for each regionalCC with the specified number of assets
-peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C mychannel -n regionalCC1 -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC1\"]}"
\ No newline at end of file
+peer chaincode invoke -o localhost:7050 --ordererTLSHostnameOverride orderer.example.com --tls --cafile "${PWD}/organizations/ordererOrganizations/example.com/orderers/orderer.example.com/msp/tlscacerts/tlsca.example.com-cert.pem" -C $(region_channel 1) -n regionalCC1 -c "{\"Args\":[\"InitLedger\", \"$num_assets_regionalCC1\"]}" --transient "$(seed_transient 1)"
\ No newline at end of file
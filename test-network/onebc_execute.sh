@@ -1,5 +1,7 @@
 #!/bin/bash
 
+. ./region_channels.sh
+
 # Check if the correct number of arguments is provided
 if [ "$#" -ne 1 ]; then
     echo "Usage: $0 <policyID>"
@@ -12,8 +14,14 @@ policyID=$1
 # Capture the start time in nanoseconds
 startTime=$(gdate +%s%N)
 
+# Read as the region's doctor, whose role attribute the region's records list in authRoles
+export CORE_PEER_MSPCONFIGPATH=${PWD}/organizations/peerOrganizations/org1.example.com/users/DoctorReg1@org1.example.com/msp
+
+# InitLedger seeds the region's records under the reserved "seed" hospital, e.g. region1:seed:pc7
+assetID="region1:seed:pc$policyID"
+
 # Construct the query command
-queryCommand="peer chaincode query -C mychannel -n regionalCC1 -c '{\"Args\":[\"ReadAsset\", \"pc$policyID\"]}'"
+queryCommand="peer chaincode query -C $(region_channel 1) -n regionalCC1 -c '{\"Args\":[\"ReadAsset\", \"$assetID\"]}'"
 
 # Execute the query command
 response=$(eval $queryCommand 2>&1)  # Capture both stdout and stderr
@@ -0,0 +1,14 @@
+#!/bin/bash
+
+# Channel each region's regional chaincode (regionalCC<n>) is deployed on.
+# Every region shares mychannel unless REGION<n>_CHANNEL is set, e.g.
+#   REGION1_CHANNEL=region1channel REGION2_CHANNEL=region2channel ./indexer_network_setup.sh
+# Source this file from the other scripts; the setup, data and execute scripts must see the same values.
+
+DEFAULT_CHANNEL=mychannel
+
+# region_channel <n> prints the channel of region n
+region_channel() {
+    local var="REGION${1}_CHANNEL"
+    echo "${!var:-$DEFAULT_CHANNEL}"
+}
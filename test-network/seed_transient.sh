@@ -0,0 +1,12 @@
+#!/bin/bash
+
+# Transient map for the regional chaincode's InitLedger, which refuses to seed without a patient key
+# and a salt. Every seeded record is sealed under one fresh key for a synthetic patient, held in the
+# region's collection, and salted with a fresh random salt. Source this file from the data scripts.
+
+# seed_transient <n> prints the --transient JSON for seeding region n
+seed_transient() {
+    local patient_key="{\"ownerRef\":\"seed-region${1}-$(openssl rand -hex 8)\",\"key\":\"$(openssl rand -base64 32)\",\"custody\":\"collection\"}"
+    local seed_salt=$(openssl rand -hex 16)
+    echo "{\"patient_key\":\"$(echo -n "$patient_key" | base64 | tr -d '\n')\",\"seed_salt\":\"$(echo -n "$seed_salt" | base64 | tr -d '\n')\"}"
+}